@@ -0,0 +1,130 @@
+package twitch
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// TagsCapability adds metadata like badges, colors and emotes to messages
+	TagsCapability = "twitch.tv/tags"
+	// CommandsCapability enables twitch specific commands like NOTICE, USERSTATE, CLEARCHAT and RECONNECT
+	CommandsCapability = "twitch.tv/commands"
+	// MembershipCapability enables JOIN, PART and NAMES messages of other users, large channels can disable it to save bandwidth
+	MembershipCapability = "twitch.tv/membership"
+)
+
+// DefaultCapabilities capabilities requested when Client.Capabilities is not changed
+var DefaultCapabilities = []string{TagsCapability, CommandsCapability, MembershipCapability}
+
+// defaultCapabilityTimeout how long to wait for the answers to CAP REQ, unanswered capabilities count as rejected afterwards
+const defaultCapabilityTimeout = time.Second * 5
+
+// GrantedCapabilities returns the capabilities twitch acknowledged on the current connection
+func (c *Client) GrantedCapabilities() []string {
+	c.capabilitiesMtx.RLock()
+	defer c.capabilitiesMtx.RUnlock()
+
+	granted := make([]string, len(c.grantedCapabilities))
+	copy(granted, c.grantedCapabilities)
+
+	return granted
+}
+
+// hasCapability reports whether twitch granted capability on the current connection
+func (c *Client) hasCapability(capability string) bool {
+	c.capabilitiesMtx.RLock()
+	defer c.capabilitiesMtx.RUnlock()
+
+	for _, granted := range c.grantedCapabilities {
+		if granted == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// requestCapabilities sends one CAP REQ per capability, so twitch can reject them individually
+func (c *Client) requestCapabilities(conn net.Conn) {
+	c.capabilitiesMtx.Lock()
+	c.grantedCapabilities = nil
+	c.pendingCapabilities = len(c.Capabilities)
+	c.welcomed = false
+	c.capabilitiesMtx.Unlock()
+
+	for _, capability := range c.Capabilities {
+		conn.Write([]byte("CAP REQ :" + capability + "\r\n"))
+	}
+}
+
+// awaitCapabilities stops waiting for the answers to CAP REQ after capabilityTimeout, until done is closed
+// a server that never answers would otherwise keep conn from ever becoming ready
+func (c *Client) awaitCapabilities(conn net.Conn, done <-chan struct{}) {
+	timeout := time.NewTimer(c.capabilityTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-done:
+		return
+	case <-timeout.C:
+	}
+
+	c.capabilitiesMtx.Lock()
+	unanswered := c.pendingCapabilities
+	if unanswered > 0 {
+		c.pendingCapabilities = 0
+	}
+	welcomed := c.welcomed
+	c.capabilitiesMtx.Unlock()
+
+	if unanswered <= 0 {
+		return
+	}
+	c.log(LevelWarn, "no answer to CAP REQ, continuing without the capabilities", "unanswered", unanswered, "timeout", c.capabilityTimeout)
+	if welcomed {
+		c.ready(conn)
+	}
+}
+
+// negotiate tracks the welcome message and CAP ACK/NAK answers of a new connection
+// and reports whether the connection is ready to be used
+func (c *Client) negotiate(line string) bool {
+	c.capabilitiesMtx.Lock()
+	defer c.capabilitiesMtx.Unlock()
+
+	if strings.HasPrefix(line, ":tmi.twitch.tv 001") {
+		c.welcomed = true
+	}
+
+	if ack, capabilities, ok := parseCapabilityAnswer(line); ok {
+		c.pendingCapabilities--
+		if ack {
+			c.grantedCapabilities = append(c.grantedCapabilities, capabilities...)
+		}
+	}
+
+	return c.welcomed && c.pendingCapabilities <= 0
+}
+
+// parseCapabilityAnswer parses lines like ":tmi.twitch.tv CAP * ACK :twitch.tv/tags"
+func parseCapabilityAnswer(line string) (bool, []string, bool) {
+	spl := strings.SplitN(line, " :", 2)
+	if len(spl) != 2 {
+		return false, nil, false
+	}
+
+	fields := strings.Fields(spl[0])
+	if len(fields) != 4 || fields[1] != "CAP" {
+		return false, nil, false
+	}
+
+	switch fields[3] {
+	case "ACK":
+		return true, strings.Fields(spl[1]), true
+	case "NAK":
+		return false, strings.Fields(spl[1]), true
+	}
+
+	return false, nil, false
+}
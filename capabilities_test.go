@@ -0,0 +1,211 @@
+package twitch
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanRequestCustomCapabilities(t *testing.T) {
+	wait := make(chan struct{})
+	requested := make(chan string, 2)
+	var granted []string
+
+	host := startServer(t, nothingOnConnect, func(message string) {
+		if strings.HasPrefix(message, "CAP REQ") {
+			requested <- message
+		}
+	})
+
+	client := newTestClient(host)
+	client.Capabilities = []string{TagsCapability, CommandsCapability}
+	client.OnConnect(func() {
+		granted = client.GrantedCapabilities()
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire")
+	}
+
+	assertStringsEqual(t, "CAP REQ :"+TagsCapability, <-requested)
+	assertStringsEqual(t, "CAP REQ :"+CommandsCapability, <-requested)
+	assertStringSlicesEqual(t, []string{TagsCapability, CommandsCapability}, granted)
+}
+
+func TestConnectionWaitsForCapabilityAnswers(t *testing.T) {
+	wait := make(chan struct{})
+	capRequested := make(chan net.Conn)
+
+	host := "127.0.0.1:" + strconv.Itoa(startPort)
+	startPort++
+
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// a server that welcomes the client but leaves answering CAP REQ to the test
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		tp := textproto.NewReader(bufio.NewReader(conn))
+		for {
+			message, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(message, "NICK") {
+				fmt.Fprintf(conn, ":tmi.twitch.tv 001 justinfan123123 :Welcome, GLHF!\r\n")
+			}
+			if strings.HasPrefix(message, "CAP REQ") {
+				capRequested <- conn
+			}
+		}
+	}()
+
+	client := newTestClient(host)
+	client.TLS = false
+	client.Capabilities = []string{TagsCapability, MembershipCapability}
+	client.OnConnect(func() {
+		close(wait)
+	})
+
+	go client.Connect()
+
+	var conn net.Conn
+	for i := 0; i < 2; i++ {
+		select {
+		case conn = <-capRequested:
+		case <-time.After(time.Second * 3):
+			t.Fatal("no CAP REQ received")
+		}
+	}
+
+	fmt.Fprintf(conn, ":tmi.twitch.tv CAP * ACK :%s\r\n", TagsCapability)
+
+	select {
+	case <-wait:
+		t.Fatal("OnConnect fired before every CAP REQ was answered")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	fmt.Fprintf(conn, ":tmi.twitch.tv CAP * NAK :%s\r\n", MembershipCapability)
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire after CAP NAK")
+	}
+
+	assertStringSlicesEqual(t, []string{TagsCapability}, client.GrantedCapabilities())
+
+	client.Join("gempir")
+	if _, err := client.Userlist("gempir"); err == nil || !strings.Contains(err.Error(), MembershipCapability) {
+		t.Fatalf("expected membership error after CAP NAK, got %v", err)
+	}
+}
+
+func TestConnectionProceedsWithoutCapabilityAnswers(t *testing.T) {
+	wait := make(chan struct{})
+
+	host := "127.0.0.1:" + strconv.Itoa(startPort)
+	startPort++
+
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// a server that welcomes the client but never answers CAP REQ
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewReader(bufio.NewReader(conn))
+		for {
+			message, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(message, "NICK") {
+				fmt.Fprintf(conn, ":tmi.twitch.tv 001 justinfan123123 :Welcome, GLHF!\r\n")
+			}
+		}
+	}()
+
+	client := newTestClient(host)
+	client.TLS = false
+	client.capabilityTimeout = time.Millisecond * 100
+	client.OnConnect(func() {
+		close(wait)
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire without CAP answers")
+	}
+
+	assertIntsEqual(t, 0, len(client.GrantedCapabilities()))
+	assertStringsEqual(t, StateConnected.String(), client.State().String())
+}
+
+func TestCanReceiveMessagesWithoutTags(t *testing.T) {
+	testMessage := ":gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #pajlada :no tags here"
+
+	wait := make(chan struct{})
+	var received, channel, username string
+
+	host := startServer(t, postMessageOnConnect(testMessage), nothingOnMessage)
+	client := newTestClient(host)
+	client.Capabilities = []string{CommandsCapability}
+
+	client.OnNewMessage(func(ch string, user User, message Message) {
+		channel = ch
+		username = user.Username
+		received = message.Text
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message sent")
+	}
+
+	assertStringsEqual(t, "pajlada", channel)
+	assertStringsEqual(t, "gempir", username)
+	assertStringsEqual(t, "no tags here", received)
+}
+
+func TestUserlistRequiresMembership(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.Capabilities = []string{TagsCapability, CommandsCapability}
+	client.Join("gempir")
+
+	_, err := client.Userlist("gempir")
+	if err == nil || !strings.Contains(err.Error(), MembershipCapability) {
+		t.Fatalf("expected membership error, got %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+// Package chatlog writes the messages a twitch client receives to per-channel, per-day log files
+//
+//	logger := chatlog.NewLogger("/var/log/twitch", chatlog.FormatText)
+//	defer logger.Close()
+//	logger.Attach(client)
+//
+// Files are written to <dir>/<channel>/<date><extension>, like logs/gempir/2018-05-20.log.
+// A new file is started every day in UTC and whenever MaxSize is exceeded, with a counter in its name: 2018-05-20.1.log.
+// With Compress, files that were rotated are gzipped to 2018-05-20.log.gz,
+// as are the files of earlier days a previous run left behind once logging starts.
+package chatlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+const (
+	// defaultFlushInterval how often buffered lines are written to disk
+	defaultFlushInterval = time.Second
+	// dateLayout the date in the file names
+	dateLayout = "2006-01-02"
+)
+
+var (
+	// ErrClosed returned from Log after Close was called
+	ErrClosed = errors.New("chatlog: logger closed")
+
+	// ErrInvalidChannel returned from Log for messages whose channel can not be used as a directory name
+	ErrInvalidChannel = errors.New("chatlog: invalid channel")
+)
+
+// DefaultTypes the message types logged unless Logger.Types is changed
+var DefaultTypes = []twitch.MessageType{twitch.PRIVMSG, twitch.CLEARCHAT, twitch.USERNOTICE, twitch.NOTICE, twitch.ROOMSTATE}
+
+// Logger writes messages to log files, set up with NewLogger
+type Logger struct {
+	// Dir the directory containing a directory per channel
+	Dir    string
+	Format Format
+	// MaxSize starts a new file once the current one reaches this many bytes, 0 only rotates daily
+	MaxSize int64
+	// Compress gzips files once they were rotated, and the files of earlier days left in Dir when logging starts
+	Compress bool
+	// FlushInterval how often buffered lines are written to disk, so a crash loses at most this much
+	FlushInterval time.Duration
+	// Types the message types to log, messages without a channel, like whispers, are never logged
+	Types []twitch.MessageType
+
+	mtx    sync.Mutex
+	files  map[string]*logFile
+	err    error
+	closed bool
+	// flushing is closed to stop the flush loop
+	flushing    chan struct{}
+	compressing sync.WaitGroup
+	now         func() time.Time
+}
+
+// logFile the file a channel is currently logged to
+type logFile struct {
+	path string
+	date string
+	file *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+// NewLogger creates a Logger writing to dir in format, with daily rotation, compression and a flush every second
+func NewLogger(dir string, format Format) *Logger {
+	return &Logger{
+		Dir:           dir,
+		Format:        format,
+		Compress:      true,
+		FlushInterval: defaultFlushInterval,
+		Types:         DefaultTypes,
+		files:         map[string]*logFile{},
+		now:           time.Now,
+	}
+}
+
+// Attach logs every message client receives
+// It uses client.OnNewRawMessage, call Log from your own callback instead if you need that callback
+func (l *Logger) Attach(client *twitch.Client) {
+	client.OnNewRawMessage(func(message *twitch.RawMessage) {
+		l.Log(message)
+	})
+}
+
+// Log writes message to the file of its channel, if its type is one of Types
+// The first error is also kept for Err, as Attach has no way to report it
+func (l *Logger) Log(message *twitch.RawMessage) error {
+	channel := message.Channel()
+	if channel == "" || !l.logs(message.Type()) {
+		return nil
+	}
+	if strings.ContainsAny(channel, `/\`) || channel == "." || channel == ".." {
+		return l.fail(ErrInvalidChannel)
+	}
+
+	now := l.now()
+	line, err := l.Format.format(message, now)
+	if err != nil {
+		return l.fail(err)
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	if l.flushing == nil {
+		l.flushing = make(chan struct{})
+		go l.flushLoop(l.flushing)
+		if l.Compress {
+			l.compressLeftovers(now)
+		}
+	}
+
+	f, err := l.file(channel, now)
+	if err != nil {
+		return l.failLocked(err)
+	}
+
+	n, err := f.w.Write(append(line, '\n'))
+	f.size += int64(n)
+	if err != nil {
+		return l.failLocked(err)
+	}
+	return nil
+}
+
+// Flush writes every buffered line to disk
+func (l *Logger) Flush() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.flushLocked()
+}
+
+// Close flushes and closes every file, and waits for running compressions
+func (l *Logger) Close() error {
+	l.mtx.Lock()
+	if l.closed {
+		l.mtx.Unlock()
+		return nil
+	}
+	l.closed = true
+	if l.flushing != nil {
+		close(l.flushing)
+	}
+
+	var err error
+	for channel, f := range l.files {
+		if closeErr := f.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(l.files, channel)
+	}
+	l.mtx.Unlock()
+
+	l.compressing.Wait()
+	return err
+}
+
+// Err returns the first error writing the logs
+func (l *Logger) Err() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.err
+}
+
+func (l *Logger) logs(messageType twitch.MessageType) bool {
+	for _, t := range l.Types {
+		if t == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) fail(err error) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.failLocked(err)
+}
+
+func (l *Logger) failLocked(err error) error {
+	if l.err == nil {
+		l.err = err
+	}
+	return err
+}
+
+// file returns the file to write the next line of channel to, rotating the current one if needed
+func (l *Logger) file(channel string, now time.Time) (*logFile, error) {
+	date := now.UTC().Format(dateLayout)
+
+	f, ok := l.files[channel]
+	if ok && f.date == date && (l.MaxSize <= 0 || f.size < l.MaxSize) {
+		return f, nil
+	}
+	if ok {
+		delete(l.files, channel)
+		if err := l.rotate(f); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Join(l.Dir, channel)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := l.open(dir, date)
+	if err != nil {
+		return nil, err
+	}
+	l.files[channel] = f
+	return f, nil
+}
+
+// open opens the last file of date in dir for appending, or the next one if it is full
+func (l *Logger) open(dir, date string) (*logFile, error) {
+	for i := 0; ; i++ {
+		path := filepath.Join(dir, fileName(date, i, l.Format))
+		if _, err := os.Stat(path + ".gz"); err == nil {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err == nil && l.MaxSize > 0 && info.Size() >= l.MaxSize {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		f := &logFile{path: path, date: date, file: file, w: bufio.NewWriter(file)}
+		if info != nil {
+			f.size = info.Size()
+		}
+		return f, nil
+	}
+}
+
+// rotate closes f, and compresses it in the background with Compress
+func (l *Logger) rotate(f *logFile) error {
+	if err := f.close(); err != nil {
+		return err
+	}
+	if l.Compress {
+		l.compressAll(f.path)
+	}
+	return nil
+}
+
+// compressLeftovers gzips the uncompressed files of days before now in Dir, which a previous run did not get to rotate
+func (l *Logger) compressLeftovers(now time.Time) {
+	today := now.UTC().Format(dateLayout)
+
+	channels, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.failLocked(err)
+		}
+		return
+	}
+
+	var paths []string
+	for _, channel := range channels {
+		if !channel.IsDir() {
+			continue
+		}
+		dir := filepath.Join(l.Dir, channel.Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			l.failLocked(err)
+			continue
+		}
+		for _, file := range files {
+			name := file.Name()
+			if _, ok := FormatFromPath(name); !ok || file.IsDir() || strings.HasSuffix(name, ".gz") {
+				continue
+			}
+			date := strings.SplitN(name, ".", 2)[0]
+			if _, err := time.Parse(dateLayout, date); err == nil && date < today {
+				paths = append(paths, filepath.Join(dir, name))
+			}
+		}
+	}
+	if len(paths) > 0 {
+		l.compressAll(paths...)
+	}
+}
+
+// compressAll gzips paths one after another in the background, Close waits for it
+func (l *Logger) compressAll(paths ...string) {
+	l.compressing.Add(1)
+	go func() {
+		defer l.compressing.Done()
+		for _, path := range paths {
+			if err := compress(path); err != nil {
+				l.fail(err)
+			}
+		}
+	}()
+}
+
+// flushLoop flushes every FlushInterval and rotates the files of channels that stayed quiet past midnight, until stop is closed
+func (l *Logger) flushLoop(stop <-chan struct{}) {
+	interval := l.FlushInterval
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		l.mtx.Lock()
+		if err := l.flushLocked(); err != nil {
+			l.failLocked(err)
+		}
+		date := l.now().UTC().Format(dateLayout)
+		for channel, f := range l.files {
+			if f.date != date {
+				delete(l.files, channel)
+				if err := l.rotate(f); err != nil {
+					l.failLocked(err)
+				}
+			}
+		}
+		l.mtx.Unlock()
+	}
+}
+
+func (l *Logger) flushLocked() error {
+	var err error
+	for _, f := range l.files {
+		if flushErr := f.w.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+func (f *logFile) close() error {
+	err := f.w.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// fileName returns the name of the i-th file of date: 2018-05-20.log, 2018-05-20.1.log, ...
+func fileName(date string, i int, format Format) string {
+	if i == 0 {
+		return date + format.Extension()
+	}
+	return fmt.Sprintf("%s.%d%s", date, i, format.Extension())
+}
+
+// compress gzips path to path.gz and removes path, a crash in between leaves path untouched
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if syncErr := dst.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
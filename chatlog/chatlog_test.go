@@ -0,0 +1,321 @@
+package chatlog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+const (
+	testPRIVMSG    = "@badges=subscriber/6;color=#FF0000;display-name=Redflamingo13;emotes=;id=2a31a9df;mod=0;room-id=11148817;tmi-sent-ts=1526817600000;user-id=78424343 :redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :Thrashh5, FeelsWayTooAmazingMan kinda"
+	testAction     = "@badges=;color=#FF0000;display-name=Redflamingo13;emotes=;id=9f7b3403;mod=0;room-id=11148817;tmi-sent-ts=1526817603000;user-id=78424343 :redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :\u0001ACTION waves\u0001"
+	testTimeout    = "@ban-duration=600;room-id=11148817;target-user-id=78424343;tmi-sent-ts=1526817601000 :tmi.twitch.tv CLEARCHAT #pajlada :redflamingo13"
+	testUSERNOTICE = `@display-name=gempir;login=gempir;msg-id=resub;msg-param-cumulative-months=12;room-id=11148817;system-msg=gempir\ssubscribed\sfor\s12\smonths!;tmi-sent-ts=1526817602000;user-id=77829817 :tmi.twitch.tv USERNOTICE #pajlada :still here`
+	testWHISPER    = "@badges=;color=#00FF7F;display-name=Danielps1;emotes=;message-id=20;thread-id=32591953_77829817;turbo=0;user-id=32591953;user-type= :danielps1!danielps1@danielps1.tmi.twitch.tv WHISPER gempir :i like memes"
+	testUSERSTATE  = "@badge-info=;badges=;color=;display-name=gempir;emote-sets=0;mod=0;subscriber=0;user-type= :tmi.twitch.tv USERSTATE #pajlada"
+)
+
+// testClock a clock tests can move forward
+type testClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *testClock) Set(now time.Time) {
+	c.mtx.Lock()
+	c.now = now
+	c.mtx.Unlock()
+}
+
+func newTestLogger(t *testing.T, format Format) (*Logger, *testClock) {
+	dir, err := ioutil.TempDir("", "chatlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	clock := &testClock{now: time.Date(2018, 5, 20, 12, 0, 0, 0, time.UTC)}
+	logger := NewLogger(dir, format)
+	logger.now = clock.Now
+
+	return logger, clock
+}
+
+func logLines(t *testing.T, logger *Logger, lines ...string) {
+	for _, line := range lines {
+		if err := logger.Log(twitch.ParseRawMessage(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	if strings.HasSuffix(path, ".gz") {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func assertFiles(t *testing.T, dir string, expected ...string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected files %v, got %v", expected, names)
+	}
+}
+
+func TestCanWriteTextLog(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatText)
+	logLines(t, logger, testPRIVMSG, testTimeout, testUSERNOTICE, testWHISPER, testUSERSTATE)
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[2018-05-20 12:00:00] #pajlada redflamingo13: Thrashh5, FeelsWayTooAmazingMan kinda\n" +
+		"[2018-05-20 12:00:01] #pajlada redflamingo13 has been timed out for 600 seconds\n" +
+		"[2018-05-20 12:00:02] #pajlada USERNOTICE gempir: gempir subscribed for 12 months! still here\n"
+
+	assertFiles(t, logger.Dir, "pajlada")
+	if content := readFile(t, filepath.Join(logger.Dir, "pajlada", "2018-05-20.log")); content != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, content)
+	}
+}
+
+func TestTextLogKeepsActions(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatText)
+	logLines(t, logger, testAction)
+	logger.Close()
+
+	path := filepath.Join(logger.Dir, "pajlada", "2018-05-20.log")
+	expected := "[2018-05-20 12:00:03] #pajlada redflamingo13: /me waves\n"
+	if content := readFile(t, path); content != expected {
+		t.Errorf("expected %q, got %q", expected, content)
+	}
+
+	reader, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	entry, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Action || entry.Text != "waves" || entry.Username != "redflamingo13" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.String()+"\n" != expected {
+		t.Errorf("expected %q, got %q", expected, entry.String())
+	}
+}
+
+func TestCanWriteJSONLog(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatJSON)
+	logLines(t, logger, testPRIVMSG, testUSERNOTICE)
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, filepath.Join(logger.Dir, "pajlada", "2018-05-20.jsonl"))), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+
+	var entry Entry
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Type != "USERNOTICE" || entry.Username != "gempir" || entry.Text != "still here" || entry.Tags["msg-id"] != "resub" || entry.Raw != testUSERNOTICE {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if !entry.Time.Equal(time.Date(2018, 5, 20, 12, 0, 2, 0, time.UTC)) {
+		t.Errorf("unexpected time %s", entry.Time)
+	}
+}
+
+func TestCanWriteRawLog(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatRaw)
+	logLines(t, logger, testPRIVMSG, testTimeout)
+	logger.Close()
+
+	content := readFile(t, filepath.Join(logger.Dir, "pajlada", "2018-05-20.irc"))
+	if content != testPRIVMSG+"\n"+testTimeout+"\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestCanRotateBySize(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatRaw)
+	logger.MaxSize = int64(len(testPRIVMSG)) * 2
+	logLines(t, logger, testPRIVMSG, testPRIVMSG, testPRIVMSG)
+	logger.Close()
+
+	dir := filepath.Join(logger.Dir, "pajlada")
+	assertFiles(t, dir, "2018-05-20.1.irc", "2018-05-20.irc.gz")
+	assertStringsContainLines(t, readFile(t, filepath.Join(dir, "2018-05-20.irc.gz")), 2)
+	assertStringsContainLines(t, readFile(t, filepath.Join(dir, "2018-05-20.1.irc")), 1)
+}
+
+func TestCanRotateByDate(t *testing.T) {
+	logger, clock := newTestLogger(t, FormatText)
+	logger.Compress = false
+	logLines(t, logger, testPRIVMSG)
+	clock.Set(time.Date(2018, 5, 21, 0, 0, 1, 0, time.UTC))
+	logLines(t, logger, testPRIVMSG)
+	logger.Close()
+
+	assertFiles(t, filepath.Join(logger.Dir, "pajlada"), "2018-05-20.log", "2018-05-21.log")
+}
+
+func TestCanContinueLogAfterRestart(t *testing.T) {
+	logger, clock := newTestLogger(t, FormatRaw)
+	logLines(t, logger, testPRIVMSG)
+	logger.Close()
+
+	restarted := NewLogger(logger.Dir, FormatRaw)
+	restarted.now = clock.Now
+	logLines(t, restarted, testTimeout)
+	restarted.Close()
+
+	content := readFile(t, filepath.Join(logger.Dir, "pajlada", "2018-05-20.irc"))
+	if content != testPRIVMSG+"\n"+testTimeout+"\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestCompressesFilesOfEarlierDaysOnStart(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatText)
+	dir := filepath.Join(logger.Dir, "pajlada")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// left behind by a run that stopped before midnight
+	for _, name := range []string{"2018-05-18.log", "2018-05-19.log", "2018-05-19.1.log", "2018-05-20.log", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logLines(t, logger, testPRIVMSG)
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFiles(t, dir, "2018-05-18.log.gz", "2018-05-19.1.log.gz", "2018-05-19.log.gz", "2018-05-20.log", "notes.txt")
+	if content := readFile(t, filepath.Join(dir, "2018-05-19.1.log.gz")); content != "2018-05-19.1.log\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+	assertStringsContainLines(t, readFile(t, filepath.Join(dir, "2018-05-20.log")), 2)
+}
+
+func TestFlushesOnInterval(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatRaw)
+	logger.FlushInterval = time.Millisecond * 10
+	defer logger.Close()
+	logLines(t, logger, testPRIVMSG)
+
+	path := filepath.Join(logger.Dir, "pajlada", "2018-05-20.irc")
+	deadline := time.Now().Add(time.Second * 3)
+	for readFile(t, path) != testPRIVMSG+"\n" {
+		if time.Now().After(deadline) {
+			t.Fatal("line was not flushed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestRejectsInvalidChannel(t *testing.T) {
+	logger, _ := newTestLogger(t, FormatRaw)
+	defer logger.Close()
+
+	err := logger.Log(twitch.ParseRawMessage(":gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #.. :hi"))
+	if err != ErrInvalidChannel {
+		t.Errorf("expected ErrInvalidChannel, got %v", err)
+	}
+	if logger.Err() != ErrInvalidChannel {
+		t.Errorf("expected Err to return ErrInvalidChannel, got %v", logger.Err())
+	}
+}
+
+func TestCanAttachToClient(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	logger, _ := newTestLogger(t, FormatRaw)
+
+	client := twitch.NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+	client.Join("pajlada")
+	logger.Attach(client)
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	if _, err := server.WaitFor("JOIN #pajlada", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	server.Send(testPRIVMSG)
+
+	path := filepath.Join(logger.Dir, "pajlada", "2018-05-20.irc")
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		logger.Flush()
+		if content, err := ioutil.ReadFile(path); err == nil && strings.Contains(string(content), testPRIVMSG) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message was not logged")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	logger.Close()
+}
+
+func assertStringsContainLines(t *testing.T, content string, lines int) {
+	t.Helper()
+	if n := strings.Count(content, "\n"); n != lines {
+		t.Errorf("expected %d lines, got %d", lines, n)
+	}
+}
@@ -0,0 +1,149 @@
+package chatlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+// Format how messages are written to the log files
+type Format int
+
+const (
+	// FormatText one readable line per message: [2006-01-02 15:04:05] #channel username: text
+	// Events other than timeouts and room settings start with their type, like USERNOTICE username: text
+	// and /me actions with /me, like username: /me text
+	FormatText Format = iota
+	// FormatJSON one Entry per line, encoded as JSON
+	FormatJSON
+	// FormatRaw the IRC line as it was received
+	FormatRaw
+)
+
+// Extension returns the file extension of the format, including the dot
+func (f Format) Extension() string {
+	switch f {
+	case FormatJSON:
+		return ".jsonl"
+	case FormatRaw:
+		return ".irc"
+	}
+	return ".log"
+}
+
+func (f Format) String() string {
+	switch f {
+	case FormatText:
+		return "text"
+	case FormatJSON:
+		return "json"
+	case FormatRaw:
+		return "raw"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+const (
+	// textTime the layout of the time in FormatText
+	textTime = "2006-01-02 15:04:05"
+	// textAction marks the text of /me actions in FormatText, twitch turns /me into an action so no text starts with it
+	textAction = "/me "
+)
+
+// Entry one message of a FormatJSON log
+type Entry struct {
+	Time        time.Time         `json:"time"`
+	Channel     string            `json:"channel"`
+	Type        string            `json:"type"`
+	Username    string            `json:"username,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	UserID      string            `json:"userID,omitempty"`
+	Text        string            `json:"text"`
+	Action      bool              `json:"action,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Raw         string            `json:"raw"`
+}
+
+// NewEntry decodes message into an Entry, received is used as its time if twitch did not send one
+func NewEntry(message *twitch.RawMessage, received time.Time) Entry {
+	channel, user, decoded := twitch.ParseMessage(message.Raw())
+
+	entry := Entry{
+		Time:        decoded.Time,
+		Channel:     channel,
+		Type:        decoded.Type.String(),
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		UserID:      user.UserID,
+		Text:        message.Text(),
+		Action:      decoded.Action,
+		Tags:        decoded.Tags,
+		Raw:         message.Raw(),
+	}
+	if entry.Time.IsZero() {
+		entry.Time = received
+	}
+	if decoded.Type == twitch.UNSET {
+		entry.Type = message.Command()
+	}
+	return entry
+}
+
+// format returns message as one line in f, without the line ending
+func (f Format) format(message *twitch.RawMessage, received time.Time) ([]byte, error) {
+	switch f {
+	case FormatRaw:
+		return []byte(message.Raw()), nil
+	case FormatJSON:
+		return json.Marshal(NewEntry(message, received))
+	}
+
+	sent := message.Time()
+	if sent.IsZero() {
+		sent = received
+	}
+	return []byte(fmt.Sprintf("[%s] #%s %s", sent.UTC().Format(textTime), message.Channel(), describe(message))), nil
+}
+
+// describe returns what happened in message, as written after the channel in FormatText
+func describe(message *twitch.RawMessage) string {
+	switch message.Type() {
+	case twitch.PRIVMSG:
+		if message.Action() {
+			return message.Username() + ": " + textAction + message.Text()
+		}
+		return message.Username() + ": " + message.Text()
+	case twitch.CLEARCHAT:
+		target := message.Text()
+		if target == "" {
+			return "chat has been cleared"
+		}
+		if duration, ok := message.Tag("ban-duration"); ok {
+			return fmt.Sprintf("%s has been timed out for %s seconds", target, duration)
+		}
+		return target + " has been banned"
+	case twitch.USERNOTICE:
+		text, _ := message.Tag("system-msg")
+		if message.Text() != "" {
+			text += " " + message.Text()
+		}
+		if login := message.Username(); login != "" {
+			return "USERNOTICE " + login + ": " + text
+		}
+		return "USERNOTICE " + text
+	case twitch.ROOMSTATE:
+		var settings []string
+		for key, value := range message.Tags() {
+			if key != "room-id" {
+				settings = append(settings, key+"="+value)
+			}
+		}
+		sort.Strings(settings)
+		return "room settings " + strings.Join(settings, " ")
+	}
+	return message.Command() + " " + message.Text()
+}
@@ -0,0 +1,233 @@
+package chatlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+// ErrInvalidLine returned from Reader.Next for a line that is not in the format of the log
+var ErrInvalidLine = errors.New("chatlog: invalid line")
+
+// FormatFromPath returns the format of a log file by its extension, ignoring a .gz suffix, ok is false for other files
+func FormatFromPath(path string) (format Format, ok bool) {
+	switch filepath.Ext(strings.TrimSuffix(path, ".gz")) {
+	case FormatText.Extension():
+		return FormatText, true
+	case FormatJSON.Extension():
+		return FormatJSON, true
+	case FormatRaw.Extension():
+		return FormatRaw, true
+	}
+	return FormatText, false
+}
+
+// Reader reads the entries of a log, written by Logger in any Format
+type Reader struct {
+	format  Format
+	scanner *bufio.Scanner
+	closers []io.Closer
+	// last the time of the previous entry, for raw lines without tmi-sent-ts
+	last time.Time
+}
+
+// NewReader creates a Reader for a log in format
+func NewReader(r io.Reader, format Format) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+
+	return &Reader{format: format, scanner: scanner}
+}
+
+// OpenFile opens a log file written by Logger, its format is taken from the extension and gzipped files are decompressed
+func OpenFile(path string) (*Reader, error) {
+	format, ok := FormatFromPath(path)
+	if !ok {
+		return nil, fmt.Errorf("chatlog: unknown format of %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// lines are logged on the date of the file name, the earliest time a raw line without tmi-sent-ts can have
+	date, _ := time.Parse(dateLayout, strings.SplitN(filepath.Base(path), ".", 2)[0])
+
+	if !strings.HasSuffix(path, ".gz") {
+		reader := NewReader(f, format)
+		reader.closers = []io.Closer{f}
+		reader.last = date
+		return reader, nil
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	reader := NewReader(zr, format)
+	reader.closers = []io.Closer{zr, f}
+	reader.last = date
+	return reader, nil
+}
+
+// Next returns the next entry, io.EOF once all were read
+// An entry of a FormatText log only has the time, channel, type, username, text and action,
+// its type is empty for events of logs written before the type was part of the line.
+// A raw line without tmi-sent-ts, like a ROOMSTATE, gets the time of the line before it,
+// or midnight of the date in the file name for the first lines opened with OpenFile
+func (r *Reader) Next() (Entry, error) {
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		switch r.format {
+		case FormatRaw:
+			entry := NewEntry(twitch.ParseRawMessage(line), r.last)
+			r.last = entry.Time
+			return entry, nil
+		case FormatJSON:
+			var entry Entry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				return Entry{}, ErrInvalidLine
+			}
+			return entry, nil
+		}
+		return parseTextLine(line)
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Entry{}, err
+	}
+	return Entry{}, io.EOF
+}
+
+// Close closes the file opened by OpenFile
+func (r *Reader) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// parseTextLine parses a line like "[2006-01-02 15:04:05] #channel username: text"
+func parseTextLine(line string) (Entry, error) {
+	if len(line) < len(textTime)+2 || line[0] != '[' || line[len(textTime)+1] != ']' {
+		return Entry{}, ErrInvalidLine
+	}
+	sent, err := time.Parse(textTime, line[1:len(textTime)+1])
+	if err != nil {
+		return Entry{}, ErrInvalidLine
+	}
+
+	rest := strings.TrimPrefix(line[len(textTime)+2:], " ")
+	if !strings.HasPrefix(rest, "#") {
+		return Entry{}, ErrInvalidLine
+	}
+	channel, text := rest[1:], ""
+	if i := strings.IndexByte(rest, ' '); i >= 0 {
+		channel, text = rest[1:i], rest[i+1:]
+	}
+
+	entry := Entry{Time: sent, Channel: channel, Text: text}
+	switch {
+	case isLoginPrefixed(text):
+		i := strings.Index(text, ": ")
+		entry.Type = twitch.PRIVMSG.String()
+		entry.Username = text[:i]
+		entry.Text = text[i+2:]
+		if strings.HasPrefix(entry.Text, textAction) {
+			entry.Action = true
+			entry.Text = entry.Text[len(textAction):]
+		}
+	case isCommandPrefixed(text):
+		entry.Type, entry.Text = text, ""
+		if i := strings.IndexByte(text, ' '); i >= 0 {
+			entry.Type, entry.Text = text[:i], text[i+1:]
+		}
+		if entry.Type == twitch.USERNOTICE.String() && isLoginPrefixed(entry.Text) {
+			i := strings.Index(entry.Text, ": ")
+			entry.Username = entry.Text[:i]
+			entry.Text = entry.Text[i+2:]
+		}
+	case text == "chat has been cleared" || strings.Contains(text, " has been timed out for ") || strings.HasSuffix(text, " has been banned"):
+		entry.Type = twitch.CLEARCHAT.String()
+		entry.Username = strings.SplitN(text, " ", 2)[0]
+		if text == "chat has been cleared" {
+			entry.Username = ""
+		}
+	case strings.HasPrefix(text, "room settings "):
+		entry.Type = twitch.ROOMSTATE.String()
+	}
+	return entry, nil
+}
+
+// isLoginPrefixed reports whether text starts with a twitch login followed by ": "
+func isLoginPrefixed(text string) bool {
+	i := strings.Index(text, ": ")
+	if i <= 0 {
+		return false
+	}
+	for _, r := range text[:i] {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// isCommandPrefixed reports whether text starts with an IRC command like USERNOTICE, as FormatText writes events
+func isCommandPrefixed(text string) bool {
+	command := strings.SplitN(text, " ", 2)[0]
+	if len(command) < 2 {
+		return false
+	}
+	for _, r := range command {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the entry the way FormatText writes it
+func (e Entry) String() string {
+	if e.Raw != "" {
+		line, err := FormatText.format(twitch.ParseRawMessage(e.Raw), e.Time)
+		if err == nil {
+			return string(line)
+		}
+	}
+
+	text := e.Text
+	switch e.Type {
+	case "", twitch.CLEARCHAT.String(), twitch.ROOMSTATE.String():
+	case twitch.PRIVMSG.String():
+		if e.Action {
+			text = textAction + text
+		}
+		text = e.Username + ": " + text
+	case twitch.USERNOTICE.String():
+		if e.Username != "" {
+			text = e.Username + ": " + text
+		}
+		text = e.Type + " " + text
+	default:
+		text = e.Type + " " + text
+	}
+	return fmt.Sprintf("[%s] #%s %s", e.Time.UTC().Format(textTime), e.Channel, text)
+}
@@ -0,0 +1,168 @@
+package chatlog
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+func readEntries(t *testing.T, reader *Reader) []Entry {
+	var entries []Entry
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestCanReadLogsOfEveryFormat(t *testing.T) {
+	for _, format := range []Format{FormatText, FormatJSON, FormatRaw} {
+		t.Run(format.String(), func(t *testing.T) {
+			logger, _ := newTestLogger(t, format)
+			logger.MaxSize = 1
+			logLines(t, logger, testPRIVMSG, testTimeout)
+			logger.Close()
+
+			dir := filepath.Join(logger.Dir, "pajlada")
+			var entries []Entry
+			for _, name := range []string{fileName("2018-05-20", 0, format) + ".gz", fileName("2018-05-20", 1, format)} {
+				reader, err := OpenFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries = append(entries, readEntries(t, reader)...)
+				reader.Close()
+			}
+
+			if len(entries) != 2 {
+				t.Fatalf("expected 2 entries, got %d", len(entries))
+			}
+			privmsg, timeout := entries[0], entries[1]
+			if privmsg.Type != "PRIVMSG" || privmsg.Channel != "pajlada" || privmsg.Username != "redflamingo13" || privmsg.Text != "Thrashh5, FeelsWayTooAmazingMan kinda" {
+				t.Errorf("unexpected entry %+v", privmsg)
+			}
+			if !privmsg.Time.Equal(time.Date(2018, 5, 20, 12, 0, 0, 0, time.UTC)) {
+				t.Errorf("unexpected time %s", privmsg.Time)
+			}
+			if timeout.Type != "CLEARCHAT" || timeout.Username != "redflamingo13" {
+				t.Errorf("unexpected entry %+v", timeout)
+			}
+			if privmsg.String() != "[2018-05-20 12:00:00] #pajlada redflamingo13: Thrashh5, FeelsWayTooAmazingMan kinda" {
+				t.Errorf("unexpected text %q", privmsg.String())
+			}
+		})
+	}
+}
+
+func TestCanReadTypeOfTextLogEvents(t *testing.T) {
+	lines := "[2018-05-20 12:00:02] #pajlada USERNOTICE gempir: gempir subscribed for 12 months! still here\n" +
+		"[2018-05-20 12:00:03] #pajlada NOTICE This room is now in slow mode.\n"
+	reader := NewReader(strings.NewReader(lines), FormatText)
+	entries := readEntries(t, reader)
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	usernotice, notice := entries[0], entries[1]
+	if usernotice.Type != "USERNOTICE" || usernotice.Username != "gempir" || usernotice.Text != "gempir subscribed for 12 months! still here" {
+		t.Errorf("unexpected entry %+v", usernotice)
+	}
+	if notice.Type != "NOTICE" || notice.Username != "" || notice.Text != "This room is now in slow mode." {
+		t.Errorf("unexpected entry %+v", notice)
+	}
+	if text := usernotice.String() + "\n" + notice.String() + "\n"; text != lines {
+		t.Errorf("expected:\n%s\ngot:\n%s", lines, text)
+	}
+}
+
+func TestTextLogEventsHaveNoType(t *testing.T) {
+	reader := NewReader(strings.NewReader("[2018-05-20 12:00:02] #pajlada gempir subscribed for 12 months! still here\n"), FormatText)
+	entries := readEntries(t, reader)
+
+	if len(entries) != 1 || entries[0].Type != "" || entries[0].Text != "gempir subscribed for 12 months! still here" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func TestRawLinesWithoutTimeGetTheTimeBeforeThem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chatlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "2018-05-20.irc")
+	content := "@room-id=11148817;slow=0 :tmi.twitch.tv ROOMSTATE #pajlada\n" + testPRIVMSG + "\n" + "@room-id=11148817;slow=10 :tmi.twitch.tv ROOMSTATE #pajlada\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	entries := readEntries(t, reader)
+
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, expected := range []time.Time{
+		time.Date(2018, 5, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2018, 5, 20, 12, 0, 0, 0, time.UTC),
+		time.Date(2018, 5, 20, 12, 0, 0, 0, time.UTC),
+	} {
+		if !entries[i].Time.Equal(expected) {
+			t.Errorf("entry %d: expected time %s, got %s", i, expected, entries[i].Time)
+		}
+	}
+}
+
+func TestCanSkipInvalidLines(t *testing.T) {
+	reader := NewReader(strings.NewReader("garbage\n[2018-05-20 12:00:00] #pajlada gempir: hi\n"), FormatText)
+
+	if _, err := reader.Next(); err != ErrInvalidLine {
+		t.Errorf("expected ErrInvalidLine, got %v", err)
+	}
+	entry, err := reader.Next()
+	if err != nil || entry.Text != "hi" {
+		t.Errorf("unexpected entry %+v, %v", entry, err)
+	}
+}
+
+func TestCanDetectFormatFromPath(t *testing.T) {
+	for path, expected := range map[string]Format{
+		"logs/gempir/2018-05-20.log":        FormatText,
+		"logs/gempir/2018-05-20.1.jsonl":    FormatJSON,
+		"logs/gempir/2018-05-20.irc.gz":     FormatRaw,
+		"logs/gempir/2018-05-20.1.jsonl.gz": FormatJSON,
+	} {
+		format, ok := FormatFromPath(path)
+		if !ok || format != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, format)
+		}
+	}
+
+	if _, ok := FormatFromPath("recording.txt"); ok {
+		t.Error("unknown extension was detected")
+	}
+}
+
+func TestEntryOfRawMessageUsesCommandForUnknownTypes(t *testing.T) {
+	entry := NewEntry(twitch.ParseRawMessage("@login=gempir;target-msg-id=1 :tmi.twitch.tv CLEARMSG #pajlada :hi"), time.Time{})
+
+	if entry.Type != "CLEARMSG" || entry.Channel != "pajlada" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
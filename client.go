@@ -2,14 +2,16 @@ package twitch
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/textproto"
+	"net/url"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +27,9 @@ var (
 
 	// ErrLoginAuthenticationFailed returned from Connect() when either the wrong or a malformed oauth token is used
 	ErrLoginAuthenticationFailed = errors.New("login authentication failed")
+
+	// ErrAnonymousClient returned from methods that write to chat when the client was created with NewAnonymousClient()
+	ErrAnonymousClient = errors.New("anonymous clients can not send messages")
 )
 
 // User data you receive from tmi
@@ -51,16 +56,46 @@ type Message struct {
 
 // Client client to control your connection and attach callbacks
 type Client struct {
+	// accessed atomically, first in the struct to keep it 64-bit aligned on 32-bit platforms
+	latency int64
+
 	IrcAddress             string
 	ircUser                string
 	ircToken               string
+	tokenProvider          TokenProvider
+	anonymous              bool
 	TLS                    bool
+	Transport              Transport
+	TLSConfig              *tls.Config
+	DialContext            DialContextFunc
+	Proxy                  *url.URL
+	SendPings              bool
+	PingInterval           time.Duration
+	PongTimeout            time.Duration
+	ReadTimeout            time.Duration
+	WriteTimeout           time.Duration
+	Capabilities           []string
+	MaxQueueSize           int
+	RateLimit              RateLimit
+	Fairness               FairnessPolicy
+	SplitMessages          *MessageSplitter
+	BypassDuplicates       bool
+	RespectSlowMode        bool
+	Recorder               *Recorder
+	SpoolFile              string
+	Logger                 Logger
+	Metrics                Metrics
+	stateMtx               *sync.RWMutex
+	state                  ConnectionState
 	connection             net.Conn
-	connActive             tAtomBool
-	disconnected           tAtomBool
 	channels               map[string]bool
 	channelUserlist        map[string]map[string]bool
 	channelsMtx            *sync.RWMutex
+	capabilitiesMtx        *sync.RWMutex
+	grantedCapabilities    []string
+	pendingCapabilities    int
+	capabilityTimeout      time.Duration
+	welcomed               bool
 	onConnect              func()
 	onNewWhisper           func(user User, message Message)
 	onNewMessage           func(channel string, user User, message Message)
@@ -72,18 +107,65 @@ type Client struct {
 	onUserJoin             func(channel, user string)
 	onUserPart             func(channel, user string)
 	onNewUnsetMessage      func(rawMessage string)
+	onNewRawMessage        func(message *RawMessage)
+	onLatency              func(latency time.Duration)
+	onStateChange          func(from, to ConnectionState, err error)
+	pongs                  chan string
+	pendingCommands        *pendingCommands
+	moderationTimeout      time.Duration
+	outgoing               *outgoingQueue
+	duplicates             *duplicateTracker
+	channelStates          *channelStates
 }
 
 // NewClient to create a new client
 func NewClient(username, oauth string) *Client {
-	return &Client{
-		ircUser:         username,
-		ircToken:        oauth,
-		TLS:             true,
-		channels:        map[string]bool{},
-		channelUserlist: map[string]map[string]bool{},
-		channelsMtx:     &sync.RWMutex{},
+	client := &Client{
+		ircUser:           username,
+		ircToken:          oauth,
+		TLS:               true,
+		channels:          map[string]bool{},
+		channelUserlist:   map[string]map[string]bool{},
+		stateMtx:          &sync.RWMutex{},
+		channelsMtx:       &sync.RWMutex{},
+		capabilitiesMtx:   &sync.RWMutex{},
+		Capabilities:      append([]string{}, DefaultCapabilities...),
+		pendingCommands:   &pendingCommands{},
+		moderationTimeout: defaultModerationTimeout,
+		capabilityTimeout: defaultCapabilityTimeout,
+		SendPings:         true,
+		PingInterval:      defaultPingInterval,
+		PongTimeout:       defaultPongTimeout,
+		WriteTimeout:      defaultWriteTimeout,
+		pongs:             make(chan string, 1),
+		MaxQueueSize:      defaultMaxQueueSize,
+		RateLimit:         DefaultRateLimit,
+		outgoing:          newOutgoingQueue(),
+		duplicates:        newDuplicateTracker(),
+		channelStates:     newChannelStates(),
+		RespectSlowMode:   true,
+		Logger:            NopLogger{},
+		Metrics:           NopMetrics{},
+	}
+	client.outgoing.expired = func(message *queuedMessage) {
+		client.log(LevelWarn, "dropped expired message", "channel", message.Channel, "line", message.Line)
 	}
+	client.outgoing.spoolFailed = func(err error) {
+		client.log(LevelError, "could not write spool file", "file", client.SpoolFile, "error", err)
+	}
+
+	return client
+}
+
+// NewAnonymousClient to create a new read-only client with a random justinfan username
+// anonymous clients can join channels and read chat, methods writing to chat return ErrAnonymousClient
+func NewAnonymousClient() *Client {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	client := NewClient(fmt.Sprintf("justinfan%d", 10000+r.Intn(90000)), "")
+	client.anonymous = true
+
+	return client
 }
 
 // OnNewWhisper attach callback to new whisper
@@ -141,17 +223,58 @@ func (c *Client) OnNewUnsetMessage(callback func(rawMessage string)) {
 	c.onNewUnsetMessage = callback
 }
 
+// OnNewRawMessage attaches callback to every received message, before it is decoded
+// Tags, badges and emotes of a RawMessage are only decoded when accessed, which is cheaper for bots that only read the text
+func (c *Client) OnNewRawMessage(callback func(message *RawMessage)) {
+	c.onNewRawMessage = callback
+}
+
 // Say write something in a chat
-func (c *Client) Say(channel, text string) {
-	c.send(fmt.Sprintf("PRIVMSG #%s :%s", channel, text))
+// messages are queued while the client is not connected and sent once the channels are joined
+// with SplitMessages set, text longer than twitch accepts is sent as several messages
+func (c *Client) Say(channel, text string) error {
+	return c.SayMessage(OutgoingMessage{Channel: channel, Text: text})
+}
+
+// SayMessage write something in a chat, dropping the message if it could not be sent within message.MaxAge
+func (c *Client) SayMessage(message OutgoingMessage) error {
+	if c.anonymous {
+		return ErrAnonymousClient
+	}
+
+	message.Channel = strings.ToLower(message.Channel)
+	parts := []string{message.Text}
+	if c.SplitMessages != nil {
+		parts = c.SplitMessages.Split(message.Text)
+	}
+
+	for _, part := range parts {
+		err := c.queue(&queuedMessage{
+			Line:     fmt.Sprintf("PRIVMSG #%s :%s", message.Channel, part),
+			Channel:  message.Channel,
+			Priority: message.Priority,
+		}, message.MaxAge)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Whisper write something in private to someone on twitch
 // whispers are heavily spam protected
 // so your message might get blocked because of this
 // verify your bot to prevent this
-func (c *Client) Whisper(username, text string) {
-	c.send(fmt.Sprintf("PRIVMSG #jtv :/w %s %s", username, text))
+func (c *Client) Whisper(username, text string) error {
+	if c.anonymous {
+		return ErrAnonymousClient
+	}
+
+	return c.queue(&queuedMessage{
+		Line:     fmt.Sprintf("PRIVMSG #jtv :/w %s %s", strings.ToLower(username), text),
+		Channel:  "jtv",
+		Priority: PriorityNormal,
+	}, 0)
 }
 
 // Join enter a twitch channel to read more messages
@@ -161,7 +284,8 @@ func (c *Client) Join(channel string) {
 	// If we don't have the channel in our map AND we have an
 	// active connection, explicitly join before we add it to our map
 	c.channelsMtx.Lock()
-	if !c.channels[channel] && c.connActive.get() {
+	if !c.channels[channel] && c.connected() {
+		c.duplicates.join(channel)
 		go c.send(fmt.Sprintf("JOIN #%s", channel))
 	}
 
@@ -172,7 +296,9 @@ func (c *Client) Join(channel string) {
 
 // Depart leave a twitch channel
 func (c *Client) Depart(channel string) {
-	if c.connActive.get() {
+	channel = strings.ToLower(channel)
+
+	if c.connected() {
 		go c.send(fmt.Sprintf("PART #%s", channel))
 	}
 
@@ -180,71 +306,140 @@ func (c *Client) Depart(channel string) {
 	delete(c.channels, channel)
 	delete(c.channelUserlist, channel)
 	c.channelsMtx.Unlock()
+
+	c.channelStates.remove(channel)
 }
 
 // Disconnect close current connection
 func (c *Client) Disconnect() error {
-	c.connActive.set(false)
-	c.disconnected.set(true)
-	if c.connection != nil {
-		return c.connection.Close()
+	c.setState(StateClosed, nil)
+
+	if conn := c.conn(); conn != nil {
+		return conn.Close()
 	}
 	return errors.New("connection not open")
 }
 
 // Connect connect the client to the irc server
 func (c *Client) Connect() error {
-	if c.IrcAddress == "" && c.TLS {
-		c.IrcAddress = ircTwitchTLS
-	} else if c.IrcAddress == "" && !c.TLS {
-		c.IrcAddress = ircTwitch
+	transport, err := c.transport()
+	if err != nil {
+		return err
 	}
 
-	c.disconnected.set(false)
-
-	dialer := &net.Dialer{
-		KeepAlive: time.Second * 10,
+	if c.IrcAddress == "" {
+		switch transport.(type) {
+		case *WebSocketTransport:
+			c.IrcAddress = ircTwitchWebSocket
+		case *TCPTransport:
+			c.IrcAddress = ircTwitch
+		default:
+			c.IrcAddress = ircTwitchTLS
+		}
 	}
 
-	var conf *tls.Config
-	// This means we are connecting to "localhost". Disable certificate chain check
-	if strings.HasPrefix(c.IrcAddress, "127.0.0.1:") {
-		conf = &tls.Config{
-			InsecureSkipVerify: true,
-		}
-	} else {
-		conf = &tls.Config{}
+	if err := c.outgoing.load(c.SpoolFile); err != nil {
+		return err
 	}
+
+	// set once a rejected token has been refreshed, so a second rejection ends Connect()
+	refreshedToken := false
+	tokenBackoff := tokenRetryMinBackoff
+	c.setState(StateDialing, nil)
 	for {
-		if c.disconnected.get() {
-			return ErrClientDisconnected
-		}
+		token, err := c.loginToken()
+		if err != nil {
+			if permanent, ok := err.(*PermanentTokenError); ok {
+				c.log(LevelError, "token provider failed", "error", permanent.Err)
+				if !c.transition(StateDisconnected, permanent) {
+					return ErrClientDisconnected
+				}
+				return permanent.Err
+			}
 
-		var err error
-		if c.TLS {
-			c.connection, err = tls.DialWithDialer(dialer, "tcp", c.IrcAddress, conf)
-		} else {
-			c.connection, err = dialer.Dial("tcp", c.IrcAddress)
+			c.log(LevelWarn, "token provider failed, retrying", "error", err, "backoff", tokenBackoff)
+			if !c.transition(StateReconnecting, err) {
+				return ErrClientDisconnected
+			}
+			time.Sleep(tokenBackoff)
+			tokenBackoff = nextTokenBackoff(tokenBackoff)
+			if !c.transition(StateDialing, nil) {
+				return ErrClientDisconnected
+			}
+			continue
 		}
+		tokenBackoff = tokenRetryMinBackoff
+
+		c.log(LevelInfo, "connecting", "address", c.IrcAddress)
+		conn, err := transport.Dial(c.IrcAddress)
 		if err != nil {
+			c.log(LevelError, "dial failed", "address", c.IrcAddress, "error", err)
+			if !c.transition(StateDisconnected, err) {
+				return ErrClientDisconnected
+			}
 			return err
 		}
+		conn = newDeadlineConn(conn, c.ReadTimeout, c.WriteTimeout)
+		if c.Recorder != nil {
+			conn = &recordingConn{Conn: conn, recorder: c.Recorder}
+		}
+		if c.Logger != nil {
+			conn = &loggingConn{Conn: conn, client: c}
+		}
+
+		if !c.attach(conn) {
+			conn.Close()
+			return ErrClientDisconnected
+		}
 
-		go c.setupConnection()
+		c.setupConnection(conn, token)
 
-		err = c.readConnection(c.connection)
-		if err != nil {
-			if err == ErrLoginAuthenticationFailed {
+		done := make(chan struct{})
+		go c.awaitCapabilities(conn, done)
+		go c.keepAlive(conn, done)
+		go c.flushQueue(conn, done)
+
+		err = c.readConnection(conn)
+		close(done)
+		conn.Close()
+
+		if err == ErrLoginAuthenticationFailed {
+			if refreshedToken || !c.refreshToken(context.Background()) {
+				c.log(LevelError, "login authentication failed", "username", c.ircUser)
+				if !c.transition(StateDisconnected, err) {
+					return ErrClientDisconnected
+				}
 				return err
 			}
+			c.log(LevelInfo, "token refreshed after a rejected login")
+			refreshedToken = true
+		} else {
+			refreshedToken = false
+		}
+
+		if !c.transition(StateReconnecting, err) {
+			return ErrClientDisconnected
+		}
+		c.metrics().Reconnect()
+		if err != ErrLoginAuthenticationFailed {
 			time.Sleep(time.Millisecond * 200)
-			continue
+		}
+		if !c.transition(StateDialing, nil) {
+			return ErrClientDisconnected
 		}
 	}
 }
 
 // Userlist returns the userlist for a given channel
+// Requires twitch to grant the membership capability, which is part of the default capabilities
 func (c *Client) Userlist(channel string) ([]string, error) {
+	if !c.hasCapability(MembershipCapability) {
+		return nil, fmt.Errorf("Userlist requires the %s capability, it was not granted", MembershipCapability)
+	}
+
+	c.channelsMtx.RLock()
+	defer c.channelsMtx.RUnlock()
+
 	usermap, ok := c.channelUserlist[channel]
 	if !ok || usermap == nil {
 		return nil, fmt.Errorf("Could not find userlist for channel '%s' in client", channel)
@@ -260,7 +455,13 @@ func (c *Client) Userlist(channel string) ([]string, error) {
 	return userlist, nil
 }
 
+// Anonymous returns true for read-only clients created with NewAnonymousClient()
+func (c *Client) Anonymous() bool {
+	return c.anonymous
+}
+
 // SetIRCToken updates the oauth token for this client used for authentication
+// Use SetTokenProvider instead if the token should be refreshed automatically
 // This will not cause a reconnect, but is meant more for "on next connect, use this new token" in case the old token has expired
 func (c *Client) SetIRCToken(ircToken string) {
 	c.ircToken = ircToken
@@ -276,102 +477,127 @@ func (c *Client) readConnection(conn net.Conn) error {
 		}
 		messages := strings.Split(line, "\r\n")
 		for _, msg := range messages {
-			if !c.connActive.get() && strings.Contains(msg, ":tmi.twitch.tv 001") {
-				c.connActive.set(true)
-				c.initialJoins()
-				if c.onConnect != nil {
-					c.onConnect()
-				}
+			if c.Recorder != nil {
+				c.Recorder.Record(DirectionReceived, msg)
+			}
+			if c.logs(LevelDebug) {
+				c.Logger.Log(LevelDebug, "received", "line", msg)
 			}
-			if err = c.handleLine(msg); err != nil {
+			// the connection is ready once twitch welcomed us and answered every CAP REQ
+			if c.State() == StateAuthenticating && c.negotiate(msg) {
+				c.ready(conn)
+			}
+			if err = c.handleLineSafely(msg); err != nil {
 				return err
 			}
 		}
 	}
 }
 
-func (c *Client) setupConnection() {
-	c.connection.Write([]byte("PASS " + c.ircToken + "\r\n"))
-	c.connection.Write([]byte("NICK " + c.ircUser + "\r\n"))
-	c.connection.Write([]byte("CAP REQ :twitch.tv/tags\r\n"))
-	c.connection.Write([]byte("CAP REQ :twitch.tv/commands\r\n"))
-	c.connection.Write([]byte("CAP REQ :twitch.tv/membership\r\n"))
+// ready moves conn on to connected, joins the channels and calls OnConnect
+// it does nothing if conn is not the authenticating connection anymore, so it runs once per connection
+func (c *Client) ready(conn net.Conn) {
+	if !c.promote(conn) {
+		return
+	}
+	c.initialJoins()
+	c.outgoing.signal()
+	c.log(LevelInfo, "connected", "address", c.IrcAddress)
+	c.callOnConnect()
+}
+
+// callOnConnect calls the OnConnect callback, a panic is logged instead of ending the client
+func (c *Client) callOnConnect() {
+	defer c.recoverCallback("")
+
+	if c.onConnect != nil {
+		c.onConnect()
+	}
+}
+
+// handleLineSafely handles line, a panicking callback is logged and the line skipped
+func (c *Client) handleLineSafely(line string) error {
+	defer c.recoverCallback(line)
+
+	return c.handleLine(line)
+}
+
+// loginToken returns the oauth token for the next login, empty for anonymous clients
+func (c *Client) loginToken() (string, error) {
+	// twitch accepts justinfan logins without a password
+	if c.anonymous {
+		return "", nil
+	}
+
+	return c.token(context.Background())
+}
+
+func (c *Client) setupConnection(conn net.Conn, token string) {
+	if !c.anonymous {
+		conn.Write([]byte("PASS " + token + "\r\n"))
+	}
+	conn.Write([]byte("NICK " + c.ircUser + "\r\n"))
+	c.requestCapabilities(conn)
 }
 
 func (c *Client) initialJoins() {
 	// join or rejoin channels on connection
+	c.duplicates.reset()
 	c.channelsMtx.RLock()
 	for channel := range c.channels {
+		c.duplicates.join(channel)
 		c.send(fmt.Sprintf("JOIN #%s", channel))
 	}
 	c.channelsMtx.RUnlock()
 }
 
+// send writes line to the connection right away, bypassing the outgoing queue
+// the line is dropped if the client is not connected, JOINs are repeated by initialJoins anyway
 func (c *Client) send(line string) {
-	for i := 0; i < 1000; i++ {
-		if !c.connActive.get() {
-			time.Sleep(time.Millisecond * 2)
-			continue
-		}
-		c.connection.Write([]byte(line + "\r\n"))
+	if conn := c.conn(); conn != nil && c.connected() {
+		conn.Write([]byte(line + "\r\n"))
 		return
 	}
+	c.log(LevelDebug, "dropped line, client not connected", "line", redactLine(line))
 }
 
 // Errors returned from handleLine break out of readConnections, which starts a reconnect
 // This means that we should only return fatal errors as errors here
 func (c *Client) handleLine(line string) error {
 	if strings.HasPrefix(line, "PING") {
-		c.send(strings.Replace(line, "PING", "PONG", 1))
+		// answer directly, a PING can arrive before the connection is ready for send()
+		c.conn().Write([]byte(strings.Replace(line, "PING", "PONG", 1) + "\r\n"))
 
 		return nil
 	}
 
-	if strings.HasPrefix(line, "@") {
-		channel, user, clientMessage := ParseMessage(line)
-
-		switch clientMessage.Type {
-		case PRIVMSG:
-			if c.onNewMessage != nil {
-				c.onNewMessage(channel, *user, *clientMessage)
-			}
-		case WHISPER:
-			if c.onNewWhisper != nil {
-				c.onNewWhisper(*user, *clientMessage)
-			}
-		case ROOMSTATE:
-			if c.onNewRoomstateMessage != nil {
-				c.onNewRoomstateMessage(channel, *user, *clientMessage)
-			}
-		case CLEARCHAT:
-			if c.onNewClearchatMessage != nil {
-				c.onNewClearchatMessage(channel, *user, *clientMessage)
-			}
-		case USERNOTICE:
-			if c.onNewUsernoticeMessage != nil {
-				c.onNewUsernoticeMessage(channel, *user, *clientMessage)
-			}
-		case NOTICE:
-			if c.onNewNoticeMessage != nil {
-				c.onNewNoticeMessage(channel, *user, *clientMessage)
-			}
-		case USERSTATE:
-			if c.onNewUserstateMessage != nil {
-				c.onNewUserstateMessage(channel, *user, *clientMessage)
-			}
-		case UNSET:
-			if c.onNewUnsetMessage != nil {
-				c.onNewUnsetMessage(clientMessage.Raw)
-			}
+	rawMessage := ParseRawMessage(line)
+	if rawMessage.Command() == "" {
+		// neither a PING nor an IRC line with a command
+		if line != "" {
+			c.metrics().ParseError()
+			c.log(LevelDebug, "could not parse line", "line", line)
 		}
 
 		return nil
 	}
 
+	if strings.HasPrefix(line, "@") {
+		c.handleMessage(rawMessage)
+
+		return nil
+	}
+
 	if strings.HasPrefix(line, ":") {
+		if strings.HasPrefix(line, ":tmi.twitch.tv PONG") {
+			c.handlePong(line)
+
+			return nil
+		}
 		if strings.Contains(line, "tmi.twitch.tv JOIN") {
 			channel, username := parseJoinPart(line)
 
+			c.channelsMtx.Lock()
 			if c.channelUserlist[channel] == nil {
 				c.channelUserlist[channel] = map[string]bool{}
 			}
@@ -380,6 +606,7 @@ func (c *Client) handleLine(line string) error {
 			if !ok && username != c.ircUser {
 				c.channelUserlist[channel][username] = true
 			}
+			c.channelsMtx.Unlock()
 
 			if c.onUserJoin != nil {
 				c.onUserJoin(channel, username)
@@ -388,7 +615,9 @@ func (c *Client) handleLine(line string) error {
 		if strings.Contains(line, "tmi.twitch.tv PART") {
 			channel, username := parseJoinPart(line)
 
+			c.channelsMtx.Lock()
 			delete(c.channelUserlist[channel], username)
+			c.channelsMtx.Unlock()
 
 			if c.onUserPart != nil {
 				c.onUserPart(channel, username)
@@ -401,21 +630,102 @@ func (c *Client) handleLine(line string) error {
 		if strings.Contains(line, "353 "+c.ircUser) {
 			channel, users := parseNames(line)
 
+			c.channelsMtx.Lock()
+			if c.channelUserlist[channel] == nil {
+				c.channelUserlist[channel] = map[string]bool{}
+			}
+
 			for _, user := range users {
 				c.channelUserlist[channel][user] = true
 			}
+			c.channelsMtx.Unlock()
 		}
 		if strings.Contains(line, "tmi.twitch.tv NOTICE * :Login authentication failed") || strings.Contains(line, "tmi.twitch.tv NOTICE * :Improperly formatted auth") {
 			return ErrLoginAuthenticationFailed
 		}
+
+		// without the tags capability twitch sends messages without the leading tags
+		if rawMessage.Type() != UNSET {
+			c.handleMessage(rawMessage)
+		}
 	}
 
 	return nil
 }
 
+// handleMessage only decodes the full message for the callbacks that are set, and the types the client tracks itself
+func (c *Client) handleMessage(rawMessage *RawMessage) {
+	if c.onNewRawMessage != nil {
+		c.onNewRawMessage(rawMessage)
+	}
+
+	if messageType := rawMessage.Type(); messageType != UNSET {
+		c.metrics().MessageReceived(messageType, rawMessage.Channel())
+	}
+
+	switch rawMessage.Type() {
+	case PRIVMSG:
+		if c.onNewMessage != nil {
+			channel, user, clientMessage := decodeMessage(rawMessage)
+			c.onNewMessage(channel, *user, *clientMessage)
+		}
+	case WHISPER:
+		if c.onNewWhisper != nil {
+			_, user, clientMessage := decodeMessage(rawMessage)
+			c.onNewWhisper(*user, *clientMessage)
+		}
+	case ROOMSTATE:
+		channel, user, clientMessage := decodeMessage(rawMessage)
+		c.channelStates.update(channel, user, clientMessage)
+		c.duplicates.joined(channel)
+		if c.onNewRoomstateMessage != nil {
+			c.onNewRoomstateMessage(channel, *user, *clientMessage)
+		}
+	case CLEARCHAT:
+		channel, user, clientMessage := decodeMessage(rawMessage)
+		c.pendingCommands.resolve(channel, *clientMessage)
+		if c.onNewClearchatMessage != nil {
+			c.onNewClearchatMessage(channel, *user, *clientMessage)
+		}
+	case USERNOTICE:
+		if c.onNewUsernoticeMessage != nil {
+			channel, user, clientMessage := decodeMessage(rawMessage)
+			c.onNewUsernoticeMessage(channel, *user, *clientMessage)
+		}
+	case NOTICE:
+		channel, user, clientMessage := decodeMessage(rawMessage)
+		// moderation commands are not tracked as chat messages, so their answers reject none
+		resolved := c.pendingCommands.resolve(channel, *clientMessage)
+		if msgID := clientMessage.Tags["msg-id"]; !resolved && isRejection(msgID) {
+			c.rejectedMessage(channel, msgID)
+		}
+		if c.onNewNoticeMessage != nil {
+			c.onNewNoticeMessage(channel, *user, *clientMessage)
+		}
+	case USERSTATE:
+		channel, user, clientMessage := decodeMessage(rawMessage)
+		c.channelStates.update(channel, user, clientMessage)
+		c.duplicates.accepted(channel, time.Now())
+		if c.onNewUserstateMessage != nil {
+			c.onNewUserstateMessage(channel, *user, *clientMessage)
+		}
+	case UNSET:
+		c.log(LevelDebug, "unhandled message", "command", rawMessage.Command(), "line", rawMessage.Raw())
+		if c.onNewUnsetMessage != nil {
+			c.onNewUnsetMessage(rawMessage.Raw())
+		}
+	}
+}
+
 // ParseMessage parse a raw ircv3 twitch
+// it decodes every part of the message, use ParseRawMessage to only decode what is accessed
 func ParseMessage(line string) (string, *User, *Message) {
-	message := parseMessage(line)
+	return decodeMessage(ParseRawMessage(line))
+}
+
+// decodeMessage decodes every part of rawMessage
+func decodeMessage(rawMessage *RawMessage) (string, *User, *Message) {
+	message := newMessage(rawMessage)
 
 	channel := message.Channel
 
@@ -448,21 +758,3 @@ func ParseMessage(line string) (string, *User, *Message) {
 
 	return channel, user, clientMessage
 }
-
-// tAtomBool atomic bool for writing/reading across threads
-type tAtomBool struct{ flag int32 }
-
-func (b *tAtomBool) set(value bool) {
-	var i int32
-	if value {
-		i = 1
-	}
-	atomic.StoreInt32(&(b.flag), int32(i))
-}
-
-func (b *tAtomBool) get() bool {
-	if atomic.LoadInt32(&(b.flag)) != 0 {
-		return true
-	}
-	return false
-}
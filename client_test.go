@@ -3,14 +3,18 @@ package twitch
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"net/textproto"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -29,17 +33,45 @@ func postMessageOnConnect(message string) func(conn net.Conn) {
 	}
 }
 
+// testTLSConfig trusts the self-signed certificate the test servers use
+func testTLSConfig() *tls.Config {
+	caCert, err := ioutil.ReadFile("test_resources/server.crt")
+	if err != nil {
+		panic(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+
+	return &tls.Config{
+		RootCAs: pool,
+	}
+}
+
 func newTestClient(host string) *Client {
 	client := NewClient("justinfan123123", "oauth:123123132")
 	client.IrcAddress = host
+	client.TLSConfig = testTLSConfig()
 
 	return client
 }
 
-func handleTestConnection(t *testing.T, onConnect func(net.Conn), onMessage func(string), listener net.Listener) {
+// ackCapabilities answers a CAP REQ the way twitch does
+func ackCapabilities(conn net.Conn, message string) bool {
+	if !strings.HasPrefix(message, "CAP REQ") {
+		return false
+	}
+
+	fmt.Fprintf(conn, ":tmi.twitch.tv CAP * ACK :%s\r\n", strings.TrimPrefix(message, "CAP REQ :"))
+	return true
+}
+
+// handleTestConnection serves one connection of listener and closes the listener afterwards
+// it runs in its own goroutine, so it can not fail the test, a failed Accept or read just ends the connection
+func handleTestConnection(onConnect func(net.Conn), onMessage func(string), listener net.Listener) {
 	conn, err := listener.Accept()
 	if err != nil {
-		t.Fatal(err)
+		return
 	}
 
 	reader := bufio.NewReader(conn)
@@ -49,8 +81,9 @@ func handleTestConnection(t *testing.T, onConnect func(net.Conn), onMessage func
 	defer conn.Close()
 	for {
 		message, err := tp.ReadLine()
-		if err != nil && err != io.EOF {
-			t.Fatal(err)
+		if err != nil {
+			// the client closed the connection, or the test closed it on the server side
+			return
 		}
 		message = strings.Replace(message, "\r\n", "", 1)
 
@@ -60,6 +93,8 @@ func handleTestConnection(t *testing.T, onConnect func(net.Conn), onMessage func
 			continue
 		}
 
+		ackCapabilities(conn, message)
+
 		if strings.HasPrefix(message, "PASS") {
 			pass := strings.Split(message, " ")[1]
 			if !strings.HasPrefix(pass, "oauth:") {
@@ -91,7 +126,7 @@ func startServer(t *testing.T, onConnect func(net.Conn), onMessage func(string))
 		t.Fatal(err)
 	}
 
-	go handleTestConnection(t, onConnect, onMessage, listener)
+	go handleTestConnection(onConnect, onMessage, listener)
 
 	return host
 }
@@ -112,13 +147,31 @@ func startServerMultiConns(t *testing.T, numConns int, onConnect func(net.Conn),
 		t.Fatal(err)
 	}
 
+	shared := &sharedListener{Listener: listener, handlers: numConns}
 	for i := 0; i < numConns; i++ {
-		go handleTestConnection(t, onConnect, onMessage, listener)
+		go handleTestConnection(onConnect, onMessage, shared)
 	}
 
 	return host
 }
 
+// sharedListener a listener served by several connection handlers, it is closed once the last one closes it
+type sharedListener struct {
+	net.Listener
+	mtx      sync.Mutex
+	handlers int
+}
+
+func (l *sharedListener) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.handlers--; l.handlers > 0 {
+		return nil
+	}
+	return l.Listener.Close()
+}
+
 func startNoTLSServer(t *testing.T, onConnect func(net.Conn), onMessage func(string)) string {
 	host := "127.0.0.1:" + strconv.Itoa(startPort)
 	startPort++
@@ -128,11 +181,64 @@ func startNoTLSServer(t *testing.T, onConnect func(net.Conn), onMessage func(str
 		t.Fatal(err)
 	}
 
-	go handleTestConnection(t, onConnect, onMessage, listener)
+	go handleTestConnection(onConnect, onMessage, listener)
 
 	return host
 }
 
+// webSocketListener performs the server side of the websocket handshake for every accepted connection
+type webSocketListener struct {
+	net.Listener
+}
+
+func (l *webSocketListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", webSocketAccept(req.Header.Get("Sec-WebSocket-Key")))
+
+	return newWebSocketConn(conn, reader, false), nil
+}
+
+func startWebSocketServer(t *testing.T, useTLS bool, onConnect func(net.Conn), onMessage func(string)) string {
+	host := "127.0.0.1:" + strconv.Itoa(startPort)
+	startPort++
+
+	var listener net.Listener
+	var err error
+	if useTLS {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair("test_resources/server.crt", "test_resources/server.key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err = tls.Listen("tcp", host, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		})
+	} else {
+		listener, err = net.Listen("tcp", host)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go handleTestConnection(onConnect, onMessage, &webSocketListener{listener})
+
+	if useTLS {
+		return "wss://" + host
+	}
+	return "ws://" + host
+}
+
 func TestCanConnectAndAuthenticateWithoutTLS(t *testing.T) {
 	const oauthCode = "oauth:123123132"
 	wait := make(chan struct{})
@@ -211,15 +317,16 @@ func TestCanConnectAndAuthenticate(t *testing.T) {
 
 	client := NewClient("justinfan123123", oauthCode)
 	client.IrcAddress = host
+	client.TLSConfig = testTLSConfig()
+	errs := make(chan error, 1)
 	go func() {
-		err := client.Connect()
-		if err != nil {
-			t.Fatal("bad error")
-		}
+		errs <- client.Connect()
 	}()
 
 	select {
 	case <-wait:
+	case err := <-errs:
+		t.Fatalf("Connect() returned %v", err)
 	case <-time.After(time.Second * 3):
 		t.Fatal("no oauth read")
 	}
@@ -567,10 +674,10 @@ func TestCanHandleRECONNECTMessage(t *testing.T) {
 
 	wait := make(chan bool)
 
-	connCount := 0
+	var connCount int32
 
 	host := startServerMultiConns(t, 2, func(conn net.Conn) {
-		connCount++
+		atomic.AddInt32(&connCount, 1)
 		wait <- true
 		time.AfterFunc(100*time.Millisecond, func() {
 			fmt.Fprintf(conn, "%s\r\n", testMessage)
@@ -587,7 +694,7 @@ func TestCanHandleRECONNECTMessage(t *testing.T) {
 		t.Fatal("no message sent")
 	}
 
-	assertIntsEqual(t, 1, connCount)
+	assertIntsEqual(t, 1, int(atomic.LoadInt32(&connCount)))
 
 	select {
 	case <-wait:
@@ -595,7 +702,7 @@ func TestCanHandleRECONNECTMessage(t *testing.T) {
 		t.Fatal("no message sent")
 	}
 
-	assertIntsEqual(t, 2, connCount)
+	assertIntsEqual(t, 2, int(atomic.LoadInt32(&connCount)))
 }
 
 func TestCanSayMessage(t *testing.T) {
@@ -699,7 +806,7 @@ func TestCanJoinChannelAfterConnection(t *testing.T) {
 	go client.Connect()
 
 	// wait for the connection to go active
-	for !client.connActive.get() {
+	for client.State() != StateConnected {
 		time.Sleep(time.Millisecond * 2)
 	}
 	client.Join("gempir")
@@ -729,7 +836,7 @@ func TestCanDepartChannel(t *testing.T) {
 	go client.Connect()
 
 	// wait for the connection to go active
-	for !client.connActive.get() {
+	for client.State() != StateConnected {
 		time.Sleep(time.Millisecond * 2)
 	}
 	client.Depart("gempir")
@@ -744,6 +851,17 @@ func TestCanDepartChannel(t *testing.T) {
 	assertStringsEqual(t, "PART #gempir", receivedMsg)
 }
 
+func TestDepartIgnoresCaseOfChannel(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.Join("Gempir")
+	client.Depart("GEMPIR")
+
+	client.channelsMtx.Lock()
+	defer client.channelsMtx.Unlock()
+	assertFalse(t, client.channels["gempir"], "mixed case depart left the channel joined")
+	assertIntsEqual(t, 0, len(client.channelUserlist))
+}
+
 func TestCanGetUserlist(t *testing.T) {
 	testString := `:justinfan123123.tmi.twitch.tv 353 justinfan123123 = #channel123 :username1 username2`
 	waitEnd := make(chan struct{})
@@ -757,7 +875,7 @@ func TestCanGetUserlist(t *testing.T) {
 	go client.Connect()
 
 	// wait for the connection to go active
-	for !client.connActive.get() {
+	for client.State() != StateConnected {
 		time.Sleep(time.Millisecond * 5)
 	}
 
@@ -808,7 +926,7 @@ func TestDepartNegatesJoinIfNotConnected(t *testing.T) {
 	go client.Connect()
 
 	// wait for the connection to go active
-	for !client.connActive.get() {
+	for client.State() != StateConnected {
 		time.Sleep(time.Millisecond * 2)
 	}
 
@@ -866,6 +984,7 @@ func TestCanNotUseImproperlyFormattedOauth(t *testing.T) {
 	host := startServer(t, nothingOnConnect, nothingOnMessage)
 	client := NewClient("justinfan123123", "imrpproperlyformattedoauth")
 	client.IrcAddress = host
+	client.TLSConfig = testTLSConfig()
 
 	err := client.Connect()
 	if err != ErrLoginAuthenticationFailed {
@@ -877,6 +996,7 @@ func TestCanNotUseWrongOauth(t *testing.T) {
 	host := startServer(t, nothingOnConnect, nothingOnMessage)
 	client := NewClient("justinfan123123", "oauth:wrong")
 	client.IrcAddress = host
+	client.TLSConfig = testTLSConfig()
 
 	err := client.Connect()
 	if err != ErrLoginAuthenticationFailed {
@@ -904,3 +1024,54 @@ func TestCanConnectToTwitchWithoutTLS(t *testing.T) {
 
 	client.Connect()
 }
+
+func TestCanCreateAnonymousClient(t *testing.T) {
+	client := NewAnonymousClient()
+
+	assertTrue(t, client.Anonymous(), "client is not anonymous")
+	assertTrue(t, strings.HasPrefix(client.ircUser, "justinfan"), "anonymous username is not a justinfan login")
+}
+
+func TestAnonymousClientCanNotSend(t *testing.T) {
+	client := NewAnonymousClient()
+
+	if err := client.Say("gempir", "hello"); err != ErrAnonymousClient {
+		t.Errorf("expected ErrAnonymousClient from Say, got %v", err)
+	}
+	if err := client.Whisper("gempir", "hello"); err != ErrAnonymousClient {
+		t.Errorf("expected ErrAnonymousClient from Whisper, got %v", err)
+	}
+	if _, err := client.Ban("gempir", "baduser", ""); err != ErrAnonymousClient {
+		t.Errorf("expected ErrAnonymousClient from Ban, got %v", err)
+	}
+}
+
+func TestAnonymousClientSkipsPassAndJoins(t *testing.T) {
+	waitEnd := make(chan struct{})
+	var received []string
+
+	host := startServer(t, nothingOnConnect, func(message string) {
+		received = append(received, message)
+		if strings.HasPrefix(message, "JOIN") {
+			close(waitEnd)
+		}
+	})
+
+	client := NewAnonymousClient()
+	client.IrcAddress = host
+	client.TLSConfig = testTLSConfig()
+	client.Join("gempir")
+
+	go client.Connect()
+
+	select {
+	case <-waitEnd:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no join message received")
+	}
+
+	for _, message := range received {
+		assertFalse(t, strings.HasPrefix(message, "PASS"), "anonymous client sent PASS")
+	}
+	assertStringsEqual(t, "JOIN #gempir", received[len(received)-1])
+}
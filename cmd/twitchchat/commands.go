@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+// errQuit returned from handleInput for /quit
+var errQuit = errors.New("quit")
+
+// chatClient the methods of twitch.Client the commands use
+type chatClient interface {
+	Say(channel, text string) error
+	Whisper(username, text string) error
+	Join(channel string)
+	Depart(channel string)
+	Ban(channel, username, reason string) (twitch.Message, error)
+	Unban(channel, username string) (twitch.Message, error)
+	Timeout(channel, username string, duration time.Duration, reason string) (twitch.Message, error)
+	DeleteMessage(channel, msgID string) (twitch.Message, error)
+	Clear(channel string) (twitch.Message, error)
+	Slow(channel string, interval time.Duration) (twitch.Message, error)
+	SlowOff(channel string) (twitch.Message, error)
+	FollowersOnly(channel string, duration time.Duration) (twitch.Message, error)
+	FollowersOnlyOff(channel string) (twitch.Message, error)
+	SubscribersOnly(channel string, enabled bool) (twitch.Message, error)
+	EmoteOnly(channel string, enabled bool) (twitch.Message, error)
+	UniqueChat(channel string, enabled bool) (twitch.Message, error)
+	Mod(channel, username string) (twitch.Message, error)
+	Unmod(channel, username string) (twitch.Message, error)
+	VIP(channel, username string) (twitch.Message, error)
+	Unvip(channel, username string) (twitch.Message, error)
+}
+
+const help = `/join <channel>                 join a channel and write to it
+/part [channel]                 leave a channel, the current one by default
+/channel <channel>              write to another joined channel
+/w <user> <text>                whisper to a user
+/me <text>                      send an action
+/ban <user> [reason]            /unban <user>
+/timeout <user> [seconds] [reason]
+/untimeout <user>               /delete <message id>
+/clear                          /slow [seconds]       /slowoff
+/followers [duration]           /followersoff
+/subscribers  /subscribersoff   /emoteonly  /emoteonlyoff
+/uniquechat   /uniquechatoff
+/mod <user>   /unmod <user>     /vip <user>   /unvip <user>
+/quit                           disconnect and exit
+anything else is sent to the current channel`
+
+// session the joined channels and the channel input is sent to
+type session struct {
+	client   chatClient
+	out      io.Writer
+	printer  printer
+	channels []string
+	current  string
+}
+
+func newSession(client chatClient, channels []string, out io.Writer, p printer) *session {
+	s := &session{client: client, out: out, printer: p}
+	for _, channel := range channels {
+		s.join(channel)
+	}
+	return s
+}
+
+func (s *session) join(channel string) {
+	channel = normalizeChannel(channel)
+	if channel == "" {
+		return
+	}
+	for _, joined := range s.channels {
+		if joined == channel {
+			s.current = channel
+			return
+		}
+	}
+
+	s.client.Join(channel)
+	s.channels = append(s.channels, channel)
+	s.current = channel
+}
+
+func (s *session) part(channel string) {
+	channel = normalizeChannel(channel)
+	for i, joined := range s.channels {
+		if joined != channel {
+			continue
+		}
+
+		s.client.Depart(channel)
+		s.channels = append(s.channels[:i:i], s.channels[i+1:]...)
+		if s.current == channel {
+			s.current = ""
+			if len(s.channels) > 0 {
+				s.current = s.channels[len(s.channels)-1]
+			}
+		}
+		return
+	}
+}
+
+// handleInput runs a line typed by the user, errQuit ends the program
+func (s *session) handleInput(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "/me ") {
+		return s.say(line)
+	}
+
+	fields := strings.Fields(line)
+	command, args := strings.ToLower(fields[0]), fields[1:]
+
+	switch command {
+	case "/quit", "/exit":
+		return errQuit
+	case "/help":
+		fmt.Fprintln(s.out, help)
+		return nil
+	case "/join":
+		if len(args) == 0 {
+			return errors.New("usage: /join <channel>")
+		}
+		for _, channel := range args {
+			s.join(channel)
+		}
+		s.infof("writing to #%s", s.current)
+		return nil
+	case "/part":
+		channel := s.current
+		if len(args) > 0 {
+			channel = args[0]
+		}
+		s.part(channel)
+		return nil
+	case "/channel":
+		if len(args) == 0 {
+			s.infof("writing to #%s, joined %s", s.current, strings.Join(s.channels, ", "))
+			return nil
+		}
+		channel := normalizeChannel(args[0])
+		for _, joined := range s.channels {
+			if joined == channel {
+				s.current = channel
+				s.infof("writing to #%s", channel)
+				return nil
+			}
+		}
+		return fmt.Errorf("not joined to #%s, use /join", channel)
+	case "/w", "/whisper":
+		if len(args) < 2 {
+			return errors.New("usage: /w <user> <text>")
+		}
+		return s.client.Whisper(strings.ToLower(args[0]), strings.Join(args[1:], " "))
+	}
+
+	return s.moderate(command, args)
+}
+
+func (s *session) say(text string) error {
+	if s.current == "" {
+		return errors.New("no channel joined, use /join <channel>")
+	}
+	return s.client.Say(s.current, text)
+}
+
+// moderate runs a moderation command in the current channel and prints the answer of twitch
+func (s *session) moderate(command string, args []string) error {
+	if s.current == "" {
+		return errors.New("no channel joined, use /join <channel>")
+	}
+	channel := s.current
+
+	user := func() (string, error) {
+		if len(args) == 0 {
+			return "", fmt.Errorf("usage: %s <user>", command)
+		}
+		return strings.ToLower(strings.TrimPrefix(args[0], "@")), nil
+	}
+	// rest returns the arguments from i on, as the reason of a ban or timeout
+	rest := func(i int) string {
+		if len(args) <= i {
+			return ""
+		}
+		return strings.Join(args[i:], " ")
+	}
+
+	var message twitch.Message
+	var err error
+	switch command {
+	case "/ban", "/unban", "/untimeout", "/mod", "/unmod", "/vip", "/unvip", "/timeout":
+		username, userErr := user()
+		if userErr != nil {
+			return userErr
+		}
+		switch command {
+		case "/ban":
+			message, err = s.client.Ban(channel, username, rest(1))
+		case "/unban", "/untimeout":
+			message, err = s.client.Unban(channel, username)
+		case "/mod":
+			message, err = s.client.Mod(channel, username)
+		case "/unmod":
+			message, err = s.client.Unmod(channel, username)
+		case "/vip":
+			message, err = s.client.VIP(channel, username)
+		case "/unvip":
+			message, err = s.client.Unvip(channel, username)
+		case "/timeout":
+			duration, reason := 10*time.Minute, rest(1)
+			if len(args) > 1 {
+				if seconds, parseErr := strconv.Atoi(args[1]); parseErr == nil {
+					duration, reason = time.Duration(seconds)*time.Second, rest(2)
+				}
+			}
+			message, err = s.client.Timeout(channel, username, duration, reason)
+		}
+	case "/delete":
+		if len(args) == 0 {
+			return errors.New("usage: /delete <message id>")
+		}
+		message, err = s.client.DeleteMessage(channel, args[0])
+	case "/clear":
+		message, err = s.client.Clear(channel)
+	case "/slow":
+		interval := 30 * time.Second
+		if len(args) > 0 {
+			seconds, parseErr := strconv.Atoi(args[0])
+			if parseErr != nil {
+				return errors.New("usage: /slow [seconds]")
+			}
+			interval = time.Duration(seconds) * time.Second
+		}
+		message, err = s.client.Slow(channel, interval)
+	case "/slowoff":
+		message, err = s.client.SlowOff(channel)
+	case "/followers":
+		var duration time.Duration
+		if len(args) > 0 {
+			if duration, err = time.ParseDuration(args[0]); err != nil {
+				return errors.New("usage: /followers [duration like 10m or 24h]")
+			}
+		}
+		message, err = s.client.FollowersOnly(channel, duration)
+	case "/followersoff":
+		message, err = s.client.FollowersOnlyOff(channel)
+	case "/subscribers", "/subscribersoff":
+		message, err = s.client.SubscribersOnly(channel, command == "/subscribers")
+	case "/emoteonly", "/emoteonlyoff":
+		message, err = s.client.EmoteOnly(channel, command == "/emoteonly")
+	case "/uniquechat", "/uniquechatoff":
+		message, err = s.client.UniqueChat(channel, command == "/uniquechat")
+	default:
+		return fmt.Errorf("unknown command %s, see /help", command)
+	}
+
+	if err != nil {
+		return err
+	}
+	if message.Text != "" {
+		s.infof("%s", message.Text)
+	}
+	return nil
+}
+
+func (s *session) infof(format string, args ...interface{}) {
+	fmt.Fprintln(s.out, s.printer.info(fmt.Sprintf(format, args...)))
+}
+
+// normalizeChannel returns channel in lower case without the #
+func normalizeChannel(channel string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(channel), "#"))
+}
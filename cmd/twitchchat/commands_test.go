@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+// fakeClient records every call as a line like "Say gempir hello"
+type fakeClient struct {
+	calls []string
+}
+
+func (c *fakeClient) call(format string, args ...interface{}) (twitch.Message, error) {
+	c.calls = append(c.calls, fmt.Sprintf(format, args...))
+	return twitch.Message{Text: "done"}, nil
+}
+
+func (c *fakeClient) Say(channel, text string) error {
+	_, err := c.call("Say %s %s", channel, text)
+	return err
+}
+
+func (c *fakeClient) Whisper(username, text string) error {
+	_, err := c.call("Whisper %s %s", username, text)
+	return err
+}
+
+func (c *fakeClient) Join(channel string)   { c.call("Join %s", channel) }
+func (c *fakeClient) Depart(channel string) { c.call("Depart %s", channel) }
+
+func (c *fakeClient) Ban(channel, username, reason string) (twitch.Message, error) {
+	return c.call("Ban %s %s %s", channel, username, reason)
+}
+
+func (c *fakeClient) Unban(channel, username string) (twitch.Message, error) {
+	return c.call("Unban %s %s", channel, username)
+}
+
+func (c *fakeClient) Timeout(channel, username string, duration time.Duration, reason string) (twitch.Message, error) {
+	return c.call("Timeout %s %s %s %s", channel, username, duration, reason)
+}
+
+func (c *fakeClient) DeleteMessage(channel, msgID string) (twitch.Message, error) {
+	return c.call("DeleteMessage %s %s", channel, msgID)
+}
+
+func (c *fakeClient) Clear(channel string) (twitch.Message, error) {
+	return c.call("Clear %s", channel)
+}
+
+func (c *fakeClient) Slow(channel string, interval time.Duration) (twitch.Message, error) {
+	return c.call("Slow %s %s", channel, interval)
+}
+
+func (c *fakeClient) SlowOff(channel string) (twitch.Message, error) {
+	return c.call("SlowOff %s", channel)
+}
+
+func (c *fakeClient) FollowersOnly(channel string, duration time.Duration) (twitch.Message, error) {
+	return c.call("FollowersOnly %s %s", channel, duration)
+}
+
+func (c *fakeClient) FollowersOnlyOff(channel string) (twitch.Message, error) {
+	return c.call("FollowersOnlyOff %s", channel)
+}
+
+func (c *fakeClient) SubscribersOnly(channel string, enabled bool) (twitch.Message, error) {
+	return c.call("SubscribersOnly %s %t", channel, enabled)
+}
+
+func (c *fakeClient) EmoteOnly(channel string, enabled bool) (twitch.Message, error) {
+	return c.call("EmoteOnly %s %t", channel, enabled)
+}
+
+func (c *fakeClient) UniqueChat(channel string, enabled bool) (twitch.Message, error) {
+	return c.call("UniqueChat %s %t", channel, enabled)
+}
+
+func (c *fakeClient) Mod(channel, username string) (twitch.Message, error) {
+	return c.call("Mod %s %s", channel, username)
+}
+
+func (c *fakeClient) Unmod(channel, username string) (twitch.Message, error) {
+	return c.call("Unmod %s %s", channel, username)
+}
+
+func (c *fakeClient) VIP(channel, username string) (twitch.Message, error) {
+	return c.call("VIP %s %s", channel, username)
+}
+
+func (c *fakeClient) Unvip(channel, username string) (twitch.Message, error) {
+	return c.call("Unvip %s %s", channel, username)
+}
+
+func runInput(t *testing.T, channels []string, input ...string) (*fakeClient, string) {
+	client := &fakeClient{}
+	out := &bytes.Buffer{}
+	s := newSession(client, channels, out, printer{})
+
+	for _, line := range input {
+		if err := s.handleInput(line); err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+	return client, out.String()
+}
+
+func assertCalls(t *testing.T, client *fakeClient, expected ...string) {
+	t.Helper()
+	if strings.Join(client.calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected calls:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(client.calls, "\n"))
+	}
+}
+
+func TestCanSayToCurrentChannel(t *testing.T) {
+	client, _ := runInput(t, []string{"#Gempir", "pajlada"}, "hello", "/me waves", "/channel gempir", "hi")
+
+	assertCalls(t, client, "Join gempir", "Join pajlada", "Say pajlada hello", "Say pajlada /me waves", "Say gempir hi")
+}
+
+func TestCanJoinAndPart(t *testing.T) {
+	client, out := runInput(t, nil, "hello", "/join gempir pajlada", "/part", "hi", "/part gempir", "hi")
+
+	assertCalls(t, client, "Join gempir", "Join pajlada", "Depart pajlada", "Say gempir hi", "Depart gempir")
+	if strings.Count(out, "no channel joined") != 2 {
+		t.Errorf("expected two errors for saying without a channel, got:\n%s", out)
+	}
+}
+
+func TestCanWhisper(t *testing.T) {
+	client, out := runInput(t, nil, "/w Pajlada hello there", "/w pajlada")
+
+	assertCalls(t, client, "Whisper pajlada hello there")
+	if !strings.Contains(out, "usage: /w") {
+		t.Errorf("expected usage, got %s", out)
+	}
+}
+
+func TestCanModerate(t *testing.T) {
+	client, out := runInput(t, []string{"gempir"},
+		"/ban @Troll spamming links",
+		"/timeout troll",
+		"/timeout troll 60 calm down",
+		"/untimeout troll",
+		"/delete 123",
+		"/slow 10",
+		"/followers 24h",
+		"/subscribersoff",
+		"/emoteonly",
+		"/vip pajlada",
+		"/clear",
+	)
+
+	assertCalls(t, client,
+		"Join gempir",
+		"Ban gempir troll spamming links",
+		"Timeout gempir troll 10m0s ",
+		"Timeout gempir troll 1m0s calm down",
+		"Unban gempir troll",
+		"DeleteMessage gempir 123",
+		"Slow gempir 10s",
+		"FollowersOnly gempir 24h0m0s",
+		"SubscribersOnly gempir false",
+		"EmoteOnly gempir true",
+		"VIP gempir pajlada",
+		"Clear gempir",
+	)
+	if strings.Count(out, "-- done") != 11 {
+		t.Errorf("expected the answers of twitch, got:\n%s", out)
+	}
+}
+
+func TestRejectsUnknownCommands(t *testing.T) {
+	client, out := runInput(t, []string{"gempir"}, "/dance", "/ban", "/slow fast")
+
+	assertCalls(t, client, "Join gempir")
+	for _, expected := range []string{"unknown command /dance", "usage: /ban <user>", "usage: /slow [seconds]"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+}
+
+func TestCanQuit(t *testing.T) {
+	s := newSession(&fakeClient{}, nil, &bytes.Buffer{}, printer{})
+
+	if err := s.handleInput("/quit"); err != errQuit {
+		t.Errorf("expected errQuit, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+const (
+	reset = "\x1b[0m"
+	dim   = "\x1b[2m"
+	bold  = "\x1b[1m"
+)
+
+// defaultColors twitch picks one of these for users who never chose a color
+var defaultColors = []string{
+	"#FF0000", "#0000FF", "#008000", "#B22222", "#FF7F50",
+	"#9ACD32", "#FF4500", "#2E8B57", "#DAA520", "#D2691E",
+	"#5F9EA0", "#1E90FF", "#FF69B4", "#8A2BE2", "#00FF7F",
+}
+
+// badge how a badge is shown in front of the name
+type badge struct {
+	label string
+	color string
+}
+
+// badges known by a short label, others are shown by their name
+var badges = map[string]badge{
+	"broadcaster": {label: "broadcaster", color: "#E91916"},
+	"staff":       {label: "staff", color: "#9146FF"},
+	"admin":       {label: "admin", color: "#FAAF19"},
+	"moderator":   {label: "mod", color: "#00AD03"},
+	"vip":         {label: "vip", color: "#E005B9"},
+	"founder":     {label: "founder", color: "#9146FF"},
+	"subscriber":  {label: "sub", color: "#9146FF"},
+	"partner":     {label: "verified", color: "#9146FF"},
+	"premium":     {label: "prime", color: "#00A0D6"},
+	"turbo":       {label: "turbo", color: "#59399A"},
+}
+
+// badgeOrder the order badges are shown in, unknown badges come last
+var badgeOrder = []string{"broadcaster", "staff", "admin", "moderator", "vip", "founder", "subscriber", "partner", "premium", "turbo"}
+
+// printer formats messages for the terminal
+type printer struct {
+	color bool
+}
+
+// paint wraps text in the ANSI escape for the #RRGGBB color hex, text is returned as is without color
+func (p printer) paint(text, hex string) string {
+	if !p.color {
+		return text
+	}
+	r, g, b, ok := parseHex(hex)
+	if !ok {
+		return text
+	}
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm%s%s", r, g, b, text, reset)
+}
+
+func (p printer) style(text, style string) string {
+	if !p.color {
+		return text
+	}
+	return style + text + reset
+}
+
+// userColor returns the color of user, a default color derived from the username if none was chosen
+func userColor(user twitch.User) string {
+	if user.Color != "" {
+		return user.Color
+	}
+	h := fnv.New32a()
+	h.Write([]byte(user.Username))
+	return defaultColors[h.Sum32()%uint32(len(defaultColors))]
+}
+
+// sanitize removes control characters, so chat can not send escape sequences to the terminal
+func sanitize(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f && r < 0xa0 {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// name returns the display name of user, followed by the login if it is different, like Name (login)
+func name(user twitch.User) string {
+	if user.DisplayName == "" {
+		return sanitize(user.Username)
+	}
+	if strings.EqualFold(user.DisplayName, user.Username) || user.Username == "" {
+		return sanitize(user.DisplayName)
+	}
+	return sanitize(user.DisplayName + " (" + user.Username + ")")
+}
+
+// badgeList returns the badges of user, like [mod] [sub]
+func (p printer) badgeList(user twitch.User) string {
+	var names []string
+	for name := range user.Badges {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return badgeRank(names[i]) < badgeRank(names[j]) || badgeRank(names[i]) == badgeRank(names[j]) && names[i] < names[j]
+	})
+
+	var list []string
+	for _, name := range names {
+		b, ok := badges[name]
+		if !ok {
+			b = badge{label: sanitize(name)}
+		}
+		label := "[" + b.label + "]"
+		if b.color != "" {
+			label = p.paint(label, b.color)
+		}
+		list = append(list, label)
+	}
+	return strings.Join(list, " ")
+}
+
+func badgeRank(name string) int {
+	for i, known := range badgeOrder {
+		if known == name {
+			return i
+		}
+	}
+	return len(badgeOrder)
+}
+
+// message formats a chat message: 15:04 #channel [mod] Name: text
+func (p printer) message(channel string, user twitch.User, message twitch.Message) string {
+	var b strings.Builder
+	b.WriteString(p.prefix(message.Time, "#"+channel))
+	if list := p.badgeList(user); list != "" {
+		b.WriteString(list + " ")
+	}
+
+	color := userColor(user)
+	if message.Action {
+		b.WriteString(p.paint(p.style(name(user), bold), color) + " " + p.paint(sanitize(message.Text), color))
+		return b.String()
+	}
+	b.WriteString(p.paint(p.style(name(user), bold), color) + ": " + sanitize(message.Text))
+	return b.String()
+}
+
+// whisper formats a received whisper: 15:04 whisper from Name: text
+func (p printer) whisper(user twitch.User, message twitch.Message) string {
+	return p.prefix(message.Time, "whisper") + "from " + p.paint(p.style(name(user), bold), userColor(user)) + ": " + sanitize(message.Text)
+}
+
+// notice formats an event without a user, like a timeout or a sub: 15:04 #channel * text
+func (p printer) notice(channel string, message twitch.Message, text string) string {
+	return p.prefix(message.Time, "#"+channel) + p.style("* "+sanitize(text), dim)
+}
+
+// info formats a note of the program itself
+func (p printer) info(text string) string {
+	return p.style("-- "+text, dim)
+}
+
+func (p printer) prefix(sent time.Time, location string) string {
+	if sent.IsZero() {
+		sent = time.Now()
+	}
+	return p.style(sent.Local().Format("15:04"), dim) + " " + p.style(location, dim) + " "
+}
+
+// clearchat describes a CLEARCHAT, user is the one who was timed out or banned
+func clearchat(user twitch.User, message twitch.Message) string {
+	if user.Username == "" {
+		return "chat was cleared"
+	}
+	if duration, ok := message.Tags["ban-duration"]; ok {
+		seconds, _ := strconv.Atoi(duration)
+		return fmt.Sprintf("%s was timed out for %s", user.Username, time.Duration(seconds)*time.Second)
+	}
+	return user.Username + " was banned"
+}
+
+// usernotice describes a USERNOTICE, like a sub or raid
+func usernotice(message twitch.Message) string {
+	text := message.Tags["system-msg"]
+	if message.Text != "" {
+		text += " " + message.Text
+	}
+	return strings.TrimSpace(text)
+}
+
+// parseHex parses a color like #1E90FF
+func parseHex(hex string) (r, g, b uint8, ok bool) {
+	if len(hex) != 7 || hex[0] != '#' {
+		return 0, 0, 0, false
+	}
+	value, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return uint8(value >> 16), uint8(value >> 8), uint8(value), true
+}
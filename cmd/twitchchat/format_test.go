@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+var testTime = time.Date(2018, 5, 20, 12, 0, 0, 0, time.Local)
+
+func TestCanFormatMessageWithoutColor(t *testing.T) {
+	user := twitch.User{Username: "gempir", DisplayName: "Gempir", Badges: map[string]int{"subscriber": 12, "moderator": 1, "glhf-pledge": 1}}
+	message := twitch.Message{Time: testTime, Text: "hello"}
+
+	line := printer{}.message("pajlada", user, message)
+
+	expected := "12:00 #pajlada [mod] [sub] [glhf-pledge] Gempir: hello"
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
+
+func TestCanFormatActionWithLocalizedName(t *testing.T) {
+	user := twitch.User{Username: "pajlada", DisplayName: "파요라다"}
+	message := twitch.Message{Time: testTime, Text: "waves", Action: true}
+
+	line := printer{}.message("pajlada", user, message)
+
+	expected := "12:00 #pajlada 파요라다 (pajlada) waves"
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
+
+func TestCanColorNameWithColorTag(t *testing.T) {
+	user := twitch.User{Username: "gempir", DisplayName: "gempir", Color: "#1E90FF"}
+	message := twitch.Message{Time: testTime, Text: "hello"}
+
+	line := printer{color: true}.message("gempir", user, message)
+
+	expected := "\x1b[2m12:00\x1b[0m \x1b[2m#gempir\x1b[0m \x1b[38;2;30;144;255m\x1b[1mgempir\x1b[0m\x1b[0m: hello"
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
+
+func TestUsersWithoutColorKeepTheirDefault(t *testing.T) {
+	user := twitch.User{Username: "gempir"}
+
+	if userColor(user) != userColor(user) {
+		t.Error("default color changed")
+	}
+	if _, _, _, ok := parseHex(userColor(user)); !ok {
+		t.Errorf("invalid default color %s", userColor(user))
+	}
+}
+
+func TestCanNotWriteEscapeSequencesToTerminal(t *testing.T) {
+	user := twitch.User{Username: "gempir"}
+	message := twitch.Message{Time: testTime, Text: "\x1b[2Jgone\x07"}
+
+	line := printer{}.message("gempir", user, message)
+
+	expected := "12:00 #gempir gempir: [2Jgone"
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
+
+func TestCanDescribeEvents(t *testing.T) {
+	_, user, timeout := twitch.ParseMessage("@ban-duration=600;room-id=11148817;target-user-id=78424343 :tmi.twitch.tv CLEARCHAT #pajlada :redflamingo13")
+	_, _, clear := twitch.ParseMessage("@room-id=11148817 :tmi.twitch.tv CLEARCHAT #pajlada")
+	_, _, resub := twitch.ParseMessage(`@login=gempir;msg-id=resub;system-msg=gempir\ssubscribed\sfor\s12\smonths! :tmi.twitch.tv USERNOTICE #pajlada :still here`)
+
+	for expected, actual := range map[string]string{
+		"redflamingo13 was timed out for 10m0s":       clearchat(*user, *timeout),
+		"chat was cleared":                            clearchat(twitch.User{}, *clear),
+		"gempir subscribed for 12 months! still here": usernotice(*resub),
+	} {
+		if actual != expected {
+			t.Errorf("expected %q, got %q", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Command twitchchat is a terminal chat client for watching and talking in twitch chat
+//
+//	TWITCH_TOKEN=oauth:123 twitchchat --username mybot gempir pajlada
+//
+// Every line typed is sent to the current channel, /help lists the commands.
+// Without a token the client joins anonymously and can only read.
+// With --raw the IRC lines are printed as they are sent and received, instead of the formatted messages.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+// drainTimeout how long queued messages may take to be sent once the input ends
+const drainTimeout = time.Second * 5
+
+// options the command line flags
+type options struct {
+	username string
+	token    string
+	raw      bool
+	noColor  bool
+	address  string
+	tls      bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command and returns its exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("twitchchat", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: twitchchat [flags] channel...")
+		flags.PrintDefaults()
+	}
+
+	var opts options
+	flags.StringVar(&opts.username, "username", os.Getenv("TWITCH_USERNAME"), "login of the account to chat as, defaults to $TWITCH_USERNAME")
+	flags.StringVar(&opts.token, "token", os.Getenv("TWITCH_TOKEN"), "oauth token of the account, defaults to $TWITCH_TOKEN, read-only without one")
+	flags.BoolVar(&opts.raw, "raw", false, "print the raw IRC lines sent and received")
+	flags.BoolVar(&opts.noColor, "no-color", os.Getenv("NO_COLOR") != "" || !isTerminal(stdout), "disable colors, the default when $NO_COLOR is set or the output is not a terminal")
+	flags.StringVar(&opts.address, "address", "", "IRC server to connect to, defaults to twitch")
+	flags.BoolVar(&opts.tls, "tls", true, "connect with TLS")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	out := &syncWriter{w: stdout}
+	p := printer{color: !opts.noColor}
+
+	client, err := newClient(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "twitchchat: %s\n", err)
+		return 2
+	}
+	attach(client, out, p, opts.raw)
+
+	s := newSession(client, flags.Args(), out, p)
+	if client.Anonymous() {
+		s.infof("no token given, reading anonymously")
+	}
+
+	connectErr := make(chan error, 1)
+	go func() {
+		connectErr <- client.Connect()
+	}()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for {
+		select {
+		case err := <-connectErr:
+			fmt.Fprintf(stderr, "twitchchat: %s\n", err)
+			return 1
+		case line, ok := <-lines:
+			if !ok {
+				drain(client)
+				client.Disconnect()
+				return 0
+			}
+			if err := s.handleInput(line); err == errQuit {
+				client.Disconnect()
+				return 0
+			} else if err != nil {
+				s.infof("%s", err)
+			}
+		}
+	}
+}
+
+// newClient creates the client for opts, anonymous without a token
+func newClient(opts options) (*twitch.Client, error) {
+	var client *twitch.Client
+	if opts.token == "" {
+		client = twitch.NewAnonymousClient()
+	} else {
+		if opts.username == "" {
+			return nil, fmt.Errorf("--username is required with a token")
+		}
+		token := opts.token
+		if !strings.HasPrefix(token, "oauth:") {
+			token = "oauth:" + token
+		}
+		client = twitch.NewClient(strings.ToLower(opts.username), token)
+	}
+
+	client.IrcAddress = opts.address
+	client.TLS = opts.tls
+	return client, nil
+}
+
+// attach prints what client receives to out, formatted or as raw lines
+func attach(client *twitch.Client, out io.Writer, p printer, raw bool) {
+	if raw {
+		client.Logger = &rawLogger{out: out, printer: p}
+		return
+	}
+	client.Logger = twitch.NewStdLogger(log.New(out, "-- ", 0), twitch.LevelWarn)
+
+	client.OnConnect(func() {
+		fmt.Fprintln(out, p.info("connected"))
+	})
+	client.OnNewMessage(func(channel string, user twitch.User, message twitch.Message) {
+		fmt.Fprintln(out, p.message(channel, user, message))
+	})
+	client.OnNewWhisper(func(user twitch.User, message twitch.Message) {
+		fmt.Fprintln(out, p.whisper(user, message))
+	})
+	client.OnNewClearchatMessage(func(channel string, user twitch.User, message twitch.Message) {
+		fmt.Fprintln(out, p.notice(channel, message, clearchat(user, message)))
+	})
+	client.OnNewUsernoticeMessage(func(channel string, user twitch.User, message twitch.Message) {
+		fmt.Fprintln(out, p.notice(channel, message, usernotice(message)))
+	})
+	client.OnNewNoticeMessage(func(channel string, user twitch.User, message twitch.Message) {
+		fmt.Fprintln(out, p.notice(channel, message, message.Text))
+	})
+}
+
+// rawLogger prints the raw lines the client logs at debug level, and every warning and error
+type rawLogger struct {
+	out     io.Writer
+	printer printer
+}
+
+func (l *rawLogger) Enabled(level twitch.LogLevel) bool {
+	return true
+}
+
+func (l *rawLogger) Log(level twitch.LogLevel, msg string, args ...interface{}) {
+	if level == twitch.LevelDebug {
+		var line string
+		for i := 0; i+1 < len(args); i += 2 {
+			if args[i] == "line" {
+				line, _ = args[i+1].(string)
+			}
+		}
+		switch msg {
+		case "received":
+			fmt.Fprintln(l.out, l.printer.style("< ", dim)+sanitize(line))
+		case "sent":
+			fmt.Fprintln(l.out, l.printer.style("> ", dim)+sanitize(line))
+		}
+		return
+	}
+	if level >= twitch.LevelWarn {
+		for i := 0; i+1 < len(args); i += 2 {
+			msg += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+		}
+		fmt.Fprintln(l.out, l.printer.info(msg))
+	}
+}
+
+// drain waits up to drainTimeout for the queued messages to be sent
+func drain(client *twitch.Client) {
+	deadline := time.Now().Add(drainTimeout)
+	for len(client.PendingMessages()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+// syncWriter serializes the writes of the client callbacks and the input loop
+type syncWriter struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return w.w.Write(p)
+}
+
+// isTerminal reports whether w is a terminal, colors are only written to terminals by default
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
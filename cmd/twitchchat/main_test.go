@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+// syncBuffer a bytes.Buffer safe to read while the command still writes to it
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func waitForOutput(t *testing.T, out *syncBuffer, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 3)
+	for !strings.Contains(out.String(), expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q in output:\n%s", expected, out.String())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func newServer(t *testing.T) *twitchtest.Server {
+	server := twitchtest.NewServer()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func startTwitchchat(t *testing.T, server *twitchtest.Server, args ...string) (io.WriteCloser, *syncBuffer, <-chan int) {
+	stdin, input := io.Pipe()
+	out := &syncBuffer{}
+	exit := make(chan int, 1)
+
+	args = append([]string{"--address", server.Addr, "--tls=false", "--no-color"}, args...)
+	go func() {
+		exit <- run(args, stdin, out, out)
+	}()
+
+	return input, out, exit
+}
+
+func waitForExit(t *testing.T, exit <-chan int) int {
+	select {
+	case code := <-exit:
+		return code
+	case <-time.After(time.Second * 3):
+		t.Fatal("twitchchat did not exit")
+	}
+	return -1
+}
+
+func TestCanChat(t *testing.T) {
+	server := newServer(t)
+	input, out, exit := startTwitchchat(t, server, "--username", "Gempir", "--token", "123", "gempir")
+
+	if _, err := server.WaitFor("JOIN #gempir", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	received := strings.Join(server.Received(), "\n")
+	if !strings.Contains(received, "PASS oauth:123") || !strings.Contains(received, "NICK gempir") {
+		t.Errorf("unexpected login:\n%s", received)
+	}
+
+	server.Send("@badges=moderator/1;color=#FF0000;display-name=Pajlada :pajlada!pajlada@pajlada.tmi.twitch.tv PRIVMSG #gempir :hello")
+	waitForOutput(t, out, "#gempir [mod] Pajlada: hello")
+
+	io.WriteString(input, "hi there\n")
+	if _, err := server.WaitFor("PRIVMSG #gempir :hi there", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+
+	io.WriteString(input, "/quit\n")
+	if code := waitForExit(t, exit); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+}
+
+func TestCanPrintRawLines(t *testing.T) {
+	server := newServer(t)
+	input, out, exit := startTwitchchat(t, server, "--raw", "--username", "gempir", "--token", "oauth:123", "gempir")
+
+	if _, err := server.WaitFor("JOIN #gempir", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	server.Send("@id=1 :pajlada!pajlada@pajlada.tmi.twitch.tv PRIVMSG #gempir :hello")
+
+	waitForOutput(t, out, "< @id=1 :pajlada!pajlada@pajlada.tmi.twitch.tv PRIVMSG #gempir :hello")
+	waitForOutput(t, out, "> JOIN #gempir")
+	waitForOutput(t, out, "> PASS ***")
+	if strings.Contains(out.String(), "oauth:123") {
+		t.Error("token was printed")
+	}
+
+	input.Close()
+	waitForExit(t, exit)
+}
+
+func TestExitsWhenLoginFails(t *testing.T) {
+	server := newServer(t)
+	server.FailAuth(true)
+	_, out, exit := startTwitchchat(t, server, "--username", "gempir", "--token", "oauth:123", "gempir")
+
+	if code := waitForExit(t, exit); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(out.String(), "login authentication failed") {
+		t.Errorf("expected the error in the output:\n%s", out.String())
+	}
+}
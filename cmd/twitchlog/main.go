@@ -0,0 +1,161 @@
+// Command twitchlog searches chat logs written by the chatlog package, or sessions recorded with twitch.Recorder
+//
+//	twitchlog --channel gempir --user pajlada --since 2018-05-20 logs/
+//	twitchlog --type USERNOTICE --output json logs/gempir/2018-05-20.jsonl.gz
+//	twitchlog --stats --top 20 logs/
+//
+// Directories are searched for .log, .jsonl and .irc files, gzipped or not.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/gempir/go-twitch-irc/chatlog"
+)
+
+// options the command line flags
+type options struct {
+	channels string
+	users    string
+	types    string
+	since    string
+	until    string
+	grep     string
+	output   string
+	format   string
+	stats    bool
+	top      int
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command and returns its exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("twitchlog", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: twitchlog [flags] file or directory...")
+		flags.PrintDefaults()
+	}
+
+	var opts options
+	flags.StringVar(&opts.channels, "channel", "", "only channels in this comma separated list")
+	flags.StringVar(&opts.users, "user", "", "only users in this comma separated list, by login or display name")
+	flags.StringVar(&opts.types, "type", "", "only message types in this comma separated list, like PRIVMSG,USERNOTICE")
+	flags.StringVar(&opts.since, "since", "", "only messages at or after this time, like 2018-05-20 or 2018-05-20T12:00:00Z")
+	flags.StringVar(&opts.until, "until", "", "only messages before this time")
+	flags.StringVar(&opts.grep, "grep", "", "only messages whose text matches this regular expression, prefix with (?i) to ignore case")
+	flags.StringVar(&opts.output, "output", "text", "print results as text or json")
+	flags.StringVar(&opts.format, "format", "auto", "format of the files: auto, text, json, raw or recording")
+	flags.BoolVar(&opts.stats, "stats", false, "print messages per user, top emotes and subs instead of the messages")
+	flags.IntVar(&opts.top, "top", 10, "number of users and emotes listed by --stats, 0 for all")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if opts.output != "text" && opts.output != "json" {
+		fmt.Fprintf(stderr, "twitchlog: unknown output %q, use text or json\n", opts.output)
+		return 2
+	}
+
+	f, err := newFilter(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "twitchlog: %s\n", err)
+		return 2
+	}
+
+	files, err := logFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "twitchlog: %s\n", err)
+		return 1
+	}
+
+	if opts.stats {
+		err = printStats(files, opts, f, stdout, stderr)
+	} else {
+		err = printEntries(files, opts, f, stdout, stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "twitchlog: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func newFilter(opts options) (*filter, error) {
+	f := &filter{
+		channels: set(opts.channels, false),
+		users:    set(opts.users, false),
+		types:    set(opts.types, true),
+	}
+
+	var err error
+	if f.since, err = parseTime(opts.since); err != nil {
+		return nil, err
+	}
+	if f.until, err = parseTime(opts.until); err != nil {
+		return nil, err
+	}
+	if opts.grep != "" {
+		if f.text, err = regexp.Compile(opts.grep); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// printEntries prints every matching entry, as a line of text or one JSON object per line
+func printEntries(files []string, opts options, f *filter, stdout, stderr io.Writer) error {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetEscapeHTML(false)
+
+	var writeErr error
+	err := query(files, opts.format, f, func(entry chatlog.Entry) {
+		if writeErr != nil {
+			return
+		}
+		if opts.output == "json" {
+			writeErr = encoder.Encode(entry)
+			return
+		}
+		_, writeErr = fmt.Fprintln(stdout, entry.String())
+	}, stderr)
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// printStats prints the stats of every matching entry
+func printStats(files []string, opts options, f *filter, stdout, stderr io.Writer) error {
+	for _, file := range files {
+		if isTextLog(file, opts.format) {
+			fmt.Fprintf(stderr, "%s: text logs have no tags, their emotes and subs are not counted\n", file)
+		}
+	}
+
+	s := newStats()
+	if err := query(files, opts.format, f, s.add, stderr); err != nil {
+		return err
+	}
+
+	report := s.report(opts.top)
+	if opts.output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	report.writeText(stdout)
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLog = `@badges=;display-name=Gempir;emotes=25:0-4,6-10;tmi-sent-ts=1526817600000;user-id=77829817 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :Kappa Kappa hello
+@room-id=1;slow=0 :tmi.twitch.tv ROOMSTATE #gempir
+@badges=;display-name=pajlada;emotes=;tmi-sent-ts=1526817660000;user-id=11148817 :pajlada!pajlada@pajlada.tmi.twitch.tv PRIVMSG #gempir :forsen
+@badges=;display-name=Gempir;emotes=;tmi-sent-ts=1526904000000;user-id=77829817 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :next day
+@login=pajlada;msg-id=resub;system-msg=pajlada\ssubscribed;tmi-sent-ts=1526817700000 :tmi.twitch.tv USERNOTICE #gempir :still here
+@login=gempir;msg-id=submysterygift;msg-param-mass-gift-count=5;system-msg=gempir\sis\sgifting\s5\ssubs;tmi-sent-ts=1526817800000 :tmi.twitch.tv USERNOTICE #gempir
+@login=gempir;msg-id=subgift;msg-param-community-gift-id=1;tmi-sent-ts=1526817801000 :tmi.twitch.tv USERNOTICE #gempir
+@login=gempir;msg-id=subgift;tmi-sent-ts=1526817900000 :tmi.twitch.tv USERNOTICE #gempir
+@badges=;display-name=forsen;emotes=;tmi-sent-ts=1526817600000 :forsen!forsen@forsen.tmi.twitch.tv PRIVMSG #forsen :hi
+`
+
+func writeTestLogs(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "twitchlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err := os.MkdirAll(filepath.Join(dir, "gempir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "gempir", "2018-05-20.irc"), []byte(testLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "gempir", "notes.md"), []byte("not a log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func runTwitchlog(t *testing.T, args ...string) string {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(args, stdout, stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestCanFilterByChannelUserAndTime(t *testing.T) {
+	dir := writeTestLogs(t)
+
+	output := runTwitchlog(t, "--channel", "#gempir", "--user", "GEMPIR", "--type", "privmsg", "--since", "2018-05-20", "--until", "2018-05-21", dir)
+
+	expected := "[2018-05-20 12:00:00] #gempir gempir: Kappa Kappa hello\n"
+	if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestCanFilterByText(t *testing.T) {
+	dir := writeTestLogs(t)
+
+	output := runTwitchlog(t, "--grep", "(?i)^FORSEN$", dir)
+
+	expected := "[2018-05-20 12:01:00] #gempir pajlada: forsen\n"
+	if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestCanPrintJSON(t *testing.T) {
+	dir := writeTestLogs(t)
+
+	output := runTwitchlog(t, "--type", "USERNOTICE", "--output", "json", dir)
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d: %s", len(lines), output)
+	}
+	var entry struct {
+		Type     string
+		Username string
+		Text     string
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Type != "USERNOTICE" || entry.Username != "pajlada" || entry.Text != "still here" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
+
+func TestCanComputeStats(t *testing.T) {
+	dir := writeTestLogs(t)
+
+	output := runTwitchlog(t, "--stats", "--output", "json", "--channel", "gempir", dir)
+
+	var report statsReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Messages != 3 {
+		t.Errorf("expected 3 messages, got %d", report.Messages)
+	}
+	if len(report.Users) != 2 || report.Users[0] != (ranked{Name: "gempir", Count: 2}) {
+		t.Errorf("unexpected users %+v", report.Users)
+	}
+	if len(report.Emotes) != 1 || report.Emotes[0] != (ranked{Name: "Kappa", Count: 2}) {
+		t.Errorf("unexpected emotes %+v", report.Emotes)
+	}
+	if report.Subs != (subStats{Resubs: 1, Gifted: 6}) {
+		t.Errorf("unexpected subs %+v", report.Subs)
+	}
+}
+
+func TestRawLinesWithoutTimeMatchSince(t *testing.T) {
+	dir := writeTestLogs(t)
+
+	output := runTwitchlog(t, "--type", "ROOMSTATE", "--since", "2018-05-20T12:00:00Z", dir)
+
+	expected := "[2018-05-20 12:00:00] #gempir room settings slow=0\n"
+	if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestCanFilterEventsOfTextLogs(t *testing.T) {
+	dir := writeTestLogs(t)
+	textLog := "[2018-05-20 12:00:00] #gempir gempir: hello\n" +
+		"[2018-05-20 12:01:40] #gempir USERNOTICE pajlada: pajlada subscribed still here\n" +
+		"[2018-05-20 12:02:00] #gempir pajlada subscribed\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "gempir", "2018-05-21.log"), []byte(textLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"--type", "USERNOTICE", "--user", "pajlada", "--since", "2018-05-20", filepath.Join(dir, "gempir", "2018-05-21.log")}, stdout, stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+
+	expected := "[2018-05-20 12:01:40] #gempir USERNOTICE pajlada: pajlada subscribed still here\n"
+	if stdout.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stdout.String())
+	}
+	// the last line was written before the type was part of the line
+	if !strings.Contains(stderr.String(), "some lines have no type") {
+		t.Errorf("missing warning about lines without type: %q", stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"--stats", dir}, stdout, stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "2018-05-21.log: text logs have no tags") {
+		t.Errorf("missing warning about stats of text logs: %q", stderr.String())
+	}
+}
+
+func TestCanReadRecording(t *testing.T) {
+	dir := writeTestLogs(t)
+	recording := filepath.Join(dir, "session.rec")
+	content := "2018-05-20T12:00:00Z > PASS ***\n" +
+		"2018-05-20T12:00:01Z < :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :recorded\n"
+	if err := ioutil.WriteFile(recording, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := runTwitchlog(t, "--format", "recording", recording)
+
+	expected := "[2018-05-20 12:00:01] #gempir gempir: recorded\n"
+	if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestRejectsInvalidFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"--output", "xml", "."},
+		{"--since", "yesterday", "."},
+		{"--grep", "(", "."},
+	} {
+		if code := run(args, ioutil.Discard, ioutil.Discard); code != 2 {
+			t.Errorf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+	"github.com/gempir/go-twitch-irc/chatlog"
+)
+
+// filter decides which entries are printed and counted
+type filter struct {
+	channels map[string]bool
+	users    map[string]bool
+	types    map[string]bool
+	since    time.Time
+	until    time.Time
+	text     *regexp.Regexp
+}
+
+// match reports whether entry passes every set condition
+func (f *filter) match(entry chatlog.Entry) bool {
+	if len(f.channels) > 0 && !f.channels[strings.ToLower(entry.Channel)] {
+		return false
+	}
+	if len(f.users) > 0 && !f.users[strings.ToLower(entry.Username)] && !f.users[strings.ToLower(entry.DisplayName)] {
+		return false
+	}
+	if len(f.types) > 0 && !f.types[entry.Type] {
+		return false
+	}
+	if !f.since.IsZero() && entry.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !entry.Time.Before(f.until) {
+		return false
+	}
+	if f.text != nil && !f.text.MatchString(entry.Text) {
+		return false
+	}
+	return true
+}
+
+// set returns the lowercased, comma separated values of list as a set, nil for an empty list
+func set(list string, upper bool) map[string]bool {
+	if list == "" {
+		return nil
+	}
+
+	values := map[string]bool{}
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+		if upper {
+			value = strings.ToUpper(value)
+		} else {
+			value = strings.ToLower(value)
+		}
+		if value != "" {
+			values[value] = true
+		}
+	}
+	return values
+}
+
+// timeLayouts accepted by --since and --until, dates are midnight UTC
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use 2006-01-02, 2006-01-02 15:04:05 or RFC 3339", value)
+}
+
+// logFiles returns the log files of paths, directories are searched recursively, sorted by path
+func logFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			// files given explicitly are read no matter their name, so recordings can be queried as well
+			if _, ok := chatlog.FormatFromPath(file); ok || file == path {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// entryReader reads the entries of one file
+type entryReader interface {
+	Next() (chatlog.Entry, error)
+	Close() error
+}
+
+// openLog opens file in format, "auto" takes the format from the extension
+func openLog(file, format string) (entryReader, error) {
+	switch format {
+	case "auto":
+		if _, ok := chatlog.FormatFromPath(file); !ok {
+			return nil, fmt.Errorf("unknown format of %s, set --format", file)
+		}
+		return chatlog.OpenFile(file)
+	case "recording":
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingReader{reader: twitch.NewRecordingReader(f), file: f}, nil
+	}
+
+	formats := map[string]chatlog.Format{"text": chatlog.FormatText, "json": chatlog.FormatJSON, "raw": chatlog.FormatRaw}
+	logFormat, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &closingReader{Reader: chatlog.NewReader(f, logFormat), file: f}, nil
+}
+
+// closingReader closes the file of a chatlog.Reader created with NewReader
+type closingReader struct {
+	*chatlog.Reader
+	file *os.File
+}
+
+func (r *closingReader) Close() error {
+	return r.file.Close()
+}
+
+// recordingReader reads the received messages of a session recorded with twitch.Recorder
+type recordingReader struct {
+	reader *twitch.RecordingReader
+	file   *os.File
+}
+
+func (r *recordingReader) Next() (chatlog.Entry, error) {
+	for {
+		line, err := r.reader.Next()
+		if err != nil {
+			return chatlog.Entry{}, err
+		}
+		if line.Direction != twitch.DirectionReceived {
+			continue
+		}
+
+		message := twitch.ParseRawMessage(line.Line)
+		if message.Channel() == "" {
+			continue
+		}
+		return chatlog.NewEntry(message, line.Time), nil
+	}
+}
+
+func (r *recordingReader) Close() error {
+	return r.file.Close()
+}
+
+// isTextLog reports whether file is read as a FormatText log, which has no tags
+func isTextLog(file, format string) bool {
+	if format == "auto" {
+		logFormat, ok := chatlog.FormatFromPath(file)
+		return ok && logFormat == chatlog.FormatText
+	}
+	return format == "text"
+}
+
+// query reads every file in format and calls match for each entry passing f
+// lines that can not be parsed are reported to errs and skipped,
+// as well as the first line of a file that can not match a set filter because it has no type or time
+func query(files []string, format string, f *filter, match func(chatlog.Entry), errs io.Writer) error {
+	for _, file := range files {
+		reader, err := openLog(file, format)
+		if err != nil {
+			return err
+		}
+
+		var untyped, untimed bool
+		for {
+			entry, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err == chatlog.ErrInvalidLine || err == twitch.ErrInvalidRecording {
+				fmt.Fprintf(errs, "%s: skipping invalid line\n", file)
+				continue
+			}
+			if err != nil {
+				reader.Close()
+				return fmt.Errorf("%s: %s", file, err)
+			}
+
+			if entry.Type == "" && (len(f.types) > 0 || len(f.users) > 0) && !untyped {
+				untyped = true
+				fmt.Fprintf(errs, "%s: some lines have no type, --type and --user can not match them\n", file)
+			}
+			if entry.Time.IsZero() && (!f.since.IsZero() || !f.until.IsZero()) && !untimed {
+				untimed = true
+				fmt.Fprintf(errs, "%s: some lines have no time, --since and --until can not match them\n", file)
+			}
+
+			if f.match(entry) {
+				match(entry)
+			}
+		}
+		reader.Close()
+	}
+	return nil
+}
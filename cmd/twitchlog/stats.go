@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	twitch "github.com/gempir/go-twitch-irc"
+	"github.com/gempir/go-twitch-irc/chatlog"
+)
+
+// stats counts what happened in the matched entries
+// emotes and subs need the tags of the JSON and raw formats, text logs only count messages
+type stats struct {
+	Messages int            `json:"messages"`
+	Users    map[string]int `json:"users"`
+	Emotes   map[string]int `json:"emotes"`
+	Subs     subStats       `json:"subs"`
+}
+
+// subStats the subscriptions announced in USERNOTICEs
+type subStats struct {
+	Subs   int `json:"subs"`
+	Resubs int `json:"resubs"`
+	// Gifted counts every gifted subscription, a mass gift counts as many as were given away
+	Gifted int `json:"gifted"`
+}
+
+func newStats() *stats {
+	return &stats{
+		Users:  map[string]int{},
+		Emotes: map[string]int{},
+	}
+}
+
+// add counts entry
+func (s *stats) add(entry chatlog.Entry) {
+	switch entry.Type {
+	case twitch.PRIVMSG.String():
+		s.Messages++
+		s.Users[entry.Username]++
+		if entry.Raw != "" {
+			for _, emote := range twitch.ParseRawMessage(entry.Raw).Emotes() {
+				s.Emotes[emote.Name] += emote.Count
+			}
+		}
+	case twitch.USERNOTICE.String():
+		switch entry.Tags["msg-id"] {
+		case "sub":
+			s.Subs.Subs++
+		case "resub":
+			s.Subs.Resubs++
+		case "subgift", "anonsubgift":
+			// the gifts of a mass gift are announced one by one as well, they are counted with the submysterygift
+			if entry.Tags["msg-param-community-gift-id"] == "" {
+				s.Subs.Gifted++
+			}
+		case "submysterygift", "anonsubmysterygift":
+			count, _ := strconv.Atoi(entry.Tags["msg-param-mass-gift-count"])
+			s.Subs.Gifted += count
+		}
+	}
+}
+
+// ranked a name and how often it was counted
+type ranked struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// top returns the n entries of counts with the highest count, ties sorted by name
+func top(counts map[string]int, n int) []ranked {
+	list := make([]ranked, 0, len(counts))
+	for name, count := range counts {
+		list = append(list, ranked{Name: name, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// statsReport the stats as printed, with the users and emotes ranked
+type statsReport struct {
+	Messages int      `json:"messages"`
+	Users    []ranked `json:"topUsers"`
+	Emotes   []ranked `json:"topEmotes"`
+	Subs     subStats `json:"subs"`
+}
+
+func (s *stats) report(n int) statsReport {
+	return statsReport{
+		Messages: s.Messages,
+		Users:    top(s.Users, n),
+		Emotes:   top(s.Emotes, n),
+		Subs:     s.Subs,
+	}
+}
+
+func (r statsReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "messages: %d\n", r.Messages)
+
+	fmt.Fprintln(w, "\ntop users:")
+	for _, user := range r.Users {
+		fmt.Fprintf(w, "  %-25s %d\n", user.Name, user.Count)
+	}
+
+	fmt.Fprintln(w, "\ntop emotes:")
+	for _, emote := range r.Emotes {
+		fmt.Fprintf(w, "  %-25s %d\n", emote.Name, emote.Count)
+	}
+
+	fmt.Fprintf(w, "\nsubs: %d\nresubs: %d\ngifted subs: %d\n", r.Subs.Subs, r.Subs.Resubs, r.Subs.Gifted)
+}
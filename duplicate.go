@@ -0,0 +1,157 @@
+package twitch
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// duplicateWindow how long twitch rejects a message identical to the previous one sent to the same channel
+	duplicateWindow = time.Second * 30
+	// duplicateBypass appended to a repeated message, an invisible tag character twitch does not strip
+	duplicateBypass = " \U000E0000"
+	// answerTimeout how long a written message waits for twitch to accept or reject it before it is forgotten
+	answerTimeout = time.Second * 10
+)
+
+// sentMessage a message written to a channel
+type sentMessage struct {
+	line    string
+	message *queuedMessage
+	sent    time.Time
+}
+
+// duplicateTracker remembers the messages sent to each channel, for BypassDuplicates
+type duplicateTracker struct {
+	mtx  sync.Mutex
+	last map[string]*sentMessage
+	// inflight the messages twitch did not answer yet per channel, oldest first
+	// twitch answers every message in order, with a USERSTATE if it was accepted or a NOTICE if it was rejected
+	inflight map[string][]*sentMessage
+	// joining channels whose JOIN was not answered with a ROOMSTATE yet, a USERSTATE answers the JOIN and not a message
+	joining map[string]bool
+}
+
+func newDuplicateTracker() *duplicateTracker {
+	return &duplicateTracker{
+		last:     map[string]*sentMessage{},
+		inflight: map[string][]*sentMessage{},
+		joining:  map[string]bool{},
+	}
+}
+
+// bypass returns the line to write for message, altered if twitch would reject it as a duplicate of the previous one
+func (d *duplicateTracker) bypass(message *queuedMessage, now time.Time) string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	line := message.Line
+	if last, ok := d.last[message.Channel]; ok && last.line == line && now.Sub(last.sent) < duplicateWindow {
+		line += duplicateBypass
+	}
+	sent := &sentMessage{line: line, message: message, sent: now}
+	d.last[message.Channel] = sent
+	d.inflight[message.Channel] = append(d.unanswered(message.Channel, now), sent)
+
+	return line
+}
+
+// reset forgets the unanswered messages and joins of a previous connection
+func (d *duplicateTracker) reset() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.inflight = map[string][]*sentMessage{}
+	d.joining = map[string]bool{}
+}
+
+// join marks channel as joining until its ROOMSTATE arrives
+func (d *duplicateTracker) join(channel string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.joining[channel] = true
+}
+
+// joined handles a ROOMSTATE of channel, which ends a join
+func (d *duplicateTracker) joined(channel string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	delete(d.joining, channel)
+}
+
+// accepted handles a USERSTATE of channel, which answers the oldest unanswered message unless the channel is being joined
+func (d *duplicateTracker) accepted(channel string, now time.Time) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if !d.joining[channel] {
+		d.answer(channel, now)
+	}
+}
+
+// rejected handles a NOTICE of channel rejecting a message, it returns the rejected message or nil if it is not known
+func (d *duplicateTracker) rejected(channel string, now time.Time) *sentMessage {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.answer(channel, now)
+}
+
+// answer removes the oldest unanswered message of channel
+func (d *duplicateTracker) answer(channel string, now time.Time) *sentMessage {
+	inflight := d.unanswered(channel, now)
+	if len(inflight) == 0 {
+		delete(d.inflight, channel)
+		return nil
+	}
+
+	d.inflight[channel] = inflight[1:]
+	return inflight[0]
+}
+
+// unanswered returns the messages of channel still waiting for an answer, dropping those waiting longer than answerTimeout
+func (d *duplicateTracker) unanswered(channel string, now time.Time) []*sentMessage {
+	inflight := d.inflight[channel]
+	for len(inflight) > 0 && now.Sub(inflight[0].sent) > answerTimeout {
+		inflight = inflight[1:]
+	}
+	return inflight
+}
+
+// rejectedMessage handles a NOTICE rejecting a message, a message rejected with msg_duplicate is queued again, once
+func (c *Client) rejectedMessage(channel, msgID string) {
+	sent := c.duplicates.rejected(channel, time.Now())
+	if sent == nil || msgID != "msg_duplicate" || !c.BypassDuplicates || sent.message.retried {
+		return
+	}
+
+	retry := *sent.message
+	retry.Line = sent.line
+	retry.retried = true
+	retry.held = time.Time{}
+	c.outgoing.requeue(&retry)
+	c.outgoing.signal()
+}
+
+// chatRejections the msg-ids of NOTICEs twitch answers a rejected chat message with
+var chatRejections = map[string]bool{
+	"msg_duplicate":                      true,
+	"msg_slowmode":                       true,
+	"msg_followersonly":                  true,
+	"msg_followersonly_followed":         true,
+	"msg_followersonly_zero":             true,
+	"msg_subsonly":                       true,
+	"msg_emoteonly":                      true,
+	"msg_r9k":                            true,
+	"msg_verified_email":                 true,
+	"msg_requires_verified_phone_number": true,
+	"msg_rejected":                       true,
+	"msg_rejected_mandatory":             true,
+}
+
+// isRejection reports whether the msg-id of a NOTICE rejects a chat message, like msg_duplicate or msg_slowmode
+func isRejection(msgID string) bool {
+	return chatRejections[msgID]
+}
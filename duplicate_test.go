@@ -0,0 +1,176 @@
+package twitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+func TestDuplicateMessagesAreAltered(t *testing.T) {
+	tracker := newDuplicateTracker()
+	now := time.Now()
+	message := &queuedMessage{Line: "PRIVMSG #gempir :hello", Channel: "gempir"}
+
+	assertStringsEqual(t, "PRIVMSG #gempir :hello", tracker.bypass(message, now))
+	assertStringsEqual(t, "PRIVMSG #gempir :hello"+duplicateBypass, tracker.bypass(message, now))
+	// the previous message was altered, so the original text is no duplicate anymore
+	assertStringsEqual(t, "PRIVMSG #gempir :hello", tracker.bypass(message, now))
+	assertStringsEqual(t, "PRIVMSG #gempir :hello", tracker.bypass(message, now.Add(duplicateWindow)))
+
+	other := &queuedMessage{Line: "PRIVMSG #pajlada :hello", Channel: "pajlada"}
+	assertStringsEqual(t, "PRIVMSG #pajlada :hello", tracker.bypass(other, now.Add(duplicateWindow)))
+}
+
+func TestCanBypassDuplicateMessages(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newQueueTestClient(server.Addr)
+	client.BypassDuplicates = true
+	client.Say("gempir", "announcement")
+	client.Say("gempir", "announcement")
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 2, "PRIVMSG")
+
+	assertStringSlicesEqual(t, []string{
+		"PRIVMSG #gempir :announcement",
+		"PRIVMSG #gempir :announcement" + duplicateBypass,
+	}, privmsgs(server.Received()))
+}
+
+func TestBypassesDuplicatesInMixedCaseChannel(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newQueueTestClient(server.Addr)
+	client.BypassDuplicates = true
+	client.Say("Gempir", "announcement")
+	client.Say("gempir", "announcement")
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 2, "PRIVMSG")
+
+	assertStringSlicesEqual(t, []string{
+		"PRIVMSG #gempir :announcement",
+		"PRIVMSG #gempir :announcement" + duplicateBypass,
+	}, privmsgs(server.Received()))
+}
+
+func TestOnlyChatRejectionsAnswerMessages(t *testing.T) {
+	assertTrue(t, isRejection("msg_duplicate"), "msg_duplicate rejects a chat message")
+	assertTrue(t, isRejection("msg_slowmode"), "msg_slowmode rejects a chat message")
+	assertFalse(t, isRejection("msg_ratelimit"), "msg_ratelimit also answers moderation commands and whispers")
+	assertFalse(t, isRejection("msg_banned"), "msg_banned also answers moderation commands and whispers")
+	assertFalse(t, isRejection("ban_success"), "ban_success answers a moderation command")
+}
+
+// connectDuplicateClient connects a client bypassing duplicates to server
+func connectDuplicateClient(t *testing.T, server *twitchtest.Server) *Client {
+	connected := make(chan struct{})
+
+	client := newQueueTestClient(server.Addr)
+	client.BypassDuplicates = true
+	client.OnConnect(func() {
+		close(connected)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire")
+	}
+
+	return client
+}
+
+func TestRetriesOnceAfterMsgDuplicate(t *testing.T) {
+	received := make(chan string, 10)
+
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		received <- message
+		return []string{"@msg-id=msg_duplicate :tmi.twitch.tv NOTICE #gempir :Your message was not sent because it is identical to the previous one you sent, less than 30 seconds ago."}
+	})
+	client := connectDuplicateClient(t, server)
+	defer client.Disconnect()
+
+	client.Say("gempir", "announcement")
+
+	for _, expected := range []string{"PRIVMSG #gempir :announcement", "PRIVMSG #gempir :announcement" + duplicateBypass} {
+		select {
+		case message := <-received:
+			assertStringsEqual(t, expected, message)
+		case <-time.After(time.Second * 3):
+			t.Fatal("message was not sent")
+		}
+	}
+
+	select {
+	case message := <-received:
+		t.Fatalf("message was retried more than once: %s", message)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestRetriesTheRejectedMessage(t *testing.T) {
+	received := make(chan string, 10)
+
+	// twitch answers in order, the NOTICE for the first message only arrives after the second was sent
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		received <- message
+		if message == "PRIVMSG #gempir :second" {
+			return []string{
+				"@msg-id=msg_duplicate :tmi.twitch.tv NOTICE #gempir :Your message was not sent because it is identical to the previous one you sent, less than 30 seconds ago.",
+				"@badges= :tmi.twitch.tv USERSTATE #gempir",
+			}
+		}
+		return nil
+	})
+	client := connectDuplicateClient(t, server)
+	defer client.Disconnect()
+
+	client.Say("gempir", "first")
+	client.Say("gempir", "second")
+
+	for _, expected := range []string{"PRIVMSG #gempir :first", "PRIVMSG #gempir :second", "PRIVMSG #gempir :first"} {
+		select {
+		case message := <-received:
+			assertStringsEqual(t, expected, message)
+		case <-time.After(time.Second * 3):
+			t.Fatal("message was not sent")
+		}
+	}
+}
+
+func TestUnansweredMessagesAreMatchedInOrder(t *testing.T) {
+	tracker := newDuplicateTracker()
+	now := time.Now()
+
+	first := &queuedMessage{Line: "PRIVMSG #gempir :first", Channel: "gempir"}
+	second := &queuedMessage{Line: "PRIVMSG #gempir :second", Channel: "gempir"}
+	tracker.bypass(first, now)
+	tracker.bypass(second, now)
+
+	// the USERSTATE of a JOIN answers no message
+	tracker.join("gempir")
+	tracker.accepted("gempir", now)
+	tracker.joined("gempir")
+
+	tracker.accepted("gempir", now)
+	assertTrue(t, tracker.rejected("gempir", now).message == second, "rejection was not matched to the second message")
+	assertTrue(t, tracker.rejected("gempir", now) == nil, "answered message was matched again")
+
+	tracker.bypass(first, now)
+	assertTrue(t, tracker.rejected("gempir", now.Add(answerTimeout+time.Second)) == nil, "message older than answerTimeout was matched")
+}
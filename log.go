@@ -0,0 +1,150 @@
+package twitch
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// LogLevel the severity of a log entry
+type LogLevel int
+
+const (
+	// LevelDebug every raw line sent and received, and messages the client could not make sense of
+	LevelDebug LogLevel = iota
+	// LevelInfo connection lifecycle, like connecting and joining
+	LevelInfo
+	// LevelWarn reconnects, dropped messages and failed writes
+	LevelWarn
+	// LevelError failed logins and panicking callbacks
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Logger receives what the client is doing, set it as Client.Logger
+// args are alternating keys and values, like in log/slog
+type Logger interface {
+	// Enabled reports whether entries of level are logged, the client skips building the others
+	Enabled(level LogLevel) bool
+	Log(level LogLevel, msg string, args ...interface{})
+}
+
+// NopLogger discards everything, the default Logger of a client
+type NopLogger struct{}
+
+// Enabled always returns false
+func (NopLogger) Enabled(level LogLevel) bool {
+	return false
+}
+
+// Log does nothing
+func (NopLogger) Log(level LogLevel, msg string, args ...interface{}) {}
+
+// StdLogger writes entries of at least Level to a logger of the standard log package
+// an entry looks like: INFO state changed from=dialing to=authenticating
+type StdLogger struct {
+	Logger *log.Logger
+	Level  LogLevel
+}
+
+// NewStdLogger creates a StdLogger writing entries of at least level to logger, nil writes to stderr like the standard logger
+func NewStdLogger(logger *log.Logger, level LogLevel) *StdLogger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &StdLogger{Logger: logger, Level: level}
+}
+
+// Enabled reports whether level is at least Level
+func (l *StdLogger) Enabled(level LogLevel) bool {
+	return level >= l.Level
+}
+
+// Log writes the entry, if it is at least Level
+func (l *StdLogger) Log(level LogLevel, msg string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		value := "!MISSING"
+		if i+1 < len(args) {
+			value = fmt.Sprint(args[i+1])
+		}
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(" " + key + "=" + value)
+	}
+
+	l.Logger.Output(2, b.String())
+}
+
+// logs reports whether the client logs entries of level, to skip building expensive ones
+func (c *Client) logs(level LogLevel) bool {
+	return c.Logger != nil && c.Logger.Enabled(level)
+}
+
+func (c *Client) log(level LogLevel, msg string, args ...interface{}) {
+	if c.logs(level) {
+		c.Logger.Log(level, msg, args...)
+	}
+}
+
+// recoverCallback logs a panic of a callback instead of ending the client
+// without a Logger enabled at LevelError the panic is not recovered, so it doesn't go unnoticed
+func (c *Client) recoverCallback(line string) {
+	if !c.logs(LevelError) {
+		return
+	}
+	if r := recover(); r != nil {
+		c.log(LevelError, "callback panicked", "panic", r, "line", redactLine(line), "stack", string(debug.Stack()))
+	}
+}
+
+// redactLine hides the oauth token of a PASS line
+func redactLine(line string) string {
+	if strings.HasPrefix(line, "PASS ") {
+		return "PASS ***"
+	}
+	return line
+}
+
+// loggingConn logs every line written to the wrapped connection
+type loggingConn struct {
+	net.Conn
+	client *Client
+}
+
+func (l *loggingConn) Write(p []byte) (int, error) {
+	n, err := l.Conn.Write(p)
+	if n > 0 && l.client.logs(LevelDebug) {
+		for _, line := range strings.Split(strings.TrimSuffix(string(p[:n]), "\r\n"), "\r\n") {
+			l.client.Logger.Log(LevelDebug, "sent", "line", redactLine(line))
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,196 @@
+package twitch
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+// testLogger keeps every entry, formatted like "INFO msg key=value"
+type testLogger struct {
+	mtx     sync.Mutex
+	entries []string
+	logged  chan struct{}
+}
+
+func newTestLogger() *testLogger {
+	return &testLogger{logged: make(chan struct{}, 1)}
+}
+
+func (l *testLogger) Enabled(level LogLevel) bool {
+	return true
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, args ...interface{}) {
+	entry := level.String() + " " + msg
+	for i := 0; i+1 < len(args); i += 2 {
+		entry += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+
+	l.mtx.Lock()
+	l.entries = append(l.entries, entry)
+	l.mtx.Unlock()
+
+	select {
+	case l.logged <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor returns the first entry starting with prefix
+func (l *testLogger) waitFor(t *testing.T, prefix string) string {
+	deadline := time.After(time.Second * 3)
+	for {
+		l.mtx.Lock()
+		for _, entry := range l.entries {
+			if strings.HasPrefix(entry, prefix) {
+				l.mtx.Unlock()
+				return entry
+			}
+		}
+		l.mtx.Unlock()
+
+		select {
+		case <-l.logged:
+		case <-deadline:
+			t.Fatalf("no log entry starting with %q", prefix)
+		}
+	}
+}
+
+func (l *testLogger) contains(substr string) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for _, entry := range l.entries {
+		if strings.Contains(entry, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStdLoggerFormatsEntries(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewStdLogger(log.New(buf, "", 0), LevelInfo)
+
+	logger.Log(LevelDebug, "received", "line", "PING :tmi.twitch.tv")
+	logger.Log(LevelWarn, "state changed", "from", StateConnected, "to", StateReconnecting, "error", "read: connection reset")
+
+	assertStringsEqual(t, "WARN state changed from=connected to=reconnecting error=\"read: connection reset\"\n", buf.String())
+	assertFalse(t, logger.Enabled(LevelDebug), "debug entries are enabled")
+	assertTrue(t, logger.Enabled(LevelError), "error entries are not enabled")
+}
+
+func TestNewClientDoesNotLog(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+
+	assertFalse(t, client.logs(LevelError), "new client logs")
+}
+
+func TestCanLogConnectionWithoutToken(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	logger := newTestLogger()
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+	client.Logger = logger
+	client.Join("gempir")
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	logger.waitFor(t, "INFO connected")
+	logger.waitFor(t, "DEBUG sent line=JOIN #gempir")
+	logger.waitFor(t, "DEBUG received line=:tmi.twitch.tv 001")
+	logger.waitFor(t, "INFO state changed from=authenticating to=connected")
+
+	assertStringsEqual(t, "DEBUG sent line=PASS ***", logger.waitFor(t, "DEBUG sent line=PASS"))
+	assertFalse(t, logger.contains("oauth:123123132"), "token was logged")
+}
+
+func TestCanLogReconnectReason(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	logger := newTestLogger()
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+	client.Logger = logger
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	logger.waitFor(t, "INFO connected")
+	server.Reconnect()
+
+	entry := logger.waitFor(t, "WARN state changed from=connected to=reconnecting")
+	assertTrue(t, strings.Contains(entry, "reconnect requested from IRC"), "reconnect reason was not logged: "+entry)
+}
+
+func TestCanRecoverPanickingCallback(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	logger := newTestLogger()
+	received := make(chan string, 2)
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+	client.Logger = logger
+	client.OnNewMessage(func(channel string, user User, message Message) {
+		if message.Text == "panic" {
+			panic("callback failed")
+		}
+		received <- message.Text
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	logger.waitFor(t, "INFO connected")
+	server.Send("@id=1 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :panic")
+	server.Send("@id=2 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :after")
+
+	select {
+	case text := <-received:
+		assertStringsEqual(t, "after", text)
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message received after the panic")
+	}
+
+	entry := logger.waitFor(t, "ERROR callback panicked")
+	assertTrue(t, strings.Contains(entry, "panic=callback failed"), "panic value was not logged: "+entry)
+	if client.State() != StateConnected {
+		t.Errorf("expected state connected, got %s", client.State())
+	}
+}
+
+func TestPanicIsNotRecoveredWithoutErrorLogger(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+
+	defer func() {
+		r := recover()
+		assertTrue(t, r == "callback failed", fmt.Sprintf("expected the panic to reach the caller, got %v", r))
+	}()
+
+	func() {
+		defer client.recoverCallback("")
+		panic("callback failed")
+	}()
+
+	t.Fatal("panic was recovered")
+}
@@ -0,0 +1,231 @@
+package twitch
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// defaultDedupeWindow how many message ids a Manager remembers to drop duplicates
+const defaultDedupeWindow = 4096
+
+var (
+	// ErrUnknownAccount returned from Manager methods when no client was added for the account
+	ErrUnknownAccount = errors.New("unknown account")
+
+	// ErrDuplicateAccount returned from Manager.AddClient when a client was already added for the account
+	ErrDuplicateAccount = errors.New("account already added")
+)
+
+// ManagerMessage a message received by one of the accounts of a Manager
+type ManagerMessage struct {
+	Account string
+	Channel string
+	User    User
+	Message Message
+}
+
+// Manager runs several accounts from one process
+// Messages of every account are merged into one stream, messages with the same "id" tag seen by
+// more than one account in a channel are only delivered once
+type Manager struct {
+	mtx       sync.RWMutex
+	clients   map[string]*Client
+	onMessage func(message ManagerMessage)
+	// results receives what Connect() of a client returned, set while Connect() runs
+	results chan error
+	// stopped is closed once Connect() returned, results are dropped from then on
+	stopped chan struct{}
+	// running clients connected by Connect() that did not return yet
+	running int
+
+	seenMtx sync.Mutex
+	seen    map[string]bool
+	// ring buffer of the keys in seen, oldest get forgotten first
+	seenOrder []string
+	seenNext  int
+}
+
+// NewManager to create a new manager without any accounts
+func NewManager() *Manager {
+	return &Manager{
+		clients:   map[string]*Client{},
+		seen:      map[string]bool{},
+		seenOrder: make([]string, defaultDedupeWindow),
+	}
+}
+
+// AddClient adds a client under its username, it is connected right away while Connect() runs
+// The manager takes over the message callbacks of the client, attach them to the manager instead
+func (m *Manager) AddClient(client *Client) error {
+	account := strings.ToLower(client.ircUser)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.clients[account]; ok {
+		return ErrDuplicateAccount
+	}
+	m.clients[account] = client
+
+	forward := func(channel string, user User, message Message) {
+		m.receive(ManagerMessage{Account: account, Channel: channel, User: user, Message: message})
+	}
+	client.OnNewMessage(forward)
+	client.OnNewRoomstateMessage(forward)
+	client.OnNewClearchatMessage(forward)
+	client.OnNewUsernoticeMessage(forward)
+	client.OnNewNoticeMessage(forward)
+	client.OnNewUserstateMessage(forward)
+	client.OnNewWhisper(func(user User, message Message) {
+		forward("", user, message)
+	})
+
+	if m.results != nil {
+		m.connect(client)
+	}
+
+	return nil
+}
+
+// Client returns the client of account
+func (m *Manager) Client(account string) (*Client, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	client, ok := m.clients[strings.ToLower(account)]
+	if !ok {
+		return nil, ErrUnknownAccount
+	}
+	return client, nil
+}
+
+// Accounts returns the usernames of every added client
+func (m *Manager) Accounts() []string {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	accounts := make([]string, 0, len(m.clients))
+	for account := range m.clients {
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// OnMessage attach callback to messages received by any account
+func (m *Manager) OnMessage(callback func(message ManagerMessage)) {
+	m.mtx.Lock()
+	m.onMessage = callback
+	m.mtx.Unlock()
+}
+
+// Say write something in a chat as account
+func (m *Manager) Say(account, channel, text string) error {
+	client, err := m.Client(account)
+	if err != nil {
+		return err
+	}
+	return client.Say(channel, text)
+}
+
+// Whisper write something in private to someone on twitch as account
+func (m *Manager) Whisper(account, username, text string) error {
+	client, err := m.Client(account)
+	if err != nil {
+		return err
+	}
+	return client.Whisper(username, text)
+}
+
+// Connect connects every account and blocks until all of them returned, clients added meanwhile are connected as well
+// The first error other than ErrClientDisconnected is returned as soon as it happens, the other clients keep running
+func (m *Manager) Connect() error {
+	m.mtx.Lock()
+	m.results = make(chan error)
+	m.stopped = make(chan struct{})
+	m.running = 0
+	for _, client := range m.clients {
+		m.connect(client)
+	}
+	results, stopped := m.results, m.stopped
+	m.mtx.Unlock()
+
+	err := ErrClientDisconnected
+	for {
+		m.mtx.Lock()
+		if m.running == 0 || err != ErrClientDisconnected {
+			m.results = nil
+			m.mtx.Unlock()
+			close(stopped)
+			return err
+		}
+		m.mtx.Unlock()
+
+		result := <-results
+
+		m.mtx.Lock()
+		m.running--
+		m.mtx.Unlock()
+		if result != nil && result != ErrClientDisconnected {
+			err = result
+		}
+	}
+}
+
+// connect connects client in the background and hands its result to Connect()
+// must be called with m.mtx held
+func (m *Manager) connect(client *Client) {
+	m.running++
+	results, stopped := m.results, m.stopped
+
+	go func() {
+		err := client.Connect()
+		select {
+		case results <- err:
+		case <-stopped:
+		}
+	}()
+}
+
+// Disconnect closes the connection of every account
+func (m *Manager) Disconnect() {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	for _, client := range m.clients {
+		client.Disconnect()
+	}
+}
+
+func (m *Manager) receive(message ManagerMessage) {
+	if id := message.Message.Tags["id"]; id != "" && m.duplicate(message.Channel+" "+id) {
+		return
+	}
+
+	m.mtx.RLock()
+	callback := m.onMessage
+	m.mtx.RUnlock()
+
+	if callback != nil {
+		callback(message)
+	}
+}
+
+// duplicate remembers key and reports whether it was seen before
+func (m *Manager) duplicate(key string) bool {
+	m.seenMtx.Lock()
+	defer m.seenMtx.Unlock()
+
+	if m.seen[key] {
+		return true
+	}
+
+	if oldest := m.seenOrder[m.seenNext]; oldest != "" {
+		delete(m.seen, oldest)
+	}
+	m.seenOrder[m.seenNext] = key
+	m.seenNext = (m.seenNext + 1) % len(m.seenOrder)
+	m.seen[key] = true
+
+	return false
+}
@@ -0,0 +1,235 @@
+package twitch
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+func newManagerTestClient(host, username string) *Client {
+	client := NewClient(username, "oauth:123123132")
+	client.TLS = false
+	client.IrcAddress = host
+	client.SendPings = false
+
+	return client
+}
+
+// connByNick returns the open connection of server logged in as nick
+func connByNick(t *testing.T, server *twitchtest.Server, nick string) *twitchtest.Conn {
+	for _, conn := range server.Conns() {
+		if conn.Nick() == nick {
+			return conn
+		}
+	}
+	t.Fatalf("%s is not connected", nick)
+	return nil
+}
+
+func TestManagerRejectsDuplicateAccount(t *testing.T) {
+	manager := NewManager()
+
+	if err := manager.AddClient(NewClient("botone", "oauth:123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddClient(NewClient("BotOne", "oauth:456")); err != ErrDuplicateAccount {
+		t.Fatalf("expected ErrDuplicateAccount, got %v", err)
+	}
+
+	if err := manager.Say("unknown", "gempir", "hello"); err != ErrUnknownAccount {
+		t.Fatalf("expected ErrUnknownAccount, got %v", err)
+	}
+}
+
+func TestManagerRoutesSayToAccount(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	manager := NewManager()
+	manager.AddClient(newManagerTestClient(server.Addr, "botone"))
+	manager.AddClient(newManagerTestClient(server.Addr, "bottwo"))
+
+	go manager.Connect()
+	defer manager.Disconnect()
+
+	waitFor(t, server, 2, "CAP REQ :"+MembershipCapability)
+
+	// wait for both connections to go active
+	for _, account := range manager.Accounts() {
+		client, _ := manager.Client(account)
+		for client.State() != StateConnected {
+			time.Sleep(time.Millisecond * 2)
+		}
+	}
+
+	if err := manager.Say("bottwo", "gempir", "hello from two"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, server, 1, "PRIVMSG")
+
+	assertStringSlicesEqual(t, []string{"PRIVMSG #gempir :hello from two"}, privmsgs(connByNick(t, server, "bottwo").Received()))
+	assertIntsEqual(t, 0, len(privmsgs(connByNick(t, server, "botone").Received())))
+}
+
+func TestManagerMergesAndDeduplicatesMessages(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	var mtx sync.Mutex
+	var received []string
+	wait := make(chan struct{}, 10)
+
+	manager := NewManager()
+	manager.AddClient(newManagerTestClient(server.Addr, "botone"))
+	manager.AddClient(newManagerTestClient(server.Addr, "bottwo"))
+	manager.OnMessage(func(message ManagerMessage) {
+		mtx.Lock()
+		received = append(received, message.Account+": "+message.Message.Text)
+		mtx.Unlock()
+		wait <- struct{}{}
+	})
+
+	go manager.Connect()
+	defer manager.Disconnect()
+
+	waitFor(t, server, 2, "CAP REQ :"+MembershipCapability)
+
+	first, second := connByNick(t, server, "botone"), connByNick(t, server, "bottwo")
+
+	// both accounts see the same message in gempir, only the first copy is delivered
+	shared := "@id=2a31a9df-d6ff-4840-b211-a2547c7e656e :someone!someone@someone.tmi.twitch.tv PRIVMSG #gempir :seen twice"
+	first.Send(shared)
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message received")
+	}
+
+	second.Send(shared)
+	second.Send("@id=9f7b3403-fa40-460f-985e-f1d01b31c196 :someone!someone@someone.tmi.twitch.tv PRIVMSG #gempir :seen once")
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message received")
+	}
+
+	select {
+	case <-wait:
+		t.Fatal("duplicate message was delivered")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// every message is tagged with the account whose connection received it
+	mtx.Lock()
+	defer mtx.Unlock()
+	assertStringSlicesEqual(t, []string{"botone: seen twice", "bottwo: seen once"}, received)
+}
+
+func TestManagerTagsMessagesWithAccount(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	received := make(chan ManagerMessage, 10)
+
+	manager := NewManager()
+	manager.AddClient(newManagerTestClient(server.Addr, "botone"))
+	manager.AddClient(newManagerTestClient(server.Addr, "bottwo"))
+	manager.OnMessage(func(message ManagerMessage) {
+		received <- message
+	})
+
+	go manager.Connect()
+	defer manager.Disconnect()
+
+	waitFor(t, server, 2, "CAP REQ :"+MembershipCapability)
+
+	// each connection gets a message only it receives, naming the account it belongs to
+	conns := server.Conns()
+	for i, conn := range conns {
+		conn.Send(fmt.Sprintf("@id=%d :someone!someone@someone.tmi.twitch.tv PRIVMSG #gempir :for %s", i, conn.Nick()))
+	}
+
+	accounts := map[string]string{}
+	for range conns {
+		select {
+		case message := <-received:
+			accounts[message.Account] = message.Message.Text
+		case <-time.After(time.Second * 3):
+			t.Fatal("no message received")
+		}
+	}
+
+	assertStringsEqual(t, "for botone", accounts["botone"])
+	assertStringsEqual(t, "for bottwo", accounts["bottwo"])
+}
+
+func TestManagerForgetsOldMessageIDs(t *testing.T) {
+	manager := NewManager()
+	manager.seenOrder = make([]string, 2)
+
+	assertFalse(t, manager.duplicate("gempir 1"), "first message reported as duplicate")
+	assertTrue(t, manager.duplicate("gempir 1"), "repeated message not reported as duplicate")
+	assertFalse(t, manager.duplicate("forsen 1"), "same id in another channel reported as duplicate")
+	assertFalse(t, manager.duplicate("gempir 2"), "new message reported as duplicate")
+	assertFalse(t, manager.duplicate("gempir 1"), "message outside of the window reported as duplicate")
+}
+
+func TestManagerConnectReturnsFirstError(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AcceptToken("oauth:123123132")
+
+	manager := NewManager()
+	manager.AddClient(newManagerTestClient(server.Addr, "botone"))
+	rejected := NewClient("bottwo", "oauth:expired")
+	rejected.TLS = false
+	rejected.IrcAddress = server.Addr
+	rejected.SendPings = false
+	manager.AddClient(rejected)
+	defer manager.Disconnect()
+
+	errs := make(chan error)
+	go func() {
+		errs <- manager.Connect()
+	}()
+
+	// botone stays connected, the failed login of bottwo ends Connect() anyway
+	select {
+	case err := <-errs:
+		if err != ErrLoginAuthenticationFailed {
+			t.Fatalf("expected ErrLoginAuthenticationFailed, got %v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("Connect() did not return the error of bottwo")
+	}
+}
+
+func TestManagerConnectsClientsAddedWhileConnected(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	manager := NewManager()
+	manager.AddClient(newManagerTestClient(server.Addr, "botone"))
+
+	errs := make(chan error)
+	go func() {
+		errs <- manager.Connect()
+	}()
+
+	waitFor(t, server, 1, "NICK botone")
+	manager.AddClient(newManagerTestClient(server.Addr, "bottwo"))
+	waitFor(t, server, 1, "NICK bottwo")
+
+	manager.Disconnect()
+	select {
+	case err := <-errs:
+		if err != ErrClientDisconnected {
+			t.Fatalf("expected ErrClientDisconnected, got %v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("Connect() did not return after Disconnect()")
+	}
+}
@@ -2,9 +2,9 @@ package twitch
 
 import (
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,6 +30,27 @@ const (
 	NOTICE MessageType = 6
 )
 
+// String returns the IRC command of the type, like PRIVMSG
+func (t MessageType) String() string {
+	switch t {
+	case WHISPER:
+		return "WHISPER"
+	case PRIVMSG:
+		return "PRIVMSG"
+	case CLEARCHAT:
+		return "CLEARCHAT"
+	case ROOMSTATE:
+		return "ROOMSTATE"
+	case USERNOTICE:
+		return "USERNOTICE"
+	case USERSTATE:
+		return "USERSTATE"
+	case NOTICE:
+		return "NOTICE"
+	}
+	return "UNSET"
+}
+
 type message struct {
 	Type        MessageType
 	Time        time.Time
@@ -55,147 +76,215 @@ type Emote struct {
 	Count int
 }
 
-func parseMessage(line string) *message {
-	if !strings.HasPrefix(line, "@") {
+// newMessage decodes every field of raw
+func newMessage(raw *RawMessage) *message {
+	if !raw.isIRC {
 		return &message{
-			Text: line,
-			Raw:  line,
+			Text: raw.line,
+			Raw:  raw.line,
 			Type: UNSET,
 		}
 	}
 
-	tags, middle, text := splitLine(line)
-
-	action := false
-	if strings.HasPrefix(text, "\u0001ACTION ") {
-		action = true
-		text = text[8 : len(text)-1]
-	}
+	tags := raw.Tags()
 	msg := &message{
-		Text:   text,
-		Tags:   map[string]string{},
-		Action: action,
-		Type:   UNSET,
+		Type:        raw.Type(),
+		Time:        raw.Time(),
+		Channel:     raw.Channel(),
+		ChannelID:   tags["room-id"],
+		UserID:      tags["user-id"],
+		Username:    prefixUsername(raw.irc.prefix),
+		DisplayName: tags["display-name"],
+		UserType:    tags["user-type"],
+		Color:       tags["color"],
+		Action:      raw.Action(),
+		Tags:        tags,
+		Text:        raw.Text(),
+		Raw:         raw.line,
+	}
+	if _, ok := tags["badges"]; ok {
+		msg.Badges = raw.Badges()
+	}
+	if _, ok := tags["emotes"]; ok {
+		msg.Emotes = raw.Emotes()
 	}
-	msg.Username, msg.Type, msg.Channel = parseMiddle(middle)
-	parseTags(msg, tags[1:])
+	if userID, ok := tags["target-user-id"]; ok {
+		msg.UserID = userID
+	}
+
 	if msg.Type == CLEARCHAT {
 		targetUser := msg.Text
 		msg.Username = targetUser
 
 		msg.Text = fmt.Sprintf("%s was timed out for %s: %s", targetUser, msg.Tags["ban-duration"], msg.Tags["ban-reason"])
 	}
-	msg.Raw = line
 	return msg
 }
 
-func splitLine(line string) (string, string, string) {
-	spl := strings.SplitN(line, " :", 3)
-
-	switch len(spl) {
-	case 2:
-		return spl[0], spl[1], ""
-	case 3:
-		return spl[0], spl[1], spl[2]
-	default:
-		return "", "", line // we don't have what we expect, return the line as text
-	}
-}
-
-// The main reason for using regex vs splitting is to reduce the edge cases
-// that needed to be accounted for
-func parseMiddle(middle string) (string, MessageType, string) {
-	var username string
-	var msgType MessageType
-	var channel string
-
-	userRe := regexp.MustCompile(`@([a-z0-9_]+)\.tmi\.twitch\.tv`)
-	userMatch := userRe.FindStringSubmatch(middle)
-	if len(userMatch) > 1 {
-		username = userMatch[1]
-	}
-
-	typeRe := regexp.MustCompile(`\s([A-Z]+)\s`)
-	typeMatch := typeRe.FindStringSubmatch(middle)
-	if len(typeMatch) > 1 {
-		switch typeMatch[1] {
-		case "PRIVMSG":
-			msgType = PRIVMSG
-		case "WHISPER":
-			msgType = WHISPER
-		case "CLEARCHAT":
-			msgType = CLEARCHAT
-		case "NOTICE":
-			msgType = NOTICE
-		case "ROOMSTATE":
-			msgType = ROOMSTATE
-		case "USERSTATE":
-			msgType = USERSTATE
-		case "USERNOTICE":
-			msgType = USERNOTICE
-		default:
-			msgType = UNSET
+// ircLine the parts of a raw IRC line, every part is a substring of the line so parsing does not allocate
+type ircLine struct {
+	tags     string
+	prefix   string
+	command  string
+	params   string
+	trailing string
+}
+
+// parseIRCLine splits "@tags :prefix COMMAND params :trailing" into its parts, tags and prefix are optional
+func parseIRCLine(line string) ircLine {
+	var irc ircLine
+
+	if strings.HasPrefix(line, "@") {
+		irc.tags, line = cutSpace(line[1:])
+	}
+	if strings.HasPrefix(line, ":") {
+		irc.prefix, line = cutSpace(line[1:])
+	}
+	irc.command, line = cutSpace(line)
+
+	if strings.HasPrefix(line, ":") {
+		irc.trailing = line[1:]
+	} else if i := strings.Index(line, " :"); i >= 0 {
+		irc.params = line[:i]
+		irc.trailing = line[i+2:]
+	} else {
+		irc.params = line
+	}
+
+	return irc
+}
+
+// tag returns the unescaped value of a tag, without building a map of all tags
+func (irc *ircLine) tag(key string) (string, bool) {
+	tags := irc.tags
+	for tags != "" {
+		var tagKey, value string
+		var ok bool
+		tagKey, value, tags, ok = nextTag(tags)
+		if ok && tagKey == key {
+			return unescapeTagValue(value), true
 		}
 	}
+	return "", false
+}
+
+// cutSpace returns s up to the first space and the rest after any spaces following it
+func cutSpace(s string) (string, string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i+1:], " ")
+}
+
+// cutByte splits s around the first sep, found is false if s does not contain sep
+func cutByte(s string, sep byte) (before, after string, found bool) {
+	if i := strings.IndexByte(s, sep); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+// prefixUsername returns the username of a prefix like "gempir!gempir@gempir.tmi.twitch.tv"
+func prefixUsername(prefix string) string {
+	_, host, found := cutByte(prefix, '@')
+	if !found || !strings.HasSuffix(host, ".tmi.twitch.tv") {
+		return ""
+	}
+	return host[:len(host)-len(".tmi.twitch.tv")]
+}
 
-	channelRe := regexp.MustCompile(`#([a-z0-9_]+)$`)
-	channelMatch := channelRe.FindStringSubmatch(middle)
-	if len(channelMatch) > 1 {
-		channel = channelMatch[1]
+// paramsChannel returns the channel from the last parameter, like "#gempir"
+func paramsChannel(params string) string {
+	last := params[strings.LastIndexByte(params, ' ')+1:]
+	if !strings.HasPrefix(last, "#") {
+		return ""
 	}
+	return last[1:]
+}
+
+func commandType(command string) MessageType {
+	switch command {
+	case "PRIVMSG":
+		return PRIVMSG
+	case "WHISPER":
+		return WHISPER
+	case "CLEARCHAT":
+		return CLEARCHAT
+	case "NOTICE":
+		return NOTICE
+	case "ROOMSTATE":
+		return ROOMSTATE
+	case "USERSTATE":
+		return USERSTATE
+	case "USERNOTICE":
+		return USERNOTICE
+	}
+	return UNSET
+}
 
-	return username, msgType, channel
+// nextTag returns the key and escaped value of the first tag and the remaining tags, ok is false for a tag without value
+func nextTag(tags string) (key, value, rest string, ok bool) {
+	tag, rest, _ := cutByte(tags, ';')
+	key, value, ok = cutByte(tag, '=')
+	return key, value, rest, ok
 }
 
-func parseTags(msg *message, tagsRaw string) {
-	tags := strings.Split(tagsRaw, ";")
+// tagBuffers reused for unescaping tag values
+var tagBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
+// unescapeTagValue undoes the IRCv3 tag value escaping, values without escapes are returned as they are
+func unescapeTagValue(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
 
-	for _, tag := range tags {
-		spl := strings.SplitN(tag, "=", 2)
-		if len(spl) != 2 {
+	bufPtr := tagBuffers.Get().(*[]byte)
+	buf := (*bufPtr)[:0]
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			buf = append(buf, value[i])
 			continue
 		}
-
-		value := strings.Replace(spl[1], "\\:", ";", -1)
-		value = strings.Replace(value, "\\s", " ", -1)
-		value = strings.Replace(value, "\\\\", "\\", -1)
-		switch spl[0] {
-		case "badges":
-			msg.Badges = parseBadges(value)
-		case "color":
-			msg.Color = value
-		case "display-name":
-			msg.DisplayName = value
-		case "emotes":
-			msg.Emotes = parseTwitchEmotes(value, msg.Text)
-		case "user-type":
-			msg.UserType = value
-		case "tmi-sent-ts":
-			i, err := strconv.ParseInt(value, 10, 64)
-			if err == nil {
-				msg.Time = time.Unix(0, int64(i*1e6))
-			}
-		case "room-id":
-			msg.ChannelID = value
-		case "target-user-id":
-			msg.UserID = value
-		case "user-id":
-			msg.UserID = value
+		i++
+		switch value[i] {
+		case ':':
+			buf = append(buf, ';')
+		case 's':
+			buf = append(buf, ' ')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'n':
+			buf = append(buf, '\n')
+		default:
+			buf = append(buf, value[i])
 		}
-		msg.Tags[spl[0]] = value
 	}
+	unescaped := string(buf)
+
+	*bufPtr = buf
+	tagBuffers.Put(bufPtr)
+
+	return unescaped
 }
 
 func parseBadges(badges string) map[string]int {
-	m := map[string]int{}
-	spl := strings.Split(badges, ",")
-	for _, badge := range spl {
-		s := strings.SplitN(badge, "/", 2)
-		if len(s) < 2 {
+	m := make(map[string]int, strings.Count(badges, ",")+1)
+	for badges != "" {
+		var badge string
+		badge, badges, _ = cutByte(badges, ',')
+
+		name, version, found := cutByte(badge, '/')
+		if !found {
 			continue
 		}
-		n, _ := strconv.Atoi(s[1])
-		m[s[0]] = n
+		n, _ := strconv.Atoi(version)
+		m[name] = n
 	}
 	return m
 }
@@ -203,42 +292,57 @@ func parseBadges(badges string) map[string]int {
 func parseTwitchEmotes(emoteTag, text string) []*Emote {
 	emotes := []*Emote{}
 
-	if emoteTag == "" {
-		return emotes
-	}
-
-	runes := []rune(text)
+	for emoteTag != "" {
+		var emote string
+		emote, emoteTag, _ = cutByte(emoteTag, '/')
 
-	emoteSlice := strings.Split(emoteTag, "/")
-	for i := range emoteSlice {
-		spl := strings.Split(emoteSlice[i], ":")
-		pos := strings.Split(spl[1], ",")
-		sp := strings.Split(pos[0], "-")
-		start, _ := strconv.Atoi(sp[0])
-		end, _ := strconv.Atoi(sp[1])
-		id := spl[0]
-		e := &Emote{
-			ID:    id,
-			Count: strings.Count(emoteSlice[i], "-"),
-			Name:  string(runes[start : end+1]),
+		id, positions, found := cutByte(emote, ':')
+		if !found {
+			continue
 		}
+		first, _, _ := cutByte(positions, ',')
+		startPos, endPos, _ := cutByte(first, '-')
+		start, _ := strconv.Atoi(startPos)
+		end, _ := strconv.Atoi(endPos)
 
-		emotes = append(emotes, e)
+		emotes = append(emotes, &Emote{
+			ID:    id,
+			Count: strings.Count(positions, "-"),
+			Name:  runeSubstring(text, start, end+1),
+		})
 	}
 	return emotes
 }
 
+// runeSubstring returns the characters start up to end of text, twitch counts emote positions in characters not bytes
+func runeSubstring(text string, start, end int) string {
+	startByte, endByte := -1, len(text)
+	n := 0
+	for i := range text {
+		if n == start {
+			startByte = i
+		}
+		if n == end {
+			endByte = i
+			break
+		}
+		n++
+	}
+	if startByte < 0 || start > end {
+		return ""
+	}
+	return text[startByte:endByte]
+}
+
 func parseJoinPart(text string) (string, string) {
-	username := strings.Split(text, "!")
-	channel := strings.Split(username[1], "#")
-	return strings.Trim(channel[1], " "), strings.Trim(username[0], " :")
+	irc := parseIRCLine(text)
+	username, _, _ := cutByte(irc.prefix, '!')
+
+	return strings.TrimPrefix(irc.params, "#"), username
 }
 
 func parseNames(text string) (string, []string) {
-	lines := strings.Split(text, ":")
-	channelDirty := strings.Split(lines[1], "#")
-	channel := strings.Trim(channelDirty[1], " ")
-	users := strings.Split(lines[2], " ")
+	irc := parseIRCLine(text)
 
-	return channel, users
+	return paramsChannel(irc.params), strings.Split(irc.trailing, " ")
 }
@@ -6,7 +6,7 @@ import (
 
 func TestCanParseMessage(t *testing.T) {
 	testMessage := "@badges=subscriber/6,premium/1;color=#FF0000;display-name=Redflamingo13;emotes=;id=2a31a9df-d6ff-4840-b211-a2547c7e656e;mod=0;room-id=11148817;subscriber=1;tmi-sent-ts=1490382457309;turbo=0;user-id=78424343;user-type= :redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :Thrashh5, FeelsWayTooAmazingMan kinda"
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertStringsEqual(t, "pajlada", message.Channel)
 	assertStringsEqual(t, "78424343", message.UserID)
@@ -26,14 +26,14 @@ func TestCanParseMessage(t *testing.T) {
 
 func TestCanParseMessageMissingChannelRegression(t *testing.T) {
 	testMessage := "@badges=broadcaster/1,bits-charity/1;color=#2E8B57;display-name=The_Xin;emotes=;flags=;id=9f7b3403-fa40-460f-985e-f1d01b31c196;mod=0;room-id=30403955;subscriber=0;tmi-sent-ts=1548100172162;turbo=0;user-id=30403955;user-type= :the_xin!the_xin@the_xin.tmi.twitch.tv PRIVMSG #the_xin :test"
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertStringsEqual(t, "the_xin", message.Channel)
 }
 
 func TestCanParseActionMessage(t *testing.T) {
 	testMessage := "@badges=subscriber/6,premium/1;color=#FF0000;display-name=Redflamingo13;emotes=;id=2a31a9df-d6ff-4840-b211-a2547c7e656e;mod=0;room-id=11148817;subscriber=1;tmi-sent-ts=1490382457309;turbo=0;user-id=78424343;user-type= :redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :\u0001ACTION Thrashh5, FeelsWayTooAmazingMan kinda\u0001"
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertStringsEqual(t, "pajlada", message.Channel)
 	assertIntsEqual(t, 6, message.Badges["subscriber"])
@@ -52,7 +52,7 @@ func TestCanParseActionMessage(t *testing.T) {
 
 func TestCanParseWhisper(t *testing.T) {
 	testMessage := "@badges=;color=#00FF7F;display-name=Danielps1;emotes=;message-id=20;thread-id=32591953_77829817;turbo=0;user-id=32591953;user-type= :danielps1!danielps1@danielps1.tmi.twitch.tv WHISPER gempir :i like memes"
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertIntsEqual(t, 0, message.Badges["subscriber"])
 	assertStringsEqual(t, "#00FF7F", message.Color)
@@ -70,7 +70,7 @@ func TestCanParseWhisper(t *testing.T) {
 func TestCantParseNoTagsMessage(t *testing.T) {
 	testMessage := "my test message"
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertStringsEqual(t, testMessage, message.Text)
 }
@@ -78,7 +78,7 @@ func TestCantParseNoTagsMessage(t *testing.T) {
 func TestCantParseInvalidMessage(t *testing.T) {
 	testMessage := "@my :test message"
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertStringsEqual(t, "", message.Text)
 }
@@ -86,7 +86,7 @@ func TestCantParseInvalidMessage(t *testing.T) {
 func TestCanParseClearChatMessage(t *testing.T) {
 	testMessage := `@ban-duration=1;ban-reason=testing\sxd;room-id=11148817;target-user-id=40910607 :tmi.twitch.tv CLEARCHAT #pajlada :ampzyh`
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	if message.Type != CLEARCHAT {
 		t.Error("parsing CLEARCHAT message failed")
@@ -98,7 +98,7 @@ func TestCanParseClearChatMessage(t *testing.T) {
 func TestCanParseClearChatMessage2(t *testing.T) {
 	testMessage := `@room-id=11148817;tmi-sent-ts=1527342985836 :tmi.twitch.tv CLEARCHAT #pajlada`
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	if message.Type != CLEARCHAT {
 		t.Error("parsing CLEARCHAT message failed")
@@ -110,33 +110,33 @@ func TestCanParseClearChatMessage2(t *testing.T) {
 func TestCanParseEmoteMessage(t *testing.T) {
 	testMessage := "@badges=;color=#008000;display-name=Zugren;emotes=120232:0-6,13-19,26-32,39-45,52-58;id=51c290e9-1b50-497c-bb03-1667e1afe6e4;mod=0;room-id=11148817;sent-ts=1490382458685;subscriber=0;tmi-sent-ts=1490382456776;turbo=0;user-id=65897106;user-type= :zugren!zugren@zugren.tmi.twitch.tv PRIVMSG #pajlada :TriHard Clap TriHard Clap TriHard Clap TriHard Clap TriHard Clap"
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertIntsEqual(t, 1, len(message.Emotes))
 }
 
 func TestParseUsernameMiddleRegex(t *testing.T) {
-	testMessage := "thexin1!thexin1@thexin1.tmi.twitch.tv PRIVMSG #n1nja"
-	username, mType, channel := parseMiddle(testMessage)
+	testMessage := ":thexin1!thexin1@thexin1.tmi.twitch.tv PRIVMSG #n1nja :hello"
+	raw := ParseRawMessage(testMessage)
 
-	assertStringsEqual(t, "thexin1", username)
-	assertIntsEqual(t, int(PRIVMSG), int(mType))
-	assertStringsEqual(t, "n1nja", channel)
+	assertStringsEqual(t, "thexin1", raw.Username())
+	assertIntsEqual(t, int(PRIVMSG), int(raw.Type()))
+	assertStringsEqual(t, "n1nja", raw.Channel())
 }
 
 func TestParseNoUserMiddleRegex(t *testing.T) {
-	testMessage := "tmi.twitch.tv ROOMSTATE #dallas"
-	username, mType, channel := parseMiddle(testMessage)
+	testMessage := ":tmi.twitch.tv ROOMSTATE #dallas"
+	raw := ParseRawMessage(testMessage)
 
-	assertStringsEqual(t, "", username)
-	assertIntsEqual(t, int(ROOMSTATE), int(mType))
-	assertStringsEqual(t, "dallas", channel)
+	assertStringsEqual(t, "", raw.Username())
+	assertIntsEqual(t, int(ROOMSTATE), int(raw.Type()))
+	assertStringsEqual(t, "dallas", raw.Channel())
 }
 
 func TestCanParseUsernoticeResubMessage(t *testing.T) {
 	testMessage := `@badges=staff/1,broadcaster/1,turbo/1;color=#008000;display-name=ronni;emotes=;id=db25007f-7a18-43eb-9379-80131e44d633;login=ronni;mod=0;msg-id=resub;msg-param-months=6;msg-param-sub-plan=Prime;msg-param-sub-plan-name=Prime;room-id=1337;subscriber=1;system-msg=ronni\shas\ssubscribed\sfor\s6\smonths!;tmi-sent-ts=1507246572675;turbo=1;user-id=1337;user-type=staff :tmi.twitch.tv USERNOTICE #dallas :Great stream -- keep it up!`
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertIntsEqual(t, int(USERNOTICE), int(message.Type))
 	assertStringsEqual(t, "dallas", message.Channel)
@@ -150,7 +150,7 @@ func TestCanParseUsernoticeResubMessage(t *testing.T) {
 func TestCanParseUsernoticeGiftSubMessage(t *testing.T) {
 	testMessage := `@badges=subscriber/24,bits/25000;color=#2E8B57;display-name=TheXin1;emotes=;id=2dd9310c-1bcb-494f-929c-d0d222e245d3;login=thexin1;mod=0;msg-id=subgift;msg-param-months=1;msg-param-recipient-display-name=Fuse404;msg-param-recipient-id=36547385;msg-param-recipient-user-name=fuse404;msg-param-sub-plan-name=Channel\sSubscription\s(theattack);msg-param-sub-plan=1000;room-id=41226075;subscriber=1;system-msg=TheXin1\sgifted\sa\s$4.99\ssub\sto\sFuse404!;tmi-sent-ts=1519844687512;turbo=0;user-id=30403955;user-type= :tmi.twitch.tv USERNOTICE #theattack`
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	assertIntsEqual(t, int(USERNOTICE), int(message.Type))
 	assertStringsEqual(t, "theattack", message.Channel)
@@ -164,7 +164,7 @@ func TestCanParseUsernoticeGiftSubMessage(t *testing.T) {
 
 func TestCanParseUserNoticeMessage(t *testing.T) {
 	testMessage := `@badges=moderator/1,subscriber/24,premium/1;color=#33FFFF;display-name=Baxx;emotes=;id=4d737a10-03ff-48a7-aca1-a5624ebac91d;login=baxx;mod=1;msg-id=subgift;msg-param-months=7;msg-param-recipient-display-name=Nclnat;msg-param-recipient-id=84027795;msg-param-recipient-user-name=nclnat;msg-param-sender-count=7;msg-param-sub-plan-name=look\sat\sthose\sshitty\semotes,\srip\s$5\sLUL;msg-param-sub-plan=1000;room-id=11148817;subscriber=1;system-msg=Baxx\sgifted\sa\sTier\s1\ssub\sto\sNclnat!\sThey\shave\sgiven\s7\sGift\sSubs\sin\sthe\schannel!;tmi-sent-ts=1527341500077;turbo=0;user-id=59504812;user-type=mod :tmi.twitch.tv USERNOTICE #pajlada`
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	if message.Type != USERNOTICE {
 		t.Error("parsing USERNOTICE message failed")
@@ -176,7 +176,7 @@ func TestCanParseUserNoticeMessage(t *testing.T) {
 
 func TestCanParseUserNoticeRaidMessage(t *testing.T) {
 	testMessage := `@badges=turbo/1;color=#9ACD32;display-name=TestChannel;emotes=;id=3d830f12-795c-447d-af3c-ea05e40fbddb;login=testchannel;mod=0;msg-id=raid;msg-param-displayName=TestChannel;msg-param-login=testchannel;msg-param-viewerCount=15;room-id=56379257;subscriber=0;system-msg=15\sraiders\sfrom\sTestChannel\shave\sjoined\n!;tmi-sent-ts=1507246572675;tmi-sent-ts=1507246572675;turbo=1;user-id=123456;user-type= :tmi.twitch.tv USERNOTICE #othertestchannel`
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	if message.Type != USERNOTICE {
 		t.Error("parsing USERNOTICE message failed")
@@ -189,7 +189,7 @@ func TestCanParseUserNoticeRaidMessage(t *testing.T) {
 func TestCanParseRoomstateMessage(t *testing.T) {
 	testMessage := `@broadcaster-lang=<broadcaster-lang>;r9k=<r9k>;slow=<slow>;subs-only=<subs-only> :tmi.twitch.tv ROOMSTATE #nothing`
 
-	message := parseMessage(testMessage)
+	message := newMessage(ParseRawMessage(testMessage))
 
 	if message.Type != ROOMSTATE {
 		t.Error("parsing ROOMSTATE message failed")
@@ -216,3 +216,121 @@ func TestCanParseNames(t *testing.T) {
 	assertStringsEqual(t, channel, "mychannel")
 	assertStringSlicesEqual(t, expectedUsers, users)
 }
+
+func TestCanParseMessageWithoutTags(t *testing.T) {
+	testMessage := ":redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :Thrashh5, FeelsWayTooAmazingMan kinda"
+	message := newMessage(ParseRawMessage(testMessage))
+
+	if message.Type != PRIVMSG {
+		t.Error("parsing message type failed")
+	}
+	assertStringsEqual(t, "pajlada", message.Channel)
+	assertStringsEqual(t, "redflamingo13", message.Username)
+	assertStringsEqual(t, "Thrashh5, FeelsWayTooAmazingMan kinda", message.Text)
+	assertIntsEqual(t, 0, len(message.Tags))
+}
+
+func TestNumericRepliesAreUnset(t *testing.T) {
+	message := newMessage(ParseRawMessage(":tmi.twitch.tv 001 justinfan123123 :Welcome, GLHF!"))
+
+	if message.Type != UNSET {
+		t.Error("numeric reply was not parsed as UNSET")
+	}
+}
+
+func TestCanSplitIRCLine(t *testing.T) {
+	irc := parseIRCLine("@badges=;color=#00FF7F :danielps1!danielps1@danielps1.tmi.twitch.tv WHISPER gempir :i like :memes")
+
+	assertStringsEqual(t, "badges=;color=#00FF7F", irc.tags)
+	assertStringsEqual(t, "danielps1!danielps1@danielps1.tmi.twitch.tv", irc.prefix)
+	assertStringsEqual(t, "WHISPER", irc.command)
+	assertStringsEqual(t, "gempir", irc.params)
+	assertStringsEqual(t, "i like :memes", irc.trailing)
+
+	irc = parseIRCLine("PING :tmi.twitch.tv")
+
+	assertStringsEqual(t, "", irc.prefix)
+	assertStringsEqual(t, "PING", irc.command)
+	assertStringsEqual(t, "tmi.twitch.tv", irc.trailing)
+}
+
+func TestCanLookUpSingleTag(t *testing.T) {
+	irc := parseIRCLine(`@login=ronni;msg-id=resub;flag;system-msg=ronni\shas\ssubscribed\sfor\s6\smonths\:\s\\o/ :tmi.twitch.tv USERNOTICE #dallas`)
+
+	value, ok := irc.tag("msg-id")
+	assertTrue(t, ok, "msg-id tag not found")
+	assertStringsEqual(t, "resub", value)
+
+	value, _ = irc.tag("system-msg")
+	assertStringsEqual(t, `ronni has subscribed for 6 months; \o/`, value)
+
+	_, ok = irc.tag("flag")
+	assertFalse(t, ok, "tag without value found")
+	_, ok = irc.tag("missing")
+	assertFalse(t, ok, "missing tag found")
+}
+
+func TestCanParseEmotesAfterMultiByteCharacters(t *testing.T) {
+	emotes := parseTwitchEmotes("25:7-11/1902:13-17", "über 🙂 Kappa Keepo")
+
+	assertIntsEqual(t, 2, len(emotes))
+	assertStringsEqual(t, "Kappa", emotes[0].Name)
+	assertStringsEqual(t, "Keepo", emotes[1].Name)
+	assertIntsEqual(t, 1, emotes[0].Count)
+}
+
+func TestParsingIRCLineDoesNotAllocate(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		irc := parseIRCLine(benchmarkPRIVMSG)
+		irc.tag("display-name")
+		prefixUsername(irc.prefix)
+		paramsChannel(irc.params)
+	})
+
+	assertIntsEqual(t, 0, int(allocs))
+}
+
+const benchmarkPRIVMSG = "@badges=subscriber/6,premium/1;color=#FF0000;display-name=Redflamingo13;emotes=120232:0-6,13-19;id=2a31a9df-d6ff-4840-b211-a2547c7e656e;mod=0;room-id=11148817;subscriber=1;tmi-sent-ts=1490382457309;turbo=0;user-id=78424343;user-type= :redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :TriHard Clap TriHard Clap kinda"
+
+const benchmarkUSERNOTICE = `@badges=staff/1,broadcaster/1,turbo/1;color=#008000;display-name=ronni;emotes=;id=db25007f-7a18-43eb-9379-80131e44d633;login=ronni;mod=0;msg-id=resub;msg-param-months=6;msg-param-sub-plan=Prime;msg-param-sub-plan-name=Prime;room-id=1337;subscriber=1;system-msg=ronni\shas\ssubscribed\sfor\s6\smonths!;tmi-sent-ts=1507246572675;turbo=1;user-id=1337;user-type=staff :tmi.twitch.tv USERNOTICE #dallas :Great stream -- keep it up!`
+
+func BenchmarkParseIRCLine(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		irc := parseIRCLine(benchmarkPRIVMSG)
+		prefixUsername(irc.prefix)
+		paramsChannel(irc.params)
+	}
+}
+
+func BenchmarkIRCLineTag(b *testing.B) {
+	irc := parseIRCLine(benchmarkPRIVMSG)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		irc.tag("user-id")
+	}
+}
+
+func BenchmarkParseMessage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseMessage(benchmarkPRIVMSG)
+	}
+}
+
+func BenchmarkParseMessageUsernotice(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseMessage(benchmarkUSERNOTICE)
+	}
+}
+
+func BenchmarkParseMessageWithoutTags(b *testing.B) {
+	line := ":redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :Thrashh5, FeelsWayTooAmazingMan kinda"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseMessage(line)
+	}
+}
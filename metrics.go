@@ -0,0 +1,56 @@
+package twitch
+
+import "time"
+
+// Metrics is updated by the client as it runs, set it as Client.Metrics
+// Implementations have to be safe for concurrent use, the package twitchmetrics exports them for Prometheus
+type Metrics interface {
+	// MessageReceived a message of a known type was received, channel is empty for whispers
+	MessageReceived(messageType MessageType, channel string)
+	// MessageSent a queued message was written to channel, jtv for whispers
+	MessageSent(channel string)
+	// RateLimitWait a message was held back in the outgoing queue for wait, because of the rate limit or slow mode
+	// it is reported once per message that had to wait, when the message is sent
+	RateLimitWait(wait time.Duration)
+	// Reconnect the connection dropped and the client reconnects
+	Reconnect()
+	// Latency a PONG arrived after latency
+	Latency(latency time.Duration)
+	// QueueDepth the number of messages waiting in the outgoing queue changed
+	QueueDepth(depth int)
+	// ParseError a line could not be parsed, like a line without a command
+	// lines of valid types the client doesn't handle, like HOSTTARGET, are not counted
+	ParseError()
+}
+
+// NopMetrics discards every update, the default Metrics of a client
+type NopMetrics struct{}
+
+// MessageReceived does nothing
+func (NopMetrics) MessageReceived(messageType MessageType, channel string) {}
+
+// MessageSent does nothing
+func (NopMetrics) MessageSent(channel string) {}
+
+// RateLimitWait does nothing
+func (NopMetrics) RateLimitWait(wait time.Duration) {}
+
+// Reconnect does nothing
+func (NopMetrics) Reconnect() {}
+
+// Latency does nothing
+func (NopMetrics) Latency(latency time.Duration) {}
+
+// QueueDepth does nothing
+func (NopMetrics) QueueDepth(depth int) {}
+
+// ParseError does nothing
+func (NopMetrics) ParseError() {}
+
+// metrics returns Metrics, NopMetrics if it was unset
+func (c *Client) metrics() Metrics {
+	if c.Metrics == nil {
+		return NopMetrics{}
+	}
+	return c.Metrics
+}
@@ -0,0 +1,126 @@
+package twitch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+// testMetrics counts reconnects and parse errors and keeps the rate limit waits, everything else is discarded
+type testMetrics struct {
+	NopMetrics
+	mtx         sync.Mutex
+	reconnects  int
+	parseErrors int
+	waits       []time.Duration
+	updated     chan struct{}
+}
+
+func (m *testMetrics) RateLimitWait(wait time.Duration) {
+	m.mtx.Lock()
+	m.waits = append(m.waits, wait)
+	m.mtx.Unlock()
+}
+
+func (m *testMetrics) Reconnect() {
+	m.mtx.Lock()
+	m.reconnects++
+	m.mtx.Unlock()
+	m.updated <- struct{}{}
+}
+
+func (m *testMetrics) ParseError() {
+	m.mtx.Lock()
+	m.parseErrors++
+	m.mtx.Unlock()
+	m.updated <- struct{}{}
+}
+
+func TestClientReportsReconnectsAndParseErrors(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	metrics := &testMetrics{updated: make(chan struct{}, 4)}
+	connected := make(chan struct{}, 2)
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+	client.Metrics = metrics
+	client.OnConnect(func() {
+		connected <- struct{}{}
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-time.After(time.Second * 3):
+			t.Fatal("client did not connect")
+		}
+
+		if i == 0 {
+			// valid types the client doesn't handle are no parse errors
+			server.Send("@id=1 :tmi.twitch.tv HOSTTARGET #gempir :-")
+			server.Send("@login=gempir;target-msg-id=1 :tmi.twitch.tv CLEARMSG #gempir :hello")
+			server.Send("@badges= :tmi.twitch.tv GLOBALUSERSTATE")
+			server.Send("@id=2 :tmi.twitch.tv")
+			server.Send(":tmi.twitch.tv")
+			server.Send("not an irc line")
+			server.Reconnect()
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-metrics.updated:
+		case <-time.After(time.Second * 3):
+			t.Fatal("metrics were not updated")
+		}
+	}
+
+	metrics.mtx.Lock()
+	defer metrics.mtx.Unlock()
+	assertIntsEqual(t, 1, metrics.reconnects)
+	assertIntsEqual(t, 3, metrics.parseErrors)
+}
+
+func TestClientReportsTimeActuallyWaited(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	metrics := &testMetrics{}
+	client := newQueueTestClient(server.Addr)
+	// without badges every message to a channel waits a second after the previous one
+	client.RespectSlowMode = true
+	client.Metrics = metrics
+	client.Say("gempir", "first")
+	client.Say("gempir", "second")
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 1, "PRIVMSG")
+	time.Sleep(time.Millisecond * 100)
+	// wakes up the queue long before the second message may be sent
+	client.Say("pajlada", "other")
+	waitFor(t, server, 2, "PRIVMSG")
+
+	metrics.mtx.Lock()
+	defer metrics.mtx.Unlock()
+	// only the second message was held back, the wake-up for the other channel is no wait of its own
+	if len(metrics.waits) != 1 {
+		t.Fatalf("expected 1 wait, got %v", metrics.waits)
+	}
+	assertTrue(t, metrics.waits[0] > time.Millisecond*500, "reported less than the second message waited: "+metrics.waits[0].String())
+}
+
+func TestMessageTypeIsNamedAfterCommand(t *testing.T) {
+	assertStringsEqual(t, "USERNOTICE", USERNOTICE.String())
+	assertStringsEqual(t, "UNSET", MessageType(42).String())
+}
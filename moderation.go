@@ -0,0 +1,417 @@
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTimeoutDuration longest timeout twitch accepts
+	maxTimeoutDuration = time.Hour * 24 * 14
+	// maxFollowersOnlyDuration longest follow age twitch accepts for followers-only mode
+	maxFollowersOnlyDuration = time.Hour * 24 * 90
+	// maxSlowDuration longest interval twitch accepts for slow mode
+	maxSlowDuration = time.Hour * 24
+	// defaultModerationTimeout how long to wait for the NOTICE answering a moderation command
+	defaultModerationTimeout = time.Second * 5
+)
+
+var (
+	// ErrInvalidChannel returned from moderation commands when the channel is empty or malformed
+	ErrInvalidChannel = errors.New("invalid channel name")
+
+	// ErrInvalidUsername returned from moderation commands when the target username is empty or malformed
+	ErrInvalidUsername = errors.New("invalid username")
+
+	// ErrInvalidDuration returned from moderation commands when a duration is outside of what twitch accepts
+	ErrInvalidDuration = errors.New("invalid duration")
+
+	// ErrInvalidMessageID returned from DeleteMessage when the message id is empty or malformed
+	ErrInvalidMessageID = errors.New("invalid message id")
+
+	// ErrModerationTimeout returned from moderation commands when twitch did not answer in time
+	ErrModerationTimeout = errors.New("no response received for moderation command")
+
+	validUsername  = regexp.MustCompile(`^[a-zA-Z0-9_]{1,25}$`)
+	validMessageID = regexp.MustCompile(`^[a-fA-F0-9-]{1,64}$`)
+)
+
+// ModerationError returned from moderation commands when twitch answers with a failure NOTICE
+type ModerationError struct {
+	MsgID  string
+	Notice Message
+}
+
+func (e *ModerationError) Error() string {
+	return fmt.Sprintf("moderation command failed (%s): %s", e.MsgID, e.Notice.Text)
+}
+
+// failure notices twitch can answer any moderation command with
+var commonModerationFailures = []string{
+	"no_permission",
+	"msg_channel_suspended",
+	"unrecognized_cmd",
+	"msg_banned",
+	"msg_ratelimit",
+}
+
+// moderationCommand describes which NOTICE msg-ids answer a chat command
+type moderationCommand struct {
+	successes []string
+	failures  []string
+	// clearchat is set for commands twitch confirms with a CLEARCHAT instead of a NOTICE
+	clearchat bool
+}
+
+var (
+	cmdBan = moderationCommand{
+		successes: []string{"ban_success"},
+		failures: []string{"already_banned", "bad_ban_admin", "bad_ban_anon", "bad_ban_broadcaster",
+			"bad_ban_global_mod", "bad_ban_mod", "bad_ban_self", "bad_ban_staff", "usage_ban"},
+	}
+	cmdUnban = moderationCommand{
+		successes: []string{"unban_success", "untimeout_success"},
+		failures:  []string{"bad_unban_no_ban", "usage_unban"},
+	}
+	cmdTimeout = moderationCommand{
+		successes: []string{"timeout_success"},
+		failures: []string{"bad_timeout_admin", "bad_timeout_anon", "bad_timeout_broadcaster", "bad_timeout_duration",
+			"bad_timeout_global_mod", "bad_timeout_mod", "bad_timeout_self", "bad_timeout_staff", "usage_timeout"},
+	}
+	cmdDelete = moderationCommand{
+		successes: []string{"delete_message_success"},
+		failures:  []string{"bad_delete_message_error", "bad_delete_message_broadcaster", "bad_delete_message_mod", "usage_delete"},
+	}
+	cmdClear = moderationCommand{
+		failures:  []string{"usage_clear"},
+		clearchat: true,
+	}
+	cmdSlow = moderationCommand{
+		successes: []string{"slow_on"},
+		failures:  []string{"usage_slow_on"},
+	}
+	cmdSlowOff = moderationCommand{
+		successes: []string{"slow_off"},
+		failures:  []string{"usage_slow_off"},
+	}
+	cmdFollowers = moderationCommand{
+		successes: []string{"followers_on", "followers_on_zero"},
+		failures:  []string{"already_followers_on", "usage_followers_on"},
+	}
+	cmdFollowersOff = moderationCommand{
+		successes: []string{"followers_off"},
+		failures:  []string{"already_followers_off", "usage_followers_off"},
+	}
+	cmdSubs = moderationCommand{
+		successes: []string{"subs_on"},
+		failures:  []string{"already_subs_on", "usage_subs_on"},
+	}
+	cmdSubsOff = moderationCommand{
+		successes: []string{"subs_off"},
+		failures:  []string{"already_subs_off", "usage_subs_off"},
+	}
+	cmdEmoteOnly = moderationCommand{
+		successes: []string{"emote_only_on"},
+		failures:  []string{"already_emote_only_on", "usage_emote_only_on"},
+	}
+	cmdEmoteOnlyOff = moderationCommand{
+		successes: []string{"emote_only_off"},
+		failures:  []string{"already_emote_only_off", "usage_emote_only_off"},
+	}
+	cmdUniqueChat = moderationCommand{
+		successes: []string{"r9k_on"},
+		failures:  []string{"already_r9k_on", "usage_r9k_on"},
+	}
+	cmdUniqueChatOff = moderationCommand{
+		successes: []string{"r9k_off"},
+		failures:  []string{"already_r9k_off", "usage_r9k_off"},
+	}
+	cmdMod = moderationCommand{
+		successes: []string{"mod_success"},
+		failures:  []string{"bad_mod_banned", "bad_mod_mod", "usage_mod"},
+	}
+	cmdUnmod = moderationCommand{
+		successes: []string{"unmod_success"},
+		failures:  []string{"bad_unmod_mod", "usage_unmod"},
+	}
+	cmdVIP = moderationCommand{
+		successes: []string{"vip_success"},
+		failures: []string{"bad_vip_grantee_banned", "bad_vip_grantee_already_vip", "bad_vip_max_vips_reached",
+			"bad_vip_achievement_incomplete", "usage_vip"},
+	}
+	cmdUnvip = moderationCommand{
+		successes: []string{"unvip_success"},
+		failures:  []string{"bad_unvip_grantee_not_vip", "usage_unvip"},
+	}
+)
+
+// pendingCommand a moderation command waiting for twitch to answer it
+type pendingCommand struct {
+	channel string
+	command moderationCommand
+	result  chan Message
+}
+
+// answeredBy reports whether msgID belongs to this command and whether it means success
+func (p *pendingCommand) answeredBy(msgID string) (matched, success bool) {
+	for _, id := range p.command.successes {
+		if id == msgID {
+			return true, true
+		}
+	}
+	for _, id := range p.command.failures {
+		if id == msgID {
+			return true, false
+		}
+	}
+	for _, id := range commonModerationFailures {
+		if id == msgID {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// pendingCommands moderation commands in the order they were sent
+type pendingCommands struct {
+	mtx      sync.Mutex
+	commands []*pendingCommand
+}
+
+func (p *pendingCommands) add(cmd *pendingCommand) {
+	p.mtx.Lock()
+	p.commands = append(p.commands, cmd)
+	p.mtx.Unlock()
+}
+
+func (p *pendingCommands) remove(cmd *pendingCommand) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for i, pending := range p.commands {
+		if pending == cmd {
+			p.commands = append(p.commands[:i], p.commands[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolve hands message to the oldest pending command in channel it answers, returns false if it answers none
+func (p *pendingCommands) resolve(channel string, message Message) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for i, pending := range p.commands {
+		if pending.channel != channel {
+			continue
+		}
+
+		var matched bool
+		switch message.Type {
+		case NOTICE:
+			matched, _ = pending.answeredBy(message.Tags["msg-id"])
+		case CLEARCHAT:
+			// a CLEARCHAT without a target user means the whole chat was cleared
+			matched = pending.command.clearchat && message.Tags["target-user-id"] == ""
+		}
+		if !matched {
+			continue
+		}
+
+		p.commands = append(p.commands[:i], p.commands[i+1:]...)
+		pending.result <- message
+		return true
+	}
+	return false
+}
+
+// Ban permanently bans username from channel, reason is optional
+// Moderation commands block until twitch answers them, so call them in their own goroutine when used from a callback
+func (c *Client) Ban(channel, username, reason string) (Message, error) {
+	if err := validateUsername(username); err != nil {
+		return Message{}, err
+	}
+	return c.moderate(channel, cmdBan, withReason("/ban "+username, reason))
+}
+
+// Unban lifts a ban or timeout of username in channel
+func (c *Client) Unban(channel, username string) (Message, error) {
+	if err := validateUsername(username); err != nil {
+		return Message{}, err
+	}
+	return c.moderate(channel, cmdUnban, "/unban "+username)
+}
+
+// Timeout times out username in channel for duration, reason is optional
+// twitch accepts durations between 1 second and 2 weeks
+func (c *Client) Timeout(channel, username string, duration time.Duration, reason string) (Message, error) {
+	if err := validateUsername(username); err != nil {
+		return Message{}, err
+	}
+	if duration < time.Second || duration > maxTimeoutDuration {
+		return Message{}, ErrInvalidDuration
+	}
+	return c.moderate(channel, cmdTimeout, withReason(fmt.Sprintf("/timeout %s %d", username, int(duration/time.Second)), reason))
+}
+
+// DeleteMessage deletes a single message in channel, msgID is the "id" tag of the message
+func (c *Client) DeleteMessage(channel, msgID string) (Message, error) {
+	if !validMessageID.MatchString(msgID) {
+		return Message{}, ErrInvalidMessageID
+	}
+	return c.moderate(channel, cmdDelete, "/delete "+msgID)
+}
+
+// Clear clears the whole chat history of channel
+// twitch confirms this with a CLEARCHAT message instead of a NOTICE, which is what gets returned on success
+func (c *Client) Clear(channel string) (Message, error) {
+	return c.moderate(channel, cmdClear, "/clear")
+}
+
+// Slow enables slow mode in channel, users have to wait interval between messages
+func (c *Client) Slow(channel string, interval time.Duration) (Message, error) {
+	if interval < time.Second || interval > maxSlowDuration {
+		return Message{}, ErrInvalidDuration
+	}
+	return c.moderate(channel, cmdSlow, fmt.Sprintf("/slow %d", int(interval/time.Second)))
+}
+
+// SlowOff disables slow mode in channel
+func (c *Client) SlowOff(channel string) (Message, error) {
+	return c.moderate(channel, cmdSlowOff, "/slowoff")
+}
+
+// FollowersOnly enables followers-only mode in channel, users have to follow for at least duration to chat
+// a duration of 0 allows all followers to chat
+func (c *Client) FollowersOnly(channel string, duration time.Duration) (Message, error) {
+	if duration < 0 || duration > maxFollowersOnlyDuration || duration%time.Minute != 0 {
+		return Message{}, ErrInvalidDuration
+	}
+	return c.moderate(channel, cmdFollowers, fmt.Sprintf("/followers %d", int(duration/time.Minute)))
+}
+
+// FollowersOnlyOff disables followers-only mode in channel
+func (c *Client) FollowersOnlyOff(channel string) (Message, error) {
+	return c.moderate(channel, cmdFollowersOff, "/followersoff")
+}
+
+// SubscribersOnly enables or disables subscribers-only mode in channel
+func (c *Client) SubscribersOnly(channel string, enabled bool) (Message, error) {
+	if enabled {
+		return c.moderate(channel, cmdSubs, "/subscribers")
+	}
+	return c.moderate(channel, cmdSubsOff, "/subscribersoff")
+}
+
+// EmoteOnly enables or disables emote-only mode in channel
+func (c *Client) EmoteOnly(channel string, enabled bool) (Message, error) {
+	if enabled {
+		return c.moderate(channel, cmdEmoteOnly, "/emoteonly")
+	}
+	return c.moderate(channel, cmdEmoteOnlyOff, "/emoteonlyoff")
+}
+
+// UniqueChat enables or disables unique chat mode (r9k) in channel
+func (c *Client) UniqueChat(channel string, enabled bool) (Message, error) {
+	if enabled {
+		return c.moderate(channel, cmdUniqueChat, "/uniquechat")
+	}
+	return c.moderate(channel, cmdUniqueChatOff, "/uniquechatoff")
+}
+
+// Mod grants moderator status to username in channel
+func (c *Client) Mod(channel, username string) (Message, error) {
+	if err := validateUsername(username); err != nil {
+		return Message{}, err
+	}
+	return c.moderate(channel, cmdMod, "/mod "+username)
+}
+
+// Unmod revokes moderator status of username in channel
+func (c *Client) Unmod(channel, username string) (Message, error) {
+	if err := validateUsername(username); err != nil {
+		return Message{}, err
+	}
+	return c.moderate(channel, cmdUnmod, "/unmod "+username)
+}
+
+// VIP grants VIP status to username in channel
+func (c *Client) VIP(channel, username string) (Message, error) {
+	if err := validateUsername(username); err != nil {
+		return Message{}, err
+	}
+	return c.moderate(channel, cmdVIP, "/vip "+username)
+}
+
+// Unvip revokes VIP status of username in channel
+func (c *Client) Unvip(channel, username string) (Message, error) {
+	if err := validateUsername(username); err != nil {
+		return Message{}, err
+	}
+	return c.moderate(channel, cmdUnvip, "/unvip "+username)
+}
+
+// moderate sends command to channel and waits for the message twitch answers it with
+func (c *Client) moderate(channel string, command moderationCommand, text string) (Message, error) {
+	if c.anonymous {
+		return Message{}, ErrAnonymousClient
+	}
+
+	channel = strings.ToLower(channel)
+	if !validUsername.MatchString(channel) {
+		return Message{}, ErrInvalidChannel
+	}
+
+	pending := &pendingCommand{
+		channel: channel,
+		command: command,
+		result:  make(chan Message, 1),
+	}
+	c.pendingCommands.add(pending)
+
+	// a command still queued after the timeout is dropped instead of being sent unnoticed later
+	err := c.queue(&queuedMessage{
+		Line:      fmt.Sprintf("PRIVMSG #%s :%s", channel, text),
+		Channel:   channel,
+		Priority:  PriorityModeration,
+		transient: true,
+		command:   true,
+	}, c.moderationTimeout)
+	if err != nil {
+		c.pendingCommands.remove(pending)
+		return Message{}, err
+	}
+
+	select {
+	case notice := <-pending.result:
+		if notice.Type == CLEARCHAT {
+			return notice, nil
+		}
+		msgID := notice.Tags["msg-id"]
+		if _, success := pending.answeredBy(msgID); !success {
+			return notice, &ModerationError{MsgID: msgID, Notice: notice}
+		}
+		return notice, nil
+	case <-time.After(c.moderationTimeout):
+		c.pendingCommands.remove(pending)
+		return Message{}, ErrModerationTimeout
+	}
+}
+
+func validateUsername(username string) error {
+	if !validUsername.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	return nil
+}
+
+func withReason(command, reason string) string {
+	// collapse whitespace so a reason can't smuggle extra lines onto the connection
+	reason = strings.Join(strings.Fields(reason), " ")
+	if reason == "" {
+		return command
+	}
+	return command + " " + reason
+}
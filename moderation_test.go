@@ -0,0 +1,169 @@
+package twitch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+// answerPrivmsgs answers every PRIVMSG with the lines returned by respond, instead of the server
+func answerPrivmsgs(server *twitchtest.Server, respond func(message string) []string) {
+	server.Handle(func(line string) ([]string, bool) {
+		if !strings.HasPrefix(line, "PRIVMSG") {
+			return nil, false
+		}
+		return respond(line), true
+	})
+}
+
+func connectModerationClient(t *testing.T, server *twitchtest.Server) *Client {
+	wait := make(chan struct{})
+
+	client := newQueueTestClient(server.Addr)
+	client.OnConnect(func() {
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire")
+	}
+
+	return client
+}
+
+func TestCanBanUser(t *testing.T) {
+	var received string
+
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		received = message
+		return []string{"@msg-id=ban_success :tmi.twitch.tv NOTICE #gempir :baduser is now banned from this channel."}
+	})
+	client := connectModerationClient(t, server)
+
+	notice, err := client.Ban("gempir", "baduser", "spamming links")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertStringsEqual(t, "PRIVMSG #gempir :/ban baduser spamming links", received)
+	assertStringsEqual(t, "ban_success", notice.Tags["msg-id"])
+}
+
+func TestCanTimeoutUser(t *testing.T) {
+	var received string
+
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		received = message
+		return []string{"@msg-id=timeout_success :tmi.twitch.tv NOTICE #gempir :baduser has been timed out for 10 minutes."}
+	})
+	client := connectModerationClient(t, server)
+
+	_, err := client.Timeout("gempir", "baduser", time.Minute*10, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertStringsEqual(t, "PRIVMSG #gempir :/timeout baduser 600", received)
+}
+
+func TestCanReceiveModerationFailure(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		return []string{"@msg-id=bad_ban_broadcaster :tmi.twitch.tv NOTICE #gempir :You cannot ban the broadcaster."}
+	})
+	client := connectModerationClient(t, server)
+
+	notice, err := client.Ban("gempir", "gempir", "")
+	modErr, ok := err.(*ModerationError)
+	if !ok {
+		t.Fatalf("expected ModerationError, got %v", err)
+	}
+
+	assertStringsEqual(t, "bad_ban_broadcaster", modErr.MsgID)
+	assertStringsEqual(t, "You cannot ban the broadcaster.", notice.Text)
+}
+
+func TestCanClearChat(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		return []string{"@room-id=11148817;tmi-sent-ts=1490382457309 :tmi.twitch.tv CLEARCHAT #gempir"}
+	})
+	client := connectModerationClient(t, server)
+
+	notice, err := client.Clear("gempir")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if notice.Type != CLEARCHAT {
+		t.Error("clear was not answered with CLEARCHAT")
+	}
+}
+
+func TestModerationIgnoresUnrelatedNotices(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		return []string{
+			"@msg-id=host_on :tmi.twitch.tv NOTICE #gempir :Now hosting KKona.",
+			"@msg-id=slow_on :tmi.twitch.tv NOTICE #gempir :This room is now in slow mode.",
+		}
+	})
+	client := connectModerationClient(t, server)
+
+	notice, err := client.Slow("gempir", time.Second*30)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertStringsEqual(t, "slow_on", notice.Tags["msg-id"])
+}
+
+func TestModerationTimesOutWithoutResponse(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	answerPrivmsgs(server, func(message string) []string {
+		return nil
+	})
+	client := connectModerationClient(t, server)
+	client.moderationTimeout = time.Millisecond * 100
+
+	if _, err := client.Unban("gempir", "baduser"); err != ErrModerationTimeout {
+		t.Fatalf("expected ErrModerationTimeout, got %v", err)
+	}
+}
+
+func TestModerationValidatesArguments(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+
+	if _, err := client.Ban("gempir", "bad user", ""); err != ErrInvalidUsername {
+		t.Errorf("expected ErrInvalidUsername, got %v", err)
+	}
+	if _, err := client.Mod("#gempir", "someone"); err != ErrInvalidChannel {
+		t.Errorf("expected ErrInvalidChannel, got %v", err)
+	}
+	if _, err := client.Timeout("gempir", "someone", time.Hour*24*15, ""); err != ErrInvalidDuration {
+		t.Errorf("expected ErrInvalidDuration, got %v", err)
+	}
+	if _, err := client.Slow("gempir", 0); err != ErrInvalidDuration {
+		t.Errorf("expected ErrInvalidDuration, got %v", err)
+	}
+	if _, err := client.FollowersOnly("gempir", time.Second*30); err != ErrInvalidDuration {
+		t.Errorf("expected ErrInvalidDuration, got %v", err)
+	}
+	if _, err := client.DeleteMessage("gempir", "not a message id"); err != ErrInvalidMessageID {
+		t.Errorf("expected ErrInvalidMessageID, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package twitch
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultPingInterval how often the client checks the connection with a PING
+	defaultPingInterval = time.Second * 15
+	// defaultPongTimeout how long to wait for the PONG before the connection counts as dead
+	defaultPongTimeout = time.Second * 5
+)
+
+// Latency returns the round-trip time of the last PING the client sent
+func (c *Client) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.latency))
+}
+
+// OnLatency attaches callback to every latency measurement
+func (c *Client) OnLatency(callback func(latency time.Duration)) {
+	c.onLatency = callback
+}
+
+// keepAlive pings conn every PingInterval until done is closed
+// a PONG that doesn't arrive within PongTimeout closes conn, which makes Connect() reconnect
+func (c *Client) keepAlive(conn net.Conn, done <-chan struct{}) {
+	if !c.SendPings || c.PingInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		// drop answers to earlier pings that arrived too late
+		select {
+		case <-c.pongs:
+		default:
+		}
+
+		nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+		sent := time.Now()
+		if _, err := conn.Write([]byte("PING :" + nonce + "\r\n")); err != nil {
+			return
+		}
+
+		timeout := time.NewTimer(c.PongTimeout)
+	wait:
+		for {
+			select {
+			case <-done:
+				timeout.Stop()
+				return
+			case pong := <-c.pongs:
+				if pong != nonce {
+					continue
+				}
+				latency := time.Since(sent)
+				atomic.StoreInt64(&c.latency, int64(latency))
+				c.metrics().Latency(latency)
+				if c.onLatency != nil {
+					c.onLatency(latency)
+				}
+				break wait
+			case <-timeout.C:
+				c.log(LevelWarn, "no PONG received, closing connection", "timeout", c.PongTimeout)
+				conn.Close()
+				return
+			}
+		}
+		timeout.Stop()
+	}
+}
+
+// handlePong hands the token of a PONG like ":tmi.twitch.tv PONG tmi.twitch.tv :nonce" to keepAlive
+func (c *Client) handlePong(line string) {
+	spl := strings.SplitN(line, " :", 2)
+	if len(spl) != 2 {
+		return
+	}
+
+	select {
+	case c.pongs <- spl[1]:
+	default:
+	}
+}
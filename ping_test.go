@@ -0,0 +1,97 @@
+package twitch
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanMeasureLatency(t *testing.T) {
+	wait := make(chan time.Duration, 1)
+	var conn net.Conn
+
+	host := startServer(t, func(c net.Conn) {
+		conn = c
+	}, func(message string) {
+		if strings.HasPrefix(message, "PING") {
+			fmt.Fprintf(conn, ":tmi.twitch.tv PONG tmi.twitch.tv %s\r\n", strings.TrimPrefix(message, "PING "))
+		}
+	})
+
+	client := newTestClient(host)
+	client.PingInterval = time.Millisecond * 50
+	client.OnLatency(func(latency time.Duration) {
+		select {
+		case wait <- latency:
+		default:
+		}
+	})
+
+	go client.Connect()
+
+	var latency time.Duration
+	select {
+	case latency = <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnLatency did not fire")
+	}
+
+	assertTrue(t, latency > 0, "latency was not measured")
+	assertTrue(t, client.Latency() > 0, "Latency() did not return the measurement")
+}
+
+func TestReconnectsWhenPongIsMissing(t *testing.T) {
+	wait := make(chan bool)
+
+	host := startServerMultiConns(t, 2, func(conn net.Conn) {
+		wait <- true
+	}, nothingOnMessage)
+
+	client := newTestClient(host)
+	client.PingInterval = time.Millisecond * 50
+	client.PongTimeout = time.Millisecond * 50
+
+	go client.Connect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-wait:
+		case <-time.After(time.Second * 3):
+			t.Fatalf("connection %d was not established", i+1)
+		}
+	}
+}
+
+func TestDoesNotPingWhenDisabled(t *testing.T) {
+	pinged := make(chan struct{}, 1)
+	connected := make(chan struct{})
+
+	host := startServer(t, nothingOnConnect, func(message string) {
+		if strings.HasPrefix(message, "PING") {
+			pinged <- struct{}{}
+		}
+	})
+
+	client := newTestClient(host)
+	client.SendPings = false
+	client.PingInterval = time.Millisecond * 10
+	client.OnConnect(func() {
+		close(connected)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire")
+	}
+
+	select {
+	case <-pinged:
+		t.Fatal("client sent PING with SendPings disabled")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
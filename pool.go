@@ -0,0 +1,602 @@
+package twitch
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultChannelsPerConnection how many channels a Pool joins on one connection before opening another
+	defaultChannelsPerConnection = 50
+	// defaultRebalanceDelay how long a Pool waits before moving the channels of a dropped connection
+	defaultRebalanceDelay = time.Second
+	// maxRebalanceDelay upper bound of the wait, it doubles while new connections keep failing
+	maxRebalanceDelay = time.Minute
+)
+
+// Pool spreads joined channels across multiple connections, opening new ones as needed
+// Messages are sent on a separate connection, so a busy sender doesn't hold up reading
+type Pool struct {
+	// ChannelsPerConnection maximum number of channels joined on a single connection
+	ChannelsPerConnection int
+	// Configure is called with every client the pool creates, before it connects
+	Configure func(client *Client)
+
+	username       string
+	oauth          string
+	rebalanceDelay time.Duration
+
+	mtx            sync.Mutex
+	sender         *Client
+	senderChannels map[string]bool
+	conns          []*poolConn
+	channels       map[string]*poolConn
+	callbacks      poolCallbacks
+	// backoff the current wait before replacing a failed connection, 0 once a connection succeeded
+	backoff   time.Duration
+	connected bool
+	closed    bool
+	err       error
+	done      chan struct{}
+}
+
+// poolCallbacks the callbacks of a Pool
+// they can be set while the connections are running, so every connection forwards to them instead of holding them itself
+type poolCallbacks struct {
+	mtx                    sync.RWMutex
+	onConnect              func()
+	onNewWhisper           func(user User, message Message)
+	onNewMessage           func(channel string, user User, message Message)
+	onNewRoomstateMessage  func(channel string, user User, message Message)
+	onNewClearchatMessage  func(channel string, user User, message Message)
+	onNewUsernoticeMessage func(channel string, user User, message Message)
+	onNewNoticeMessage     func(channel string, user User, message Message)
+	onNewUserstateMessage  func(channel string, user User, message Message)
+	onUserJoin             func(channel, user string)
+	onUserPart             func(channel, user string)
+	onNewUnsetMessage      func(rawMessage string)
+}
+
+// poolConn a reading connection of a Pool and the channels it joined
+type poolConn struct {
+	client   *Client
+	channels map[string]bool
+}
+
+// NewPool to create a new pool of connections
+func NewPool(username, oauth string) *Pool {
+	return &Pool{
+		ChannelsPerConnection: defaultChannelsPerConnection,
+		username:              username,
+		oauth:                 oauth,
+		rebalanceDelay:        defaultRebalanceDelay,
+		channels:              map[string]*poolConn{},
+		senderChannels:        map[string]bool{},
+		done:                  make(chan struct{}),
+	}
+}
+
+// OnConnect attach callback to when one of the reading connections has been established
+func (p *Pool) OnConnect(callback func()) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onConnect = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewWhisper attach callback to new whisper
+// whispers reach every connection, they are only read from the sending connection
+func (p *Pool) OnNewWhisper(callback func(user User, message Message)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewWhisper = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewMessage attach callback to new standard chat messages
+func (p *Pool) OnNewMessage(callback func(channel string, user User, message Message)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewMessage = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewRoomstateMessage attach callback to new messages such as submode enabled
+func (p *Pool) OnNewRoomstateMessage(callback func(channel string, user User, message Message)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewRoomstateMessage = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewClearchatMessage attach callback to new messages such as timeouts
+func (p *Pool) OnNewClearchatMessage(callback func(channel string, user User, message Message)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewClearchatMessage = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewUsernoticeMessage attach callback to new usernotice message such as sub, resub, and raids
+func (p *Pool) OnNewUsernoticeMessage(callback func(channel string, user User, message Message)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewUsernoticeMessage = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewNoticeMessage attach callback to new notice message such as hosts
+func (p *Pool) OnNewNoticeMessage(callback func(channel string, user User, message Message)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewNoticeMessage = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewUserstateMessage attach callback to new userstate
+func (p *Pool) OnNewUserstateMessage(callback func(channel string, user User, message Message)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewUserstateMessage = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnUserJoin attaches callback to user joins
+func (p *Pool) OnUserJoin(callback func(channel, user string)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onUserJoin = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnUserPart attaches callback to user parts
+func (p *Pool) OnUserPart(callback func(channel, user string)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onUserPart = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// OnNewUnsetMessage attaches callback to messages that didn't parse properly
+func (p *Pool) OnNewUnsetMessage(callback func(rawMessage string)) {
+	p.callbacks.mtx.Lock()
+	p.callbacks.onNewUnsetMessage = callback
+	p.callbacks.mtx.Unlock()
+}
+
+// Say write something in a chat, using the sending connection
+// the sending connection joins channel as well, to learn about slow mode and its own badges in it
+func (p *Pool) Say(channel, text string) error {
+	channel = strings.ToLower(channel)
+
+	p.mtx.Lock()
+	sender := p.senderClient()
+	if !p.senderChannels[channel] && !p.closed {
+		p.senderChannels[channel] = true
+		sender.Join(channel)
+	}
+	p.mtx.Unlock()
+
+	return sender.Say(channel, text)
+}
+
+// Whisper write something in private to someone on twitch, using the sending connection
+func (p *Pool) Whisper(username, text string) error {
+	p.mtx.Lock()
+	sender := p.senderClient()
+	p.mtx.Unlock()
+
+	return sender.Whisper(username, text)
+}
+
+// Join enter a twitch channel on a connection that has room for it, opening a new connection if none has
+func (p *Pool) Join(channel string) {
+	channel = strings.ToLower(channel)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if _, ok := p.channels[channel]; ok || p.closed {
+		return
+	}
+
+	conn := p.connWithRoom()
+	conn.channels[channel] = true
+	p.channels[channel] = conn
+	conn.client.Join(channel)
+}
+
+// Depart leave a twitch channel, closing its connection if no channels are left on it
+func (p *Pool) Depart(channel string) {
+	channel = strings.ToLower(channel)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.senderChannels[channel] {
+		delete(p.senderChannels, channel)
+		p.sender.Depart(channel)
+	}
+
+	conn, ok := p.channels[channel]
+	if !ok {
+		return
+	}
+
+	delete(p.channels, channel)
+	delete(conn.channels, channel)
+	conn.client.Depart(channel)
+
+	if len(conn.channels) == 0 {
+		p.removeConn(conn)
+		conn.client.Disconnect()
+	}
+}
+
+// Userlist returns the userlist for a given channel
+func (p *Pool) Userlist(channel string) ([]string, error) {
+	channel = strings.ToLower(channel)
+
+	p.mtx.Lock()
+	conn, ok := p.channels[channel]
+	p.mtx.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Could not find userlist for channel '%s' in pool", channel)
+	}
+
+	return conn.client.Userlist(channel)
+}
+
+// Connections returns the number of reading connections, not counting the sending connection
+func (p *Pool) Connections() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return len(p.conns)
+}
+
+// Connect connects every connection of the pool and blocks until Disconnect() is called
+// or a connection fails to authenticate
+func (p *Pool) Connect() error {
+	p.mtx.Lock()
+	if p.connected || p.closed {
+		p.mtx.Unlock()
+		return ErrClientDisconnected
+	}
+	p.connected = true
+
+	go p.runSender(p.senderClient())
+	for _, conn := range p.conns {
+		go p.run(conn)
+	}
+	p.mtx.Unlock()
+
+	<-p.done
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.err
+}
+
+// Disconnect closes every connection of the pool
+func (p *Pool) Disconnect() error {
+	p.stop(ErrClientDisconnected)
+	return nil
+}
+
+func (p *Pool) stop(err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.err = err
+
+	for _, conn := range p.conns {
+		conn.client.Disconnect()
+	}
+	if p.sender != nil {
+		p.sender.Disconnect()
+	}
+	close(p.done)
+}
+
+// run keeps conn connected and moves its channels to other connections once its connection drops
+func (p *Pool) run(conn *poolConn) {
+	dropped, permanent := p.watchState(conn.client)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- conn.client.Connect()
+	}()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-dropped:
+		// the client would join the same channels again on its own, the pool spreads them instead
+		conn.client.Disconnect()
+		err = <-result
+	}
+
+	if tokenErr := permanentError(permanent); tokenErr != nil {
+		p.stop(tokenErr)
+		return
+	}
+	if err == ErrLoginAuthenticationFailed {
+		p.stop(err)
+		return
+	}
+
+	p.mtx.Lock()
+	// the pool is shutting down or the connection was closed on purpose
+	if p.closed || !p.removeConn(conn) {
+		p.mtx.Unlock()
+		return
+	}
+	p.mtx.Unlock()
+
+	if !p.wait() {
+		return
+	}
+
+	p.mtx.Lock()
+	var channels []string
+	for channel := range conn.channels {
+		// departed or joined again while waiting
+		if p.channels[channel] == conn {
+			delete(p.channels, channel)
+			channels = append(channels, channel)
+		}
+	}
+	p.mtx.Unlock()
+
+	for _, channel := range channels {
+		p.Join(channel)
+	}
+}
+
+// runSender keeps the sending connection connected
+func (p *Pool) runSender(sender *Client) {
+	_, permanent := p.watchState(sender)
+
+	for {
+		err := sender.Connect()
+		if tokenErr := permanentError(permanent); tokenErr != nil {
+			p.stop(tokenErr)
+			return
+		}
+		if err == ErrLoginAuthenticationFailed {
+			p.stop(err)
+			return
+		}
+
+		if !p.wait() {
+			return
+		}
+	}
+}
+
+// watchState reports on dropped when the established connection of client drops, and on permanent the error
+// of a *PermanentTokenError client gave up on, the state change callback set by Configure is still called
+func (p *Pool) watchState(client *Client) (dropped chan struct{}, permanent chan error) {
+	dropped = make(chan struct{}, 1)
+	permanent = make(chan error, 1)
+
+	client.stateMtx.Lock()
+	configured := client.onStateChange
+	client.stateMtx.Unlock()
+
+	client.OnStateChange(func(from, to ConnectionState, err error) {
+		if configured != nil {
+			configured(from, to, err)
+		}
+
+		switch {
+		case to == StateConnected:
+			p.mtx.Lock()
+			p.backoff = 0
+			p.mtx.Unlock()
+		case to == StateReconnecting && from == StateConnected:
+			select {
+			case dropped <- struct{}{}:
+			default:
+			}
+		case to == StateDisconnected:
+			if tokenErr, ok := err.(*PermanentTokenError); ok {
+				select {
+				case permanent <- tokenErr.Err:
+				default:
+				}
+			}
+		}
+	})
+
+	return dropped, permanent
+}
+
+// permanentError returns the error reported on permanent, or nil
+func permanentError(permanent chan error) error {
+	select {
+	case err := <-permanent:
+		return err
+	default:
+		return nil
+	}
+}
+
+// wait sleeps before a failed connection is replaced, doubling the wait while connections keep failing
+// returns false if the pool was closed in the meantime
+func (p *Pool) wait() bool {
+	p.mtx.Lock()
+	if p.backoff == 0 {
+		p.backoff = p.rebalanceDelay
+	} else if p.backoff *= 2; p.backoff > maxRebalanceDelay {
+		p.backoff = maxRebalanceDelay
+	}
+	backoff := p.backoff
+	p.mtx.Unlock()
+
+	select {
+	case <-p.done:
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
+
+// senderClient returns the sending connection, creating it if needed
+// must be called with p.mtx held
+func (p *Pool) senderClient() *Client {
+	if p.sender == nil {
+		p.sender = p.newClient()
+		// the sender only needs NOTICEs about its own messages, no membership events
+		p.sender.Capabilities = []string{TagsCapability, CommandsCapability}
+		p.sender.OnNewWhisper(func(user User, message Message) {
+			p.callbacks.mtx.RLock()
+			callback := p.callbacks.onNewWhisper
+			p.callbacks.mtx.RUnlock()
+
+			if callback != nil {
+				callback(user, message)
+			}
+		})
+	}
+
+	return p.sender
+}
+
+// connWithRoom returns a connection that can join another channel, opening a new one if needed
+// must be called with p.mtx held
+func (p *Pool) connWithRoom() *poolConn {
+	for _, conn := range p.conns {
+		if len(conn.channels) < p.ChannelsPerConnection {
+			return conn
+		}
+	}
+
+	conn := &poolConn{
+		client:   p.newClient(),
+		channels: map[string]bool{},
+	}
+	p.forwardCallbacks(conn.client)
+	p.conns = append(p.conns, conn)
+
+	if p.connected {
+		go p.run(conn)
+	}
+
+	return conn
+}
+
+// removeConn removes conn from the pool, returns false if it was not part of it anymore
+// must be called with p.mtx held
+func (p *Pool) removeConn(conn *poolConn) bool {
+	for i, c := range p.conns {
+		if c == conn {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) newClient() *Client {
+	client := NewClient(p.username, p.oauth)
+	if p.Configure != nil {
+		p.Configure(client)
+	}
+
+	return client
+}
+
+// forwardCallbacks sets the callbacks of a reading connection, before it connects, to call the current callbacks of the pool
+func (p *Pool) forwardCallbacks(client *Client) {
+	cb := &p.callbacks
+
+	client.OnConnect(func() {
+		cb.mtx.RLock()
+		callback := cb.onConnect
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback()
+		}
+	})
+	client.OnNewMessage(func(channel string, user User, message Message) {
+		cb.mtx.RLock()
+		callback := cb.onNewMessage
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user, message)
+		}
+	})
+	client.OnNewRoomstateMessage(func(channel string, user User, message Message) {
+		cb.mtx.RLock()
+		callback := cb.onNewRoomstateMessage
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user, message)
+		}
+	})
+	client.OnNewClearchatMessage(func(channel string, user User, message Message) {
+		cb.mtx.RLock()
+		callback := cb.onNewClearchatMessage
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user, message)
+		}
+	})
+	client.OnNewUsernoticeMessage(func(channel string, user User, message Message) {
+		cb.mtx.RLock()
+		callback := cb.onNewUsernoticeMessage
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user, message)
+		}
+	})
+	client.OnNewNoticeMessage(func(channel string, user User, message Message) {
+		cb.mtx.RLock()
+		callback := cb.onNewNoticeMessage
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user, message)
+		}
+	})
+	client.OnNewUserstateMessage(func(channel string, user User, message Message) {
+		cb.mtx.RLock()
+		callback := cb.onNewUserstateMessage
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user, message)
+		}
+	})
+	client.OnUserJoin(func(channel, user string) {
+		cb.mtx.RLock()
+		callback := cb.onUserJoin
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user)
+		}
+	})
+	client.OnUserPart(func(channel, user string) {
+		cb.mtx.RLock()
+		callback := cb.onUserPart
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(channel, user)
+		}
+	})
+	client.OnNewUnsetMessage(func(rawMessage string) {
+		cb.mtx.RLock()
+		callback := cb.onNewUnsetMessage
+		cb.mtx.RUnlock()
+
+		if callback != nil {
+			callback(rawMessage)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package twitch
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+// waitFor waits until server received count more lines starting with prefix
+func waitFor(t *testing.T, server *twitchtest.Server, count int, prefix string) {
+	for ; count > 0; count-- {
+		if _, err := server.WaitFor(prefix, time.Second*3); err != nil {
+			t.Fatalf("did not receive %d more lines starting with %q", count, prefix)
+		}
+	}
+}
+
+// privmsgs returns the PRIVMSGs in lines
+func privmsgs(lines []string) []string {
+	var privmsgs []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "PRIVMSG") {
+			privmsgs = append(privmsgs, line)
+		}
+	}
+	return privmsgs
+}
+
+func newTestPool(server *twitchtest.Server) *Pool {
+	pool := NewPool("justinfan123123", "oauth:123123132")
+	pool.ChannelsPerConnection = 2
+	pool.rebalanceDelay = time.Millisecond * 10
+	pool.Configure = func(client *Client) {
+		client.TLS = false
+		client.IrcAddress = server.Addr
+		client.SendPings = false
+	}
+
+	return pool
+}
+
+func TestPoolShardsChannelsAcrossConnections(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	pool := newTestPool(server)
+
+	for _, channel := range []string{"a", "b", "c", "d", "e"} {
+		pool.Join(channel)
+	}
+
+	go pool.Connect()
+	defer pool.Disconnect()
+
+	waitFor(t, server, 5, "JOIN")
+
+	assertIntsEqual(t, 3, pool.Connections())
+
+	joined, total := 0, 0
+	for _, conn := range server.Conns() {
+		channels := conn.Channels()
+		if len(channels) == 0 {
+			continue
+		}
+		joined++
+		assertTrue(t, len(channels) <= 2, "connection joined more channels than allowed")
+		total += len(channels)
+	}
+	assertIntsEqual(t, 3, joined)
+	assertIntsEqual(t, 5, total)
+}
+
+func TestPoolGrowsAfterConnect(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	pool := newTestPool(server)
+	pool.Join("a")
+
+	go pool.Connect()
+	defer pool.Disconnect()
+
+	waitFor(t, server, 1, "JOIN")
+
+	pool.Join("b")
+	pool.Join("c")
+
+	waitFor(t, server, 2, "JOIN")
+	assertIntsEqual(t, 2, pool.Connections())
+
+	if _, err := pool.Userlist("B"); err != nil {
+		t.Fatalf("expected userlist of b, got %v", err)
+	}
+}
+
+func TestPoolSaysOnSeparateConnection(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	pool := newTestPool(server)
+	pool.Join("gempir")
+
+	go pool.Connect()
+	defer pool.Disconnect()
+
+	waitFor(t, server, 1, "JOIN")
+
+	pool.Say("gempir", "hello")
+	waitFor(t, server, 1, "PRIVMSG")
+
+	senders := 0
+	for _, conn := range server.Conns() {
+		sent := privmsgs(conn.Received())
+		if len(sent) == 0 {
+			continue
+		}
+		senders++
+		assertStringSlicesEqual(t, []string{"PRIVMSG #gempir :hello"}, sent)
+		// the sender joins the channel it writes to, apart from the reading connection
+		assertStringSlicesEqual(t, []string{"gempir"}, conn.Channels())
+	}
+	assertIntsEqual(t, 1, senders)
+	assertIntsEqual(t, 1, pool.Connections())
+}
+
+func TestPoolCallbacksCanBeSetWhileConnected(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	pool := newTestPool(server)
+	pool.Join("gempir")
+
+	go pool.Connect()
+	defer pool.Disconnect()
+
+	waitFor(t, server, 1, "JOIN")
+
+	received := make(chan string, 1)
+	pool.OnNewMessage(func(channel string, user User, message Message) {
+		received <- message.Text
+	})
+
+	server.Send("@id=1 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :set late")
+
+	select {
+	case text := <-received:
+		assertStringsEqual(t, "set late", text)
+	case <-time.After(time.Second * 3):
+		t.Fatal("callback set after Connect was not called")
+	}
+}
+
+func TestPoolRebalancesDroppedConnection(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	pool := newTestPool(server)
+	pool.Join("a")
+	pool.Join("b")
+	pool.Join("c")
+
+	go pool.Connect()
+	defer pool.Disconnect()
+
+	waitFor(t, server, 3, "JOIN")
+
+	pool.mtx.Lock()
+	dropped := pool.channels["a"]
+	pool.mtx.Unlock()
+
+	for _, conn := range server.Conns() {
+		if channels := conn.Channels(); len(channels) == 2 {
+			conn.Close()
+		}
+	}
+
+	// the two channels of the dropped connection are joined again elsewhere
+	waitFor(t, server, 2, "JOIN")
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	assertIntsEqual(t, 3, len(pool.channels))
+	for channel, conn := range pool.channels {
+		assertTrue(t, conn != dropped, "channel "+channel+" still assigned to dropped connection")
+	}
+}
+
+func TestPoolStopsOnPermanentTokenError(t *testing.T) {
+	providerErr := errors.New("refresh token revoked")
+	server := twitchtest.NewServer()
+	defer server.Close()
+	pool := newTestPool(server)
+	configure := pool.Configure
+	pool.Configure = func(client *Client) {
+		configure(client)
+		client.SetTokenProvider(&stubTokenProvider{err: &PermanentTokenError{Err: providerErr}})
+	}
+	pool.Join("gempir")
+
+	errs := make(chan error)
+	go func() {
+		errs <- pool.Connect()
+	}()
+
+	select {
+	case err := <-errs:
+		if err != providerErr {
+			t.Fatalf("expected token provider error, got %v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("pool kept replacing connections after a permanent token error")
+	}
+}
+
+func TestPoolBacksOffWhileConnectionsFail(t *testing.T) {
+	pool := NewPool("justinfan123123", "oauth:123123132")
+	pool.rebalanceDelay = time.Millisecond * 10
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assertTrue(t, pool.wait(), "wait returned false on an open pool")
+	}
+	// 10ms, 20ms and 40ms
+	assertTrue(t, time.Since(start) >= time.Millisecond*70, "wait did not back off")
+
+	close(pool.done)
+	assertFalse(t, pool.wait(), "wait returned true on a closed pool")
+}
+
+func TestPoolDepartClosesEmptyConnection(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	pool := newTestPool(server)
+	pool.Join("a")
+	pool.Join("b")
+	pool.Join("c")
+
+	go pool.Connect()
+	defer pool.Disconnect()
+
+	waitFor(t, server, 3, "JOIN")
+
+	pool.Depart("c")
+	assertIntsEqual(t, 1, pool.Connections())
+}
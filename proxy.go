@@ -0,0 +1,211 @@
+package twitch
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// proxyHandshakeTimeout how long a proxy may take to open the tunnel
+const proxyHandshakeTimeout = time.Second * 10
+
+// ErrProxyRefused returned from Connect() when the proxy did not open a tunnel to the irc server
+var ErrProxyRefused = errors.New("proxy refused connection")
+
+// ProxyDialContext returns a DialContextFunc that tunnels every connection through the proxy at proxyURL
+// Supported schemes are socks5:// and http:// (HTTP CONNECT), credentials are taken from the url
+// forward opens the connection to the proxy itself, nil uses a default net.Dialer
+func ProxyDialContext(proxyURL *url.URL, forward DialContextFunc) (DialContextFunc, error) {
+	forward = dialContext(forward)
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := forward(ctx, "tcp", proxyURL.Host)
+			if err != nil {
+				return nil, err
+			}
+			if err = socks5Connect(conn, proxyURL.User, address, proxyHandshakeTimeout); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}, nil
+	case "http":
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := forward(ctx, "tcp", proxyURL.Host)
+			if err != nil {
+				return nil, err
+			}
+			tunnel, err := httpConnect(conn, proxyURL.User, address, proxyHandshakeTimeout)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tunnel, nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+}
+
+// socks5Connect asks a SOCKS5 proxy to connect conn to address, see RFC 1928 and RFC 1929
+// the proxy has to finish within timeout
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string, timeout time.Duration) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	// every length is sent as a single byte
+	if len(host) > 255 {
+		return errors.New("socks5 host name too long")
+	}
+	if user != nil {
+		if len(user.Username()) > 255 {
+			return errors.New("socks5 username too long")
+		}
+		if password, _ := user.Password(); len(password) > 255 {
+			return errors.New("socks5 password too long")
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// version 5, offering "no authentication" and, with credentials, "username/password"
+	greeting := []byte{0x05, 0x01, 0x00}
+	if user != nil {
+		greeting = []byte{0x05, 0x02, 0x00, 0x02}
+	}
+	if _, err = conn.Write(greeting); err != nil {
+		return err
+	}
+
+	var method [2]byte
+	if _, err = io.ReadFull(conn, method[:]); err != nil {
+		return err
+	}
+	if method[0] != 0x05 {
+		return ErrProxyRefused
+	}
+
+	switch method[1] {
+	case 0x00:
+	case 0x02:
+		if user == nil {
+			return ErrProxyRefused
+		}
+		password, _ := user.Password()
+		auth := []byte{0x01, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+
+		var status [2]byte
+		if _, err = io.ReadFull(conn, status[:]); err != nil {
+			return err
+		}
+		if status[1] != 0x00 {
+			return ErrProxyRefused
+		}
+	default:
+		return ErrProxyRefused
+	}
+
+	// CONNECT with a domain name, the proxy resolves it
+	request := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, 0, 0)
+	binary.BigEndian.PutUint16(request[len(request)-2:], uint16(port))
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+
+	var reply [4]byte
+	if _, err = io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return ErrProxyRefused
+	}
+
+	// skip the address the proxy bound to
+	var skip int
+	switch reply[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		var length [1]byte
+		if _, err = io.ReadFull(conn, length[:]); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return ErrProxyRefused
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// httpConnect opens a tunnel to address through an HTTP proxy, the proxy has to answer within timeout
+func httpConnect(conn net.Conn, user *url.Userinfo, address string, timeout time.Duration) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrProxyRefused
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn a connection that was partly read into a bufio.Reader already
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
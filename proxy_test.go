@@ -0,0 +1,313 @@
+package twitch
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startProxy accepts a single connection, lets handshake read the target address and pipes it through
+func startProxy(t *testing.T, handshake func(conn net.Conn, reader *bufio.Reader) string) string {
+	host := "127.0.0.1:" + strconv.Itoa(startPort)
+	startPort++
+
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		reader := bufio.NewReader(conn)
+		target, err := net.Dial("tcp", handshake(conn, reader))
+		if err != nil {
+			conn.Close()
+			return
+		}
+
+		go io.Copy(target, reader)
+		io.Copy(conn, target)
+	}()
+
+	return host
+}
+
+func socks5Handshake(expectedUser, expectedPassword string) func(conn net.Conn, reader *bufio.Reader) string {
+	return func(conn net.Conn, reader *bufio.Reader) string {
+		greeting := make([]byte, 2)
+		io.ReadFull(reader, greeting)
+		io.ReadFull(reader, make([]byte, greeting[1]))
+
+		if expectedUser == "" {
+			conn.Write([]byte{0x05, 0x00})
+		} else {
+			conn.Write([]byte{0x05, 0x02})
+			header := make([]byte, 2)
+			io.ReadFull(reader, header)
+			user := make([]byte, header[1])
+			io.ReadFull(reader, user)
+			length, _ := reader.ReadByte()
+			password := make([]byte, length)
+			io.ReadFull(reader, password)
+			if string(user) != expectedUser || string(password) != expectedPassword {
+				conn.Write([]byte{0x01, 0x01})
+				return ""
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+
+		request := make([]byte, 5)
+		io.ReadFull(reader, request)
+		host := make([]byte, request[4])
+		io.ReadFull(reader, host)
+		port := make([]byte, 2)
+		io.ReadFull(reader, port)
+
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+
+		return net.JoinHostPort(string(host), strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	}
+}
+
+func httpConnectHandshake(conn net.Conn, reader *bufio.Reader) string {
+	req, err := http.ReadRequest(reader)
+	if err != nil || req.Method != http.MethodConnect {
+		return ""
+	}
+
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return req.Host
+}
+
+func TestCanConnectThroughSOCKS5Proxy(t *testing.T) {
+	wait := make(chan struct{})
+
+	host := startServer(t, nothingOnConnect, nothingOnMessage)
+	proxy := startProxy(t, socks5Handshake("bot", "hunter2"))
+
+	client := newTestClient(host)
+	client.Proxy = &url.URL{Scheme: "socks5", Host: proxy, User: url.UserPassword("bot", "hunter2")}
+	client.OnConnect(func() {
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire through socks5 proxy")
+	}
+}
+
+func TestCanConnectThroughHTTPProxy(t *testing.T) {
+	wait := make(chan struct{})
+
+	host := startServer(t, nothingOnConnect, nothingOnMessage)
+	proxy := startProxy(t, httpConnectHandshake)
+
+	client := newTestClient(host)
+	client.Proxy = &url.URL{Scheme: "http", Host: proxy}
+	client.OnConnect(func() {
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire through http proxy")
+	}
+}
+
+func TestSOCKS5ProxyRejectsWrongCredentials(t *testing.T) {
+	proxy := startProxy(t, socks5Handshake("bot", "hunter2"))
+
+	dial, err := ProxyDialContext(&url.URL{Scheme: "socks5", Host: proxy, User: url.UserPassword("bot", "wrong")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = dial(context.Background(), "tcp", "127.0.0.1:6667"); err != ErrProxyRefused {
+		t.Fatalf("expected ErrProxyRefused, got %v", err)
+	}
+}
+
+func TestSOCKS5RejectsCredentialsTooLong(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	long := strings.Repeat("a", 256)
+	for _, user := range []*url.Userinfo{url.UserPassword(long, "hunter2"), url.UserPassword("bot", long)} {
+		if err := socks5Connect(clientSide, user, "127.0.0.1:6667", time.Second); err == nil {
+			t.Fatal("expected an error for credentials longer than 255 bytes")
+		}
+	}
+}
+
+func TestProxyHandshakeTimesOut(t *testing.T) {
+	handshakes := map[string]func(conn net.Conn) error{
+		"socks5": func(conn net.Conn) error {
+			return socks5Connect(conn, nil, "127.0.0.1:6667", time.Millisecond*50)
+		},
+		"http": func(conn net.Conn) error {
+			_, err := httpConnect(conn, nil, "127.0.0.1:6667", time.Millisecond*50)
+			return err
+		},
+	}
+
+	for name, handshake := range handshakes {
+		clientSide, serverSide := net.Pipe()
+		// the proxy reads the request but never answers it
+		go io.Copy(ioutil.Discard, serverSide)
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- handshake(clientSide)
+		}()
+
+		select {
+		case err := <-errs:
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				t.Fatalf("%s: expected a timeout, got %v", name, err)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("%s: handshake did not time out", name)
+		}
+
+		clientSide.Close()
+		serverSide.Close()
+	}
+}
+
+func TestCanNotUseUnknownProxyScheme(t *testing.T) {
+	if _, err := ProxyDialContext(&url.URL{Scheme: "ftp", Host: "127.0.0.1:21"}, nil); err == nil {
+		t.Fatal("expected error for unknown proxy scheme")
+	}
+}
+
+func TestCanUseCustomDialContext(t *testing.T) {
+	wait := make(chan struct{})
+	var dialed string
+
+	host := startServer(t, nothingOnConnect, nothingOnMessage)
+
+	client := newTestClient(host)
+	client.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+	client.OnConnect(func() {
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire")
+	}
+
+	assertStringsEqual(t, host, dialed)
+}
+
+func TestCanNotConnectToUntrustedLocalServer(t *testing.T) {
+	host := "127.0.0.1:" + strconv.Itoa(startPort)
+	startPort++
+
+	cert, err := tls.LoadX509KeyPair("test_resources/server.crt", "test_resources/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", host, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// reading completes the handshake the client is going to abort
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}()
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = host
+
+	err = client.Connect()
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected certificate error, got %v", err)
+	}
+}
+
+func TestCanConnectOverWebSocketThroughProxy(t *testing.T) {
+	wait := make(chan struct{})
+	tunneled := make(chan string, 1)
+
+	host := startWebSocketServer(t, true, nothingOnConnect, nothingOnMessage)
+	proxy := startProxy(t, func(conn net.Conn, reader *bufio.Reader) string {
+		target := httpConnectHandshake(conn, reader)
+		tunneled <- target
+		return target
+	})
+
+	client := newTestClient(host)
+	client.Transport = &WebSocketTransport{}
+	client.Proxy = &url.URL{Scheme: "http", Host: proxy}
+	client.OnConnect(func() {
+		close(wait)
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("OnConnect did not fire over websocket through http proxy")
+	}
+
+	assertStringsEqual(t, strings.TrimPrefix(host, "wss://"), <-tunneled)
+}
+
+func TestConnectRejectsConflictingTransportOptions(t *testing.T) {
+	dial := (&net.Dialer{}).DialContext
+
+	client := newTestClient("127.0.0.1:1")
+	client.DialContext = dial
+	client.Transport = &WebSocketTransport{DialContext: dial}
+	if err := client.Connect(); err != ErrTransportOptions {
+		t.Fatalf("expected ErrTransportOptions for two DialContexts, got %v", err)
+	}
+
+	client = NewClient("justinfan123123", "oauth:123123132")
+	client.Proxy = &url.URL{Scheme: "http", Host: "127.0.0.1:1"}
+	client.Transport = &ReplayTransport{Recording: strings.NewReader("")}
+	if err := client.Connect(); err != ErrTransportOptions {
+		t.Fatalf("expected ErrTransportOptions for a proxy with a ReplayTransport, got %v", err)
+	}
+}
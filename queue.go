@@ -0,0 +1,487 @@
+package twitch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxQueueSize how many outgoing messages are held while the client is not connected
+	defaultMaxQueueSize = 1000
+	// spoolCompactSlack how many records the spool file may hold beyond twice the queued messages before it is rewritten
+	spoolCompactSlack = 100
+)
+
+var (
+	// ErrQueueFull returned from methods writing to chat when MaxQueueSize messages are already waiting to be sent
+	ErrQueueFull = errors.New("outgoing message queue is full")
+
+	// ErrInvalidPriority returned from SayMessage when Priority is none of the Priority constants
+	ErrInvalidPriority = errors.New("invalid message priority")
+)
+
+// MessagePriority the lane an outgoing message waits in, lanes share the rate limit and are served in strict priority order
+type MessagePriority int
+
+const (
+	// PriorityBulk only sent when no other message is waiting
+	PriorityBulk MessagePriority = iota - 1
+	// PriorityNormal used by Say and Whisper
+	PriorityNormal
+	// PriorityModeration used by the moderation commands, like Ban and Timeout
+	PriorityModeration
+	// PriorityCritical sent before anything else
+	PriorityCritical
+)
+
+// lanes in the order they are served
+var priorities = []MessagePriority{PriorityCritical, PriorityModeration, PriorityNormal, PriorityBulk}
+
+// valid reports whether p is one of the lanes
+func (p MessagePriority) valid() bool {
+	for _, priority := range priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// FairnessPolicy how messages of different channels within the same lane are ordered
+type FairnessPolicy int
+
+const (
+	// FairnessRoundRobin takes turns between channels, so one busy channel can not starve the others
+	FairnessRoundRobin FairnessPolicy = iota
+	// FairnessFIFO sends messages in the order they were queued
+	FairnessFIFO
+)
+
+// OutgoingMessage a chat message to send with SayMessage
+type OutgoingMessage struct {
+	Channel string
+	Text    string
+	// MaxAge drops the message if it could not be sent within this duration, zero keeps it until it is sent
+	MaxAge time.Duration
+	// Priority lane to queue the message in, defaults to PriorityNormal
+	Priority MessagePriority
+}
+
+// queuedMessage a line waiting in the outgoing queue, exported fields are written to the spool file
+type queuedMessage struct {
+	// ID identifies the message in the records of the spool file
+	ID       uint64          `json:"id"`
+	Line     string          `json:"line"`
+	Channel  string          `json:"channel"`
+	Priority MessagePriority `json:"priority"`
+	Queued   time.Time       `json:"queued"`
+	Expires  time.Time       `json:"expires,omitempty"`
+	// transient messages are never written to the spool file, like moderation commands that time out anyway
+	transient bool
+	// command is set for chat commands, they are exempt from the spacing of a channel and twitch never answers them with a USERSTATE
+	command bool
+	// retried is set on the second attempt of a message twitch rejected as a duplicate
+	retried bool
+	// held is when the queue first had to hold the message back, zero if it never had to
+	held time.Time
+}
+
+func (m *queuedMessage) expired(now time.Time) bool {
+	return !m.Expires.IsZero() && now.After(m.Expires)
+}
+
+// spoolRecord a line of the spool file, either a queued message or the removal of one
+// the spool file is only appended to, and rewritten with the queued messages once it holds mostly removed ones
+type spoolRecord struct {
+	queuedMessage
+	// Front is set for a message put back in front of its lane
+	Front bool `json:"front,omitempty"`
+	// Removed the ID of a message that was sent or dropped
+	Removed uint64 `json:"removed,omitempty"`
+}
+
+// spoolRemoval the record of a removed message, without the empty fields of a message
+type spoolRemoval struct {
+	Removed uint64 `json:"removed"`
+}
+
+// outgoingQueue holds messages until a connection is ready and the schedule allows sending them
+type outgoingQueue struct {
+	schedule
+	mtx    sync.Mutex
+	spool  string
+	loaded bool
+	wake   chan struct{}
+	// spoolFile is opened for appending on the first record after the spool file was written
+	spoolFile *os.File
+	// records how many records were appended since the spool file was written
+	records int
+	lastID  uint64
+	// expired is called for every message dropped because its MaxAge passed
+	expired func(message *queuedMessage)
+	// spoolFailed is called when the spool file could not be written
+	spoolFailed func(err error)
+}
+
+func newOutgoingQueue() *outgoingQueue {
+	return &outgoingQueue{
+		schedule: newSchedule(),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// push appends a message to its lane and wakes up the sender
+func (q *outgoingQueue) push(message *queuedMessage, maxSize int) error {
+	// a message outside of the lanes would never be served
+	if !message.Priority.valid() {
+		return ErrInvalidPriority
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.dropExpired(time.Now())
+	if maxSize > 0 && q.size() >= maxSize {
+		return ErrQueueFull
+	}
+	q.lanes[message.Priority] = append(q.lanes[message.Priority], message)
+	q.spoolAdd(message, false)
+	q.signal()
+
+	return nil
+}
+
+// pop removes the next message to send, if policy allows sending one now
+// otherwise it returns how long to wait, ok is false if the queue is empty
+func (q *outgoingQueue) pop(policy sendPolicy) (message *queuedMessage, wait time.Duration, ok bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	now := time.Now()
+	q.dropExpired(now)
+	if q.size() == 0 {
+		return nil, 0, false
+	}
+
+	if message, wait = q.next(now, policy); message != nil {
+		q.spoolRemove(message)
+		return message, 0, true
+	}
+
+	for _, lane := range q.lanes {
+		for _, held := range lane {
+			if held.held.IsZero() {
+				held.held = now
+			}
+		}
+	}
+	return nil, wait, true
+}
+
+// requeue puts back a message that could not be written, in front of all others of its lane
+func (q *outgoingQueue) requeue(message *queuedMessage) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.lanes[message.Priority] = append([]*queuedMessage{message}, q.lanes[message.Priority]...)
+	q.spoolAdd(message, true)
+}
+
+// len returns the number of messages waiting to be sent
+func (q *outgoingQueue) len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return q.size()
+}
+
+func (q *outgoingQueue) size() int {
+	size := 0
+	for _, lane := range q.lanes {
+		size += len(lane)
+	}
+	return size
+}
+
+// signal wakes up the sender, without blocking if it is already awake
+func (q *outgoingQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *outgoingQueue) dropExpired(now time.Time) {
+	for priority, lane := range q.lanes {
+		kept := lane[:0]
+		for _, message := range lane {
+			if message.expired(now) {
+				if q.expired != nil {
+					q.expired(message)
+				}
+				q.spoolRemove(message)
+				continue
+			}
+			kept = append(kept, message)
+		}
+		q.lanes[priority] = kept
+	}
+}
+
+// load reads the messages left in the spool file by a previous process, they are sent before any newer message
+func (q *outgoingQueue) load(spool string) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.loaded || spool == "" {
+		return nil
+	}
+
+	spooled, err := readSpool(spool)
+	if err != nil {
+		return err
+	}
+
+	q.spool = spool
+	q.loaded = true
+	for _, priority := range priorities {
+		lane := spooled[priority]
+		for _, message := range lane {
+			if message.ID > q.lastID {
+				q.lastID = message.ID
+			}
+		}
+		q.lanes[priority] = append(lane, q.lanes[priority]...)
+	}
+	q.dropExpired(time.Now())
+
+	return q.writeSpool()
+}
+
+// readSpool replays the records of a spool file, it returns the messages still queued in every lane
+func readSpool(spool string) (map[MessagePriority][]*queuedMessage, error) {
+	spooled := map[MessagePriority][]*queuedMessage{}
+
+	file, err := os.Open(spool)
+	if os.IsNotExist(err) {
+		return spooled, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := spoolRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid spool file %s: %s", spool, err)
+		}
+
+		if record.Removed != 0 {
+			for priority, lane := range spooled {
+				for i, message := range lane {
+					if message.ID == record.Removed {
+						spooled[priority] = append(lane[:i], lane[i+1:]...)
+						break
+					}
+				}
+			}
+			continue
+		}
+
+		message := &record.queuedMessage
+		if !message.Priority.valid() {
+			return nil, fmt.Errorf("invalid spool file %s: %s %d", spool, ErrInvalidPriority, message.Priority)
+		}
+		if record.Front {
+			spooled[message.Priority] = append([]*queuedMessage{message}, spooled[message.Priority]...)
+		} else {
+			spooled[message.Priority] = append(spooled[message.Priority], message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return spooled, nil
+}
+
+// spoolAdd appends message to the spool file, in front of its lane if front is set
+func (q *outgoingQueue) spoolAdd(message *queuedMessage, front bool) {
+	if q.spool == "" || message.transient {
+		return
+	}
+	if message.ID == 0 {
+		q.lastID++
+		message.ID = q.lastID
+	}
+	q.journal(spoolRecord{queuedMessage: *message, Front: front})
+}
+
+// spoolRemove records in the spool file that message was sent or dropped
+func (q *outgoingQueue) spoolRemove(message *queuedMessage) {
+	if q.spool == "" || message.transient {
+		return
+	}
+	q.journal(spoolRemoval{Removed: message.ID})
+}
+
+// journal appends record to the spool file, which is rewritten once it holds more removed messages than queued ones
+func (q *outgoingQueue) journal(record interface{}) {
+	err := q.appendSpool(record)
+	if err == nil && q.records > 2*q.size()+spoolCompactSlack {
+		err = q.writeSpool()
+	}
+	if err != nil && q.spoolFailed != nil {
+		q.spoolFailed(err)
+	}
+}
+
+// appendSpool writes record at the end of the spool file
+func (q *outgoingQueue) appendSpool(record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if q.spoolFile == nil {
+		q.spoolFile, err = os.OpenFile(q.spool, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = q.spoolFile.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	q.records++
+
+	return nil
+}
+
+// writeSpool replaces the spool file with the current queue, through a rename so a crash never leaves half a file
+func (q *outgoingQueue) writeSpool() error {
+	if q.spool == "" {
+		return nil
+	}
+
+	if q.spoolFile != nil {
+		q.spoolFile.Close()
+		q.spoolFile = nil
+	}
+
+	tmp := q.spool + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, priority := range priorities {
+		for _, message := range q.lanes[priority] {
+			if message.transient {
+				continue
+			}
+			if message.ID == 0 {
+				q.lastID++
+				message.ID = q.lastID
+			}
+			if err = encoder.Encode(spoolRecord{queuedMessage: *message}); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	q.records = 0
+	return os.Rename(tmp, q.spool)
+}
+
+// queue adds a message to the outgoing queue, maxAge of zero keeps it until it is sent
+func (c *Client) queue(message *queuedMessage, maxAge time.Duration) error {
+	message.Queued = time.Now()
+	if maxAge > 0 {
+		message.Expires = message.Queued.Add(maxAge)
+	}
+
+	err := c.outgoing.push(message, c.MaxQueueSize)
+	if err != nil {
+		c.log(LevelWarn, "dropped message", "channel", message.Channel, "error", err)
+		return err
+	}
+	c.metrics().QueueDepth(c.outgoing.len())
+	return nil
+}
+
+// flushQueue writes queued messages to conn whenever the client is connected and the rate limit allows it, until done is closed
+func (c *Client) flushQueue(conn net.Conn, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.outgoing.wake:
+		}
+
+		if !c.connected() {
+			continue
+		}
+
+		for {
+			message, wait, ok := c.outgoing.pop(c.sendPolicy())
+			if !ok {
+				break
+			}
+			if message == nil {
+				// woken up early by a new message, which might be for a channel that does not have to wait
+				select {
+				case <-done:
+					return
+				case <-c.outgoing.wake:
+				case <-time.After(wait):
+				}
+				continue
+			}
+			if !message.held.IsZero() {
+				c.metrics().RateLimitWait(time.Since(message.held))
+			}
+
+			line := message.Line
+			// whispers and moderation commands are no chat messages twitch answers with a USERSTATE
+			if c.BypassDuplicates && !message.command && message.Channel != "jtv" {
+				line = c.duplicates.bypass(message, time.Now())
+			}
+
+			if _, err := conn.Write([]byte(line + "\r\n")); err != nil {
+				// keep the message for the next connection, the read loop notices the broken connection
+				c.log(LevelWarn, "requeued message after failed write", "channel", message.Channel, "error", err)
+				c.outgoing.requeue(message)
+				return
+			}
+			c.metrics().MessageSent(message.Channel)
+			c.metrics().QueueDepth(c.outgoing.len())
+		}
+	}
+}
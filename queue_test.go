@@ -0,0 +1,312 @@
+package twitch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+func newQueueTestClient(host string) *Client {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.TLS = false
+	client.IrcAddress = host
+	client.SendPings = false
+	// users without badges wait a second between messages to a channel
+	client.RespectSlowMode = false
+
+	return client
+}
+
+func TestSayIsQueuedUntilChannelsAreJoined(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newQueueTestClient(server.Addr)
+	client.Join("gempir")
+	client.Say("gempir", "queued before connect")
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 1, "JOIN #gempir")
+	waitFor(t, server, 1, "PRIVMSG")
+
+	assertStringSlicesEqual(t, []string{"PRIVMSG #gempir :queued before connect"}, privmsgs(server.Received()))
+}
+
+func TestQueueDropsExpiredMessages(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newQueueTestClient(server.Addr)
+	client.SayMessage(OutgoingMessage{Channel: "gempir", Text: "too old", MaxAge: time.Millisecond * 10})
+	client.SayMessage(OutgoingMessage{Channel: "gempir", Text: "still fresh", MaxAge: time.Minute})
+	time.Sleep(time.Millisecond * 30)
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 1, "PRIVMSG")
+
+	assertStringSlicesEqual(t, []string{"PRIVMSG #gempir :still fresh"}, privmsgs(server.Received()))
+}
+
+func TestQueueSurvivesReconnect(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newQueueTestClient(server.Addr)
+	client.OnStateChange(func(from, to ConnectionState, err error) {
+		if to == StateReconnecting {
+			client.Say("gempir", "sent while reconnecting")
+		}
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 1, "CAP REQ")
+	for client.State() != StateConnected {
+		time.Sleep(time.Millisecond * 2)
+	}
+
+	dropped := server.Conns()[0]
+	dropped.Close()
+
+	waitFor(t, server, 1, "PRIVMSG")
+
+	assertIntsEqual(t, 0, len(privmsgs(dropped.Received())))
+	assertStringSlicesEqual(t, []string{"PRIVMSG #gempir :sent while reconnecting"}, privmsgs(server.Received()))
+}
+
+func TestQueueRejectsMessagesWhenFull(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.MaxQueueSize = 2
+
+	assertTrue(t, client.Say("gempir", "one") == nil, "first message was rejected")
+	assertTrue(t, client.Say("gempir", "two") == nil, "second message was rejected")
+	if err := client.Say("gempir", "three"); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestQueueRejectsInvalidPriority(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+
+	if err := client.SayMessage(OutgoingMessage{Channel: "gempir", Text: "hello", Priority: MessagePriority(5)}); err != ErrInvalidPriority {
+		t.Fatalf("expected ErrInvalidPriority, got %v", err)
+	}
+	assertIntsEqual(t, 0, client.outgoing.len())
+
+	dir, err := ioutil.TempDir("", "twitch-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "outgoing.spool")
+
+	line := `{"line":"PRIVMSG #gempir :hello","channel":"gempir","priority":5,"queued":"2018-05-20T12:00:00Z"}` + "\n"
+	if err := ioutil.WriteFile(spool, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.outgoing.load(spool); err == nil {
+		t.Fatal("expected an error for a spooled message with an invalid priority")
+	}
+	assertIntsEqual(t, 0, client.outgoing.len())
+}
+
+func TestSpoolFileSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twitch-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "outgoing.spool")
+
+	// the first process never manages to connect
+	offline := newQueueTestClient("127.0.0.1:1")
+	offline.SpoolFile = spool
+	if err := offline.Connect(); err == nil {
+		t.Fatal("expected Connect() to fail")
+	}
+	offline.Say("gempir", "written before the restart")
+	offline.moderationTimeout = time.Millisecond * 10
+	offline.Ban("gempir", "baduser", "")
+
+	server := twitchtest.NewServer()
+	defer server.Close()
+	client := newQueueTestClient(server.Addr)
+	client.SpoolFile = spool
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 1, "PRIVMSG")
+	assertStringSlicesEqual(t, []string{"PRIVMSG #gempir :written before the restart"}, privmsgs(server.Received()))
+
+	for client.outgoing.len() != 0 {
+		time.Sleep(time.Millisecond * 2)
+	}
+	client.outgoing.mtx.Lock()
+	defer client.outgoing.mtx.Unlock()
+	spooled, err := readSpool(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIntsEqual(t, 0, len(spooled[PriorityNormal]))
+}
+
+func TestSpoolFileIsAppendedAndCompacted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twitch-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "outgoing.spool")
+
+	queue := newOutgoingQueue()
+	if err := queue.load(spool); err != nil {
+		t.Fatal(err)
+	}
+	queue.push(&queuedMessage{Line: "kept", Channel: "gempir"}, 0)
+	for i := 0; i < 500; i++ {
+		queue.push(&queuedMessage{Line: "sent", Channel: "pajlada", Priority: PriorityCritical}, 0)
+		queue.pop(sendPolicy{})
+	}
+	queue.push(&queuedMessage{Line: "requeued", Channel: "gempir", Priority: PriorityCritical}, 0)
+	message, _, _ := queue.pop(sendPolicy{})
+	queue.requeue(message)
+
+	content, err := ioutil.ReadFile(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Count(string(content), "\n")
+	assertTrue(t, lines < 2*spoolCompactSlack, "spool file was not compacted, it has "+strconv.Itoa(lines)+" lines")
+
+	restarted := newOutgoingQueue()
+	if err := restarted.load(spool); err != nil {
+		t.Fatal(err)
+	}
+	assertStringSlicesEqual(t, []string{"requeued", "kept"}, popLines(t, restarted, FairnessFIFO))
+}
+
+func TestSpoolFileErrorsAreLogged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twitch-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var failures []error
+	queue := newOutgoingQueue()
+	queue.spoolFailed = func(err error) {
+		failures = append(failures, err)
+	}
+	if err := queue.load(filepath.Join(dir, "missing", "outgoing.spool")); err == nil {
+		t.Fatal("expected an error for a spool file in a missing directory")
+	}
+
+	queue = newOutgoingQueue()
+	queue.spoolFailed = func(err error) {
+		failures = append(failures, err)
+	}
+	if err := queue.load(filepath.Join(dir, "outgoing.spool")); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dir)
+
+	queue.push(&queuedMessage{Line: "hello", Channel: "gempir"}, 0)
+	assertIntsEqual(t, 1, len(failures))
+	assertIntsEqual(t, 1, queue.len())
+}
+
+func popLines(t *testing.T, queue *outgoingQueue, fairness FairnessPolicy) []string {
+	var lines []string
+	for {
+		message, wait, ok := queue.pop(sendPolicy{fairness: fairness})
+		if !ok {
+			return lines
+		}
+		if wait > 0 {
+			t.Fatal("disabled rate limit made pop wait")
+		}
+		lines = append(lines, message.Line)
+	}
+}
+
+func TestQueueServesLanesInPriorityOrder(t *testing.T) {
+	queue := newOutgoingQueue()
+	queue.push(&queuedMessage{Line: "bulk", Channel: "gempir", Priority: PriorityBulk}, 0)
+	queue.push(&queuedMessage{Line: "normal", Channel: "gempir", Priority: PriorityNormal}, 0)
+	queue.push(&queuedMessage{Line: "moderation", Channel: "gempir", Priority: PriorityModeration}, 0)
+	queue.push(&queuedMessage{Line: "critical", Channel: "gempir", Priority: PriorityCritical}, 0)
+
+	assertStringSlicesEqual(t, []string{"critical", "moderation", "normal", "bulk"}, popLines(t, queue, FairnessRoundRobin))
+}
+
+func TestQueueTakesTurnsBetweenChannels(t *testing.T) {
+	for fairness, expected := range map[FairnessPolicy][]string{
+		FairnessRoundRobin: {"busy 1", "quiet 1", "other 1", "busy 2", "quiet 2", "busy 3"},
+		FairnessFIFO:       {"busy 1", "busy 2", "busy 3", "quiet 1", "quiet 2", "other 1"},
+	} {
+		queue := newOutgoingQueue()
+		for _, message := range []string{"busy 1", "busy 2", "busy 3", "quiet 1", "quiet 2", "other 1"} {
+			queue.push(&queuedMessage{Line: message, Channel: message[:len(message)-2]}, 0)
+		}
+
+		assertStringSlicesEqual(t, expected, popLines(t, queue, fairness))
+	}
+}
+
+func TestQueueWaitsForRateLimit(t *testing.T) {
+	limit := RateLimit{Messages: 2, Period: time.Minute}
+
+	queue := newOutgoingQueue()
+	for i := 0; i < 3; i++ {
+		queue.push(&queuedMessage{Line: "hello", Channel: "gempir"}, 0)
+	}
+
+	for i := 0; i < 2; i++ {
+		if message, _, _ := queue.pop(sendPolicy{limit: limit}); message == nil {
+			t.Fatal("message within the rate limit was held back")
+		}
+	}
+
+	message, wait, ok := queue.pop(sendPolicy{limit: limit})
+	assertTrue(t, ok && message == nil, "message over the rate limit was sent")
+	assertTrue(t, wait > time.Second*59 && wait <= time.Minute, "unexpected wait for the rate limit: "+wait.String())
+	assertIntsEqual(t, 1, queue.len())
+}
+
+func TestModerationSkipsQueuedChatMessages(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newQueueTestClient(server.Addr)
+	for i := 0; i < 3; i++ {
+		client.Say("gempir", "reply")
+	}
+	go client.Ban("gempir", "baduser", "")
+	for client.outgoing.len() != 4 {
+		time.Sleep(time.Millisecond * 2)
+	}
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, 4, "PRIVMSG")
+
+	assertStringSlicesEqual(t, []string{
+		"PRIVMSG #gempir :/ban baduser",
+		"PRIVMSG #gempir :reply",
+		"PRIVMSG #gempir :reply",
+		"PRIVMSG #gempir :reply",
+	}, privmsgs(server.Received()))
+}
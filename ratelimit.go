@@ -0,0 +1,54 @@
+package twitch
+
+import (
+	"time"
+)
+
+// RateLimit how many messages may be sent within Period, a zero Messages disables rate limiting
+type RateLimit struct {
+	Messages int
+	Period   time.Duration
+}
+
+var (
+	// DefaultRateLimit what twitch allows regular users to send
+	DefaultRateLimit = RateLimit{Messages: 20, Period: time.Second * 30}
+
+	// ModeratorRateLimit what twitch allows in channels the user is moderator or broadcaster of
+	ModeratorRateLimit = RateLimit{Messages: 100, Period: time.Second * 30}
+
+	// VerifiedBotRateLimit what twitch allows verified bots to send
+	VerifiedBotRateLimit = RateLimit{Messages: 7500, Period: time.Second * 30}
+)
+
+// rateLimiter a sliding window over the times of the last sent messages
+type rateLimiter struct {
+	sent []time.Time
+}
+
+// wait returns how long to wait before another message fits into limit, zero if it can be sent right away
+func (r *rateLimiter) wait(limit RateLimit, now time.Time) time.Duration {
+	if limit.Messages <= 0 {
+		return 0
+	}
+
+	windowStart := now.Add(-limit.Period)
+	expired := 0
+	for expired < len(r.sent) && !r.sent[expired].After(windowStart) {
+		expired++
+	}
+	r.sent = r.sent[expired:]
+
+	if len(r.sent) < limit.Messages {
+		return 0
+	}
+	return r.sent[len(r.sent)-limit.Messages].Sub(windowStart)
+}
+
+// record counts a sent message against the limit
+func (r *rateLimiter) record(limit RateLimit, now time.Time) {
+	if limit.Messages <= 0 {
+		return
+	}
+	r.sent = append(r.sent, now)
+}
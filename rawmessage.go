@@ -0,0 +1,140 @@
+package twitch
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RawMessage a received line that only decodes what is accessed
+// The type, channel, username and text are read straight from the line,
+// tags, badges and emotes are decoded on first access and cached.
+// A RawMessage can be kept after the callback returns, but is not safe for concurrent use
+type RawMessage struct {
+	line string
+	irc  ircLine
+	// irc is only set for lines in IRC format, other lines are their own text
+	isIRC bool
+
+	tags   map[string]string
+	badges map[string]int
+	emotes []*Emote
+}
+
+// ParseRawMessage wraps line in a RawMessage, the line is not decoded until a method is called
+func ParseRawMessage(line string) *RawMessage {
+	raw := &RawMessage{line: line}
+	if strings.HasPrefix(line, "@") || strings.HasPrefix(line, ":") {
+		raw.irc = parseIRCLine(line)
+		raw.isIRC = true
+	}
+	return raw
+}
+
+// Raw returns the line as it was received
+func (m *RawMessage) Raw() string {
+	return m.line
+}
+
+// Type returns the type of the message, UNSET for messages without a known type
+func (m *RawMessage) Type() MessageType {
+	if !m.isIRC {
+		return UNSET
+	}
+	return commandType(m.irc.command)
+}
+
+// Command returns the IRC command, like PRIVMSG or 001
+func (m *RawMessage) Command() string {
+	return m.irc.command
+}
+
+// Channel returns the channel the message was sent to, without the #
+func (m *RawMessage) Channel() string {
+	return paramsChannel(m.irc.params)
+}
+
+// Username returns the login of the user who sent the message
+// For USERNOTICE, like subs, it is the user who initiated the event, taken from the login tag
+func (m *RawMessage) Username() string {
+	if username := prefixUsername(m.irc.prefix); username != "" {
+		return username
+	}
+	login, _ := m.Tag("login")
+	return login
+}
+
+// Text returns the text of the message, without the framing of /me actions
+func (m *RawMessage) Text() string {
+	if !m.isIRC {
+		return m.line
+	}
+	if m.Action() {
+		return strings.TrimSuffix(m.irc.trailing[len("\u0001ACTION "):], "\u0001")
+	}
+	return m.irc.trailing
+}
+
+// Action returns true for /me messages
+func (m *RawMessage) Action() bool {
+	return strings.HasPrefix(m.irc.trailing, "\u0001ACTION ")
+}
+
+// Tag returns the value of a single tag, without decoding the others unless Tags was called before
+func (m *RawMessage) Tag(key string) (string, bool) {
+	if m.tags != nil {
+		value, ok := m.tags[key]
+		return value, ok
+	}
+	return m.irc.tag(key)
+}
+
+// Tags returns all tags of the message, decoded on the first call
+func (m *RawMessage) Tags() map[string]string {
+	if m.tags != nil {
+		return m.tags
+	}
+
+	m.tags = make(map[string]string, strings.Count(m.irc.tags, ";")+1)
+	tags := m.irc.tags
+	for tags != "" {
+		var key, value string
+		var ok bool
+		key, value, tags, ok = nextTag(tags)
+		if ok {
+			m.tags[key] = unescapeTagValue(value)
+		}
+	}
+	return m.tags
+}
+
+// Badges returns the badges of the user and their version, decoded on the first call
+func (m *RawMessage) Badges() map[string]int {
+	if m.badges == nil {
+		badges, _ := m.Tag("badges")
+		m.badges = parseBadges(badges)
+	}
+	return m.badges
+}
+
+// Emotes returns the emotes used in the text, decoded on the first call
+func (m *RawMessage) Emotes() []*Emote {
+	if m.emotes == nil {
+		emotes, _ := m.Tag("emotes")
+		m.emotes = parseTwitchEmotes(emotes, m.Text())
+	}
+	return m.emotes
+}
+
+// Time returns when twitch received the message, the zero time if the message does not carry it
+func (m *RawMessage) Time() time.Time {
+	sent, ok := m.Tag("tmi-sent-ts")
+	if !ok {
+		return time.Time{}
+	}
+	i, err := strconv.ParseInt(sent, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, i*1e6)
+}
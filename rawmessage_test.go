@@ -0,0 +1,112 @@
+package twitch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanReadRawMessageWithoutDecodingTags(t *testing.T) {
+	testMessage := "@badges=subscriber/6,premium/1;color=#FF0000;display-name=Redflamingo13;emotes=;id=2a31a9df-d6ff-4840-b211-a2547c7e656e;mod=0;room-id=11148817;subscriber=1;tmi-sent-ts=1490382457309;turbo=0;user-id=78424343;user-type= :redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :Thrashh5, FeelsWayTooAmazingMan kinda"
+	message := ParseRawMessage(testMessage)
+
+	if message.Type() != PRIVMSG {
+		t.Error("parsing message type failed")
+	}
+	assertStringsEqual(t, "PRIVMSG", message.Command())
+	assertStringsEqual(t, "pajlada", message.Channel())
+	assertStringsEqual(t, "redflamingo13", message.Username())
+	assertStringsEqual(t, "Thrashh5, FeelsWayTooAmazingMan kinda", message.Text())
+	assertStringsEqual(t, testMessage, message.Raw())
+
+	userID, ok := message.Tag("user-id")
+	assertTrue(t, ok, "user-id tag not found")
+	assertStringsEqual(t, "78424343", userID)
+	assertTrue(t, message.tags == nil, "looking up a single tag decoded all tags")
+	assertTrue(t, message.Time().Equal(time.Unix(0, 1490382457309*1e6)), "parsing time failed")
+}
+
+func TestCanDecodeRawMessageTagsOnce(t *testing.T) {
+	testMessage := `@badges=moderator/1,subscriber/12;display-name=gempir;emotes=25:0-4;system-msg=hello\sworld :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :Kappa Keepo`
+	message := ParseRawMessage(testMessage)
+
+	tags := message.Tags()
+	assertStringsEqual(t, "hello world", tags["system-msg"])
+	tags["display-name"] = "changed"
+
+	displayName, _ := message.Tag("display-name")
+	assertStringsEqual(t, "changed", displayName)
+
+	assertIntsEqual(t, 12, message.Badges()["subscriber"])
+	assertIntsEqual(t, 1, message.Badges()["moderator"])
+
+	emotes := message.Emotes()
+	assertIntsEqual(t, 1, len(emotes))
+	assertStringsEqual(t, "Kappa", emotes[0].Name)
+	assertTrue(t, &message.Emotes()[0] == &emotes[0], "emotes were decoded twice")
+}
+
+func TestCanReadRawActionMessage(t *testing.T) {
+	message := ParseRawMessage("@emotes=25:0-4 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :\u0001ACTION Kappa hi\u0001")
+
+	assertTrue(t, message.Action(), "parsing action failed")
+	assertStringsEqual(t, "Kappa hi", message.Text())
+	assertStringsEqual(t, "Kappa", message.Emotes()[0].Name)
+}
+
+func TestRawMessageUsernameFallsBackToLogin(t *testing.T) {
+	message := ParseRawMessage("@login=gifter;msg-id=subgift :tmi.twitch.tv USERNOTICE #gempir")
+
+	assertStringsEqual(t, "gifter", message.Username())
+	assertStringsEqual(t, "", message.Text())
+}
+
+func TestRawMessageOfUnknownLineIsUnset(t *testing.T) {
+	message := ParseRawMessage("not an irc line")
+
+	if message.Type() != UNSET {
+		t.Error("parsing message type failed")
+	}
+	assertStringsEqual(t, "not an irc line", message.Text())
+	assertStringsEqual(t, "", message.Channel())
+	assertIntsEqual(t, 0, len(message.Tags()))
+	assertTrue(t, message.Time().IsZero(), "time of a line without tags is not zero")
+}
+
+func TestCanReceiveRawMessage(t *testing.T) {
+	testMessage := "@badges=subscriber/6;color=#FF0000;display-name=Redflamingo13;emotes=;mod=0;room-id=11148817;user-id=78424343 :redflamingo13!redflamingo13@redflamingo13.tmi.twitch.tv PRIVMSG #pajlada :Thrashh5, FeelsWayTooAmazingMan kinda"
+
+	wait := make(chan struct{})
+	var received, username string
+
+	host := startServer(t, postMessageOnConnect(testMessage), nothingOnMessage)
+	client := newTestClient(host)
+
+	client.OnNewRawMessage(func(message *RawMessage) {
+		if message.Type() != PRIVMSG {
+			return
+		}
+		received = message.Text()
+		username = message.Username()
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message sent")
+	}
+
+	assertStringsEqual(t, "Thrashh5, FeelsWayTooAmazingMan kinda", received)
+	assertStringsEqual(t, "redflamingo13", username)
+}
+
+func BenchmarkRawMessageTextAndUsername(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		message := ParseRawMessage(benchmarkPRIVMSG)
+		message.Text()
+		message.Username()
+	}
+}
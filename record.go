@@ -0,0 +1,215 @@
+package twitch
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction whether a recorded line was sent or received by the client
+type Direction byte
+
+const (
+	// DirectionReceived a line the server sent to the client
+	DirectionReceived Direction = '<'
+	// DirectionSent a line the client sent to the server
+	DirectionSent Direction = '>'
+)
+
+var (
+	// ErrInvalidRecording returned when reading a recording that is not in the format written by Recorder
+	ErrInvalidRecording = errors.New("invalid recording")
+
+	// ErrReplayFinished returned from ReplayTransport.Dial once the recording was replayed, which ends Connect()
+	ErrReplayFinished = errors.New("replay finished")
+)
+
+// RecordedLine one line of a recorded session
+type RecordedLine struct {
+	Time      time.Time
+	Direction Direction
+	Line      string
+}
+
+// String formats the line the way Recorder writes it: the time, the direction and the raw IRC line, separated by spaces
+func (r RecordedLine) String() string {
+	return fmt.Sprintf("%s %c %s", r.Time.UTC().Format(time.RFC3339Nano), r.Direction, r.Line)
+}
+
+// ParseRecordedLine parses a line written by Recorder
+func ParseRecordedLine(line string) (RecordedLine, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || len(parts[1]) != 1 {
+		return RecordedLine{}, ErrInvalidRecording
+	}
+
+	recordedAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return RecordedLine{}, ErrInvalidRecording
+	}
+
+	direction := Direction(parts[1][0])
+	if direction != DirectionReceived && direction != DirectionSent {
+		return RecordedLine{}, ErrInvalidRecording
+	}
+
+	return RecordedLine{Time: recordedAt, Direction: direction, Line: parts[2]}, nil
+}
+
+// Recorder writes every line a client sends and receives to a writer, set it as Client.Recorder
+// The oauth token is never written, PASS lines are recorded as "PASS ***"
+type Recorder struct {
+	mtx sync.Mutex
+	w   *bufio.Writer
+	err error
+}
+
+// NewRecorder creates a Recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: bufio.NewWriter(w)}
+}
+
+// Record writes line, received or sent right now
+func (r *Recorder) Record(direction Direction, line string) {
+	if direction == DirectionSent {
+		line = redactLine(line)
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	if _, r.err = r.w.WriteString(RecordedLine{Time: time.Now(), Direction: direction, Line: line}.String() + "\n"); r.err != nil {
+		return
+	}
+	r.err = r.w.Flush()
+}
+
+// Err returns the first error writing the recording, recording stops after it
+func (r *Recorder) Err() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.err
+}
+
+// recordingConn records every line written to the wrapped connection
+type recordingConn struct {
+	net.Conn
+	recorder *Recorder
+}
+
+func (r *recordingConn) Write(p []byte) (int, error) {
+	n, err := r.Conn.Write(p)
+	if n > 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(string(p[:n]), "\r\n"), "\r\n") {
+			r.recorder.Record(DirectionSent, line)
+		}
+	}
+	return n, err
+}
+
+// RecordingReader reads the lines of a recording one after another
+type RecordingReader struct {
+	scanner *bufio.Scanner
+}
+
+// NewRecordingReader creates a RecordingReader reading from r
+func NewRecordingReader(r io.Reader) *RecordingReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+
+	return &RecordingReader{scanner: scanner}
+}
+
+// Next returns the next line of the recording, io.EOF once all lines were read
+func (r *RecordingReader) Next() (RecordedLine, error) {
+	for r.scanner.Scan() {
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+		return ParseRecordedLine(r.scanner.Text())
+	}
+	if err := r.scanner.Err(); err != nil {
+		return RecordedLine{}, err
+	}
+	return RecordedLine{}, io.EOF
+}
+
+// ReplayTransport feeds the received lines of a recording into a Client, set it as Client.Transport
+// PINGs the client sends are answered, other lines are discarded
+// The connection closes after the last line and the next Dial returns ErrReplayFinished
+type ReplayTransport struct {
+	Recording io.Reader
+	// Speed replays the recording this many times faster than it was recorded, zero replays without any delay
+	Speed float64
+
+	mtx      sync.Mutex
+	replayed bool
+}
+
+// Dial returns a connection replaying the recording, address is ignored
+func (t *ReplayTransport) Dial(address string) (net.Conn, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.replayed {
+		return nil, ErrReplayFinished
+	}
+	t.replayed = true
+
+	client, server := net.Pipe()
+	go answerPings(server)
+	go t.replay(server)
+
+	return client, nil
+}
+
+// answerPings answers the keepalive PINGs of the client like twitch would, so replays longer than PingInterval don't time out
+func answerPings(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "PING ") {
+			continue
+		}
+		if _, err := conn.Write([]byte(":tmi.twitch.tv PONG tmi.twitch.tv " + strings.TrimPrefix(line, "PING ") + "\r\n")); err != nil {
+			return
+		}
+	}
+	// keep discarding in case a line was too long for the scanner, so writes of the client don't block
+	io.Copy(ioutil.Discard, conn)
+}
+
+func (t *ReplayTransport) replay(conn net.Conn) {
+	defer conn.Close()
+
+	reader := NewRecordingReader(t.Recording)
+	var previous time.Time
+	for {
+		line, err := reader.Next()
+		if err != nil {
+			return
+		}
+		if line.Direction != DirectionReceived {
+			continue
+		}
+
+		if t.Speed > 0 && !previous.IsZero() {
+			time.Sleep(time.Duration(float64(line.Time.Sub(previous)) / t.Speed))
+		}
+		previous = line.Time
+
+		if _, err = conn.Write([]byte(line.Line + "\r\n")); err != nil {
+			return
+		}
+	}
+}
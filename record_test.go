@@ -0,0 +1,167 @@
+package twitch
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+// syncBuffer a bytes.Buffer safe to read while the client still writes to it
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+const testRecording = `2018-05-20T12:00:00Z > PASS ***
+2018-05-20T12:00:00Z > NICK justinfan123123
+2018-05-20T12:00:00.1Z < :tmi.twitch.tv 001 justinfan123123 :Welcome, GLHF!
+2018-05-20T12:00:00.1Z > CAP REQ :twitch.tv/tags
+2018-05-20T12:00:00.2Z < :tmi.twitch.tv CAP * ACK :twitch.tv/tags
+2018-05-20T12:00:00.2Z > JOIN #gempir
+2018-05-20T12:00:01Z < @id=1;display-name=gempir :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :first
+2018-05-20T12:00:02Z < @id=2;display-name=pajlada :pajlada!pajlada@pajlada.tmi.twitch.tv PRIVMSG #gempir :second
+`
+
+func TestCanRecordSession(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	recording := &syncBuffer{}
+	received := make(chan struct{})
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.Recorder = NewRecorder(recording)
+	client.Join("gempir")
+	client.OnNewMessage(func(channel string, user User, message Message) {
+		close(received)
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	if _, err := server.WaitFor("JOIN #gempir", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	server.Send("@id=1 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :recorded")
+
+	select {
+	case <-received:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message received")
+	}
+
+	var lines []string
+	reader := NewRecordingReader(strings.NewReader(recording.String()))
+	for {
+		line, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTrue(t, time.Since(line.Time) < time.Minute, "unexpected time "+line.Time.String())
+		lines = append(lines, string(line.Direction)+" "+line.Line)
+	}
+
+	assertStringsEqual(t, "> PASS ***", lines[0])
+	assertStringsEqual(t, "> NICK justinfan123123", lines[1])
+
+	all := strings.Join(lines, "\n")
+	assertTrue(t, strings.Contains(all, "< :tmi.twitch.tv 001 justinfan123123 :Welcome, GLHF!"), "welcome was not recorded")
+	assertTrue(t, strings.Contains(all, "> JOIN #gempir"), "JOIN was not recorded")
+	assertTrue(t, strings.Contains(all, "< @id=1 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :recorded"), "message was not recorded")
+	assertTrue(t, client.Recorder.Err() == nil, "recording failed")
+}
+
+func TestCanReplaySession(t *testing.T) {
+	var received []string
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.Capabilities = []string{TagsCapability}
+	client.Transport = &ReplayTransport{Recording: strings.NewReader(testRecording)}
+	client.OnNewMessage(func(channel string, user User, message Message) {
+		received = append(received, user.Username+": "+message.Text)
+	})
+
+	if err := client.Connect(); err != ErrReplayFinished {
+		t.Fatalf("expected ErrReplayFinished, got %v", err)
+	}
+
+	assertStringSlicesEqual(t, []string{"gempir: first", "pajlada: second"}, received)
+}
+
+func TestReplayKeepsTiming(t *testing.T) {
+	var times []time.Time
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.Capabilities = []string{TagsCapability}
+	client.Transport = &ReplayTransport{Recording: strings.NewReader(testRecording), Speed: 10}
+	client.OnNewMessage(func(channel string, user User, message Message) {
+		times = append(times, time.Now())
+	})
+
+	client.Connect()
+
+	assertIntsEqual(t, 2, len(times))
+	// the messages were recorded a second apart
+	gap := times[1].Sub(times[0])
+	assertTrue(t, gap > time.Millisecond*80 && gap < time.Millisecond*500, "unexpected gap "+gap.String())
+}
+
+func TestReplayAnswersPings(t *testing.T) {
+	var received []string
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.Capabilities = []string{TagsCapability}
+	client.PingInterval = time.Millisecond * 20
+	client.PongTimeout = time.Millisecond * 50
+	// the messages are 100ms apart, several PINGs are sent in between
+	client.Transport = &ReplayTransport{Recording: strings.NewReader(testRecording), Speed: 10}
+	client.OnNewMessage(func(channel string, user User, message Message) {
+		received = append(received, user.Username+": "+message.Text)
+	})
+
+	if err := client.Connect(); err != ErrReplayFinished {
+		t.Fatalf("expected ErrReplayFinished, got %v", err)
+	}
+
+	assertStringSlicesEqual(t, []string{"gempir: first", "pajlada: second"}, received)
+	assertTrue(t, client.Latency() > 0, "no PONG was received")
+}
+
+func TestCanParseRecordedLine(t *testing.T) {
+	line, err := ParseRecordedLine("2018-05-20T12:00:00.5Z < :tmi.twitch.tv PONG tmi.twitch.tv :nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTrue(t, line.Direction == DirectionReceived, "wrong direction")
+	assertStringsEqual(t, ":tmi.twitch.tv PONG tmi.twitch.tv :nonce", line.Line)
+	assertStringsEqual(t, "2018-05-20T12:00:00.5Z < :tmi.twitch.tv PONG tmi.twitch.tv :nonce", line.String())
+
+	for _, invalid := range []string{"not a recording", "2018-05-20T12:00:00Z ? PING", "yesterday > PING"} {
+		if _, err := ParseRecordedLine(invalid); err != ErrInvalidRecording {
+			t.Errorf("expected ErrInvalidRecording for %q, got %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package twitch
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// channelSpacing how long users without moderator or VIP status have to wait between two messages to the same channel
+const channelSpacing = time.Second
+
+// sendPolicy the limits the outgoing queue is served within
+type sendPolicy struct {
+	limit    RateLimit
+	fairness FairnessPolicy
+	// interval returns the time to leave between two messages to channel
+	interval func(channel string) time.Duration
+}
+
+// schedule decides which queued message is sent next and when
+type schedule struct {
+	lanes map[MessagePriority][]*queuedMessage
+	// turn at which a channel was last served, for FairnessRoundRobin
+	lastServed map[string]uint64
+	turn       uint64
+	// when the last message was sent to a channel, for the channel interval
+	lastSent map[string]time.Time
+	limiter  rateLimiter
+}
+
+func newSchedule() schedule {
+	return schedule{
+		lanes:      map[MessagePriority][]*queuedMessage{},
+		lastServed: map[string]uint64{},
+		lastSent:   map[string]time.Time{},
+	}
+}
+
+// next removes the message to send at now, otherwise it returns how long to wait for one
+func (s *schedule) next(now time.Time, policy sendPolicy) (*queuedMessage, time.Duration) {
+	wait := s.limiter.wait(policy.limit, now)
+	if wait > 0 {
+		return nil, wait
+	}
+
+	for _, priority := range priorities {
+		lane := s.lanes[priority]
+
+		i, laneWait := s.nextInLane(lane, now, policy)
+		if i < 0 {
+			if laneWait > 0 && (wait == 0 || laneWait < wait) {
+				wait = laneWait
+			}
+			continue
+		}
+
+		message := lane[i]
+		s.lanes[priority] = append(lane[:i:i], lane[i+1:]...)
+
+		s.turn++
+		s.lastServed[message.Channel] = s.turn
+		s.lastSent[message.Channel] = now
+		s.limiter.record(policy.limit, now)
+
+		return message, 0
+	}
+
+	return nil, wait
+}
+
+// nextInLane returns the index of the next message of lane that can be sent at now, or -1 and how long the first one has to wait
+func (s *schedule) nextInLane(lane []*queuedMessage, now time.Time, policy sendPolicy) (int, time.Duration) {
+	next := -1
+	var wait time.Duration
+	seen := map[string]bool{}
+	for i, message := range lane {
+		if seen[message.Channel] {
+			continue
+		}
+		seen[message.Channel] = true
+
+		if channelWait := s.channelWait(message, now, policy); channelWait > 0 {
+			if wait == 0 || channelWait < wait {
+				wait = channelWait
+			}
+			continue
+		}
+
+		if next < 0 {
+			next = i
+			if policy.fairness != FairnessRoundRobin {
+				break
+			}
+		} else if s.lastServed[message.Channel] < s.lastServed[lane[next].Channel] {
+			next = i
+		}
+	}
+
+	return next, wait
+}
+
+// channelWait returns how long message has to wait for the interval of its channel
+func (s *schedule) channelWait(message *queuedMessage, now time.Time, policy sendPolicy) time.Duration {
+	// moderation commands are only sent by moderators, who are exempt from both slow mode and the spacing
+	if message.command || policy.interval == nil {
+		return 0
+	}
+
+	last, ok := s.lastSent[message.Channel]
+	if !ok {
+		return 0
+	}
+	if wait := last.Add(policy.interval(message.Channel)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// clone returns a copy of s that can be advanced without changing s
+func (s *schedule) clone() schedule {
+	clone := newSchedule()
+	for priority, lane := range s.lanes {
+		clone.lanes[priority] = append([]*queuedMessage{}, lane...)
+	}
+	for channel, turn := range s.lastServed {
+		clone.lastServed[channel] = turn
+	}
+	for channel, sent := range s.lastSent {
+		clone.lastSent[channel] = sent
+	}
+	clone.turn = s.turn
+	clone.limiter.sent = append([]time.Time{}, s.limiter.sent...)
+
+	return clone
+}
+
+// estimate returns the queued messages in the order they are expected to be sent, starting at now
+func (s *schedule) estimate(now time.Time, policy sendPolicy) []PendingMessage {
+	simulation := s.clone()
+	var pending []PendingMessage
+
+	for {
+		message, wait := simulation.next(now, policy)
+		if message != nil {
+			pending = append(pending, PendingMessage{
+				Channel:           message.Channel,
+				Line:              message.Line,
+				Priority:          message.Priority,
+				Queued:            message.Queued,
+				EstimatedDelivery: now,
+			})
+			continue
+		}
+		if wait <= 0 {
+			return pending
+		}
+		now = now.Add(wait)
+	}
+}
+
+// PendingMessage a message waiting in the outgoing queue
+type PendingMessage struct {
+	Channel  string
+	Line     string
+	Priority MessagePriority
+	Queued   time.Time
+	// EstimatedDelivery when the message is expected to be sent, considering the rate limit and slow mode
+	// assuming the client stays connected and no message of a higher priority is queued in the meantime
+	EstimatedDelivery time.Time
+}
+
+// PendingMessages returns the messages waiting in the outgoing queue, in the order they are expected to be sent
+func (c *Client) PendingMessages() []PendingMessage {
+	c.outgoing.mtx.Lock()
+	defer c.outgoing.mtx.Unlock()
+
+	now := time.Now()
+	c.outgoing.dropExpired(now)
+
+	return c.outgoing.estimate(now, c.sendPolicy())
+}
+
+// channelState what the client knows about a joined channel from ROOMSTATE and USERSTATE
+type channelState struct {
+	slow time.Duration
+	// privileged is set when the client user is broadcaster, moderator or VIP in the channel
+	privileged bool
+}
+
+// channelStates tracks the channelState of every joined channel
+type channelStates struct {
+	mtx    sync.RWMutex
+	states map[string]*channelState
+}
+
+func newChannelStates() *channelStates {
+	return &channelStates{
+		states: map[string]*channelState{},
+	}
+}
+
+func (s *channelStates) get(channel string) *channelState {
+	state, ok := s.states[channel]
+	if !ok {
+		state = &channelState{}
+		s.states[channel] = state
+	}
+	return state
+}
+
+// update applies a ROOMSTATE or USERSTATE message
+func (s *channelStates) update(channel string, user *User, message *Message) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	state := s.get(channel)
+	switch message.Type {
+	case ROOMSTATE:
+		// twitch only sends the changed tags when a room setting changes
+		if slow, ok := message.Tags["slow"]; ok {
+			seconds, _ := strconv.Atoi(slow)
+			state.slow = time.Duration(seconds) * time.Second
+		}
+	case USERSTATE:
+		state.privileged = message.Tags["mod"] == "1" || user.Badges["broadcaster"] > 0 ||
+			user.Badges["moderator"] > 0 || user.Badges["vip"] > 0
+	}
+}
+
+func (s *channelStates) remove(channel string) {
+	s.mtx.Lock()
+	delete(s.states, channel)
+	s.mtx.Unlock()
+}
+
+// interval returns how long to wait between two messages to channel
+func (s *channelStates) interval(channel string) time.Duration {
+	// whispers are not sent to a chat room
+	if channel == "jtv" {
+		return 0
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	state, ok := s.states[strings.ToLower(channel)]
+	if !ok {
+		return channelSpacing
+	}
+	if state.privileged {
+		return 0
+	}
+	if state.slow > channelSpacing {
+		return state.slow
+	}
+	return channelSpacing
+}
+
+// sendPolicy returns the limits for the outgoing queue from the client options
+func (c *Client) sendPolicy() sendPolicy {
+	policy := sendPolicy{
+		limit:    c.RateLimit,
+		fairness: c.Fairness,
+	}
+	if c.RespectSlowMode {
+		policy.interval = c.channelStates.interval
+	}
+	return policy
+}
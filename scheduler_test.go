@@ -0,0 +1,94 @@
+package twitch
+
+import (
+	"testing"
+	"time"
+)
+
+func updateChannelState(states *channelStates, line string) {
+	channel, user, message := ParseMessage(line)
+	states.update(channel, user, message)
+}
+
+func TestChannelIntervalFollowsRoomAndUserState(t *testing.T) {
+	states := newChannelStates()
+
+	assertTrue(t, states.interval("gempir") == channelSpacing, "unknown channel is not spaced")
+	assertTrue(t, states.interval("jtv") == 0, "whispers are spaced")
+
+	updateChannelState(states, "@broadcaster-lang=;emote-only=0;followers-only=-1;r9k=0;rituals=0;room-id=11148817;slow=30;subs-only=0 :tmi.twitch.tv ROOMSTATE #gempir")
+	assertTrue(t, states.interval("gempir") == time.Second*30, "slow mode is ignored")
+
+	// a partial ROOMSTATE for another setting keeps slow mode
+	updateChannelState(states, "@emote-only=1;room-id=11148817 :tmi.twitch.tv ROOMSTATE #gempir")
+	assertTrue(t, states.interval("gempir") == time.Second*30, "slow mode lost on partial ROOMSTATE")
+
+	updateChannelState(states, "@badges=moderator/1;color=;display-name=justinfan123123;emote-sets=0;mod=1;subscriber=0;user-type=mod :tmi.twitch.tv USERSTATE #gempir")
+	assertTrue(t, states.interval("gempir") == 0, "moderator has to wait for slow mode")
+
+	updateChannelState(states, "@badges=;color=;display-name=justinfan123123;emote-sets=0;mod=0;subscriber=0;user-type= :tmi.twitch.tv USERSTATE #gempir")
+	updateChannelState(states, "@room-id=11148817;slow=0 :tmi.twitch.tv ROOMSTATE #gempir")
+	assertTrue(t, states.interval("gempir") == channelSpacing, "channel without slow mode is not spaced")
+}
+
+func TestSlowChannelDoesNotBlockOtherChannels(t *testing.T) {
+	states := newChannelStates()
+	updateChannelState(states, "@room-id=11148817;slow=30 :tmi.twitch.tv ROOMSTATE #gempir")
+	policy := sendPolicy{interval: states.interval}
+
+	queue := newOutgoingQueue()
+	queue.push(&queuedMessage{Line: "first", Channel: "gempir", Priority: PriorityCritical}, 0)
+	queue.push(&queuedMessage{Line: "second", Channel: "gempir", Priority: PriorityCritical}, 0)
+	queue.push(&queuedMessage{Line: "elsewhere", Channel: "pajlada", Priority: PriorityBulk}, 0)
+
+	message, _, _ := queue.pop(policy)
+	assertStringsEqual(t, "first", message.Line)
+	message, _, _ = queue.pop(policy)
+	assertStringsEqual(t, "elsewhere", message.Line)
+
+	message, wait, ok := queue.pop(policy)
+	assertTrue(t, ok && message == nil, "message sent within the slow mode interval")
+	assertTrue(t, wait > time.Second*29 && wait <= time.Second*30, "unexpected wait for slow mode: "+wait.String())
+}
+
+func TestPendingMessagesEstimateDelivery(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.RateLimit = RateLimit{Messages: 2, Period: time.Minute}
+	updateChannelState(client.channelStates, "@room-id=11148817;slow=10 :tmi.twitch.tv ROOMSTATE #gempir")
+
+	client.Say("gempir", "one")
+	client.Say("gempir", "two")
+	client.Say("pajlada", "three")
+
+	start := time.Now()
+	pending := client.PendingMessages()
+
+	assertIntsEqual(t, 3, len(pending))
+	assertStringsEqual(t, "PRIVMSG #gempir :one", pending[0].Line)
+	assertStringsEqual(t, "PRIVMSG #pajlada :three", pending[1].Line)
+	assertStringsEqual(t, "PRIVMSG #gempir :two", pending[2].Line)
+
+	assertTrue(t, pending[0].EstimatedDelivery.Sub(start) < time.Second, "first message is not sent right away")
+	assertTrue(t, pending[1].EstimatedDelivery.Sub(start) < time.Second, "other channel waits for slow mode")
+	// slow mode would allow it after 10 seconds, but the rate limit is used up for a minute
+	delay := pending[2].EstimatedDelivery.Sub(start)
+	assertTrue(t, delay > time.Second*59 && delay < time.Minute+time.Second, "unexpected estimate: "+delay.String())
+
+	// estimating does not change the queue
+	assertIntsEqual(t, 3, client.outgoing.len())
+}
+
+func TestMixedCaseChannelIsSpaced(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	updateChannelState(client.channelStates, "@room-id=11148817;slow=10 :tmi.twitch.tv ROOMSTATE #gempir")
+
+	client.Say("Gempir", "one")
+	client.Say("GEMPIR", "two")
+
+	message, _, _ := client.outgoing.pop(client.sendPolicy())
+	assertStringsEqual(t, "PRIVMSG #gempir :one", message.Line)
+
+	message, wait, ok := client.outgoing.pop(client.sendPolicy())
+	assertTrue(t, ok && message == nil, "message to the same channel in other case was not spaced")
+	assertTrue(t, wait > time.Second*9 && wait <= time.Second*10, "unexpected wait for slow mode: "+wait.String())
+}
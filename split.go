@@ -0,0 +1,139 @@
+package twitch
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMessageLength longest text twitch accepts in a PRIVMSG, in characters
+const maxMessageLength = 500
+
+// actionPrefix marks a /me message, it is repeated on every part of a split action
+const actionPrefix = "/me "
+
+// MessageSplitter splits text longer than twitch accepts into several messages
+// Text is split at whitespace, only words longer than a whole message are split between characters
+// Whitespace within a part is kept as it is, only the whitespace a part was split at is dropped
+// Emote names are words, so they are never cut
+type MessageSplitter struct {
+	// MaxLength of every message in characters, including the markers, defaults to 500
+	MaxLength int
+	// ContinuationSuffix appended to every message followed by another part, like " …"
+	ContinuationSuffix string
+	// ContinuationPrefix prepended to every message continuing the previous part, like "… "
+	ContinuationPrefix string
+}
+
+// Split returns the messages to send for text, text fitting into a single message is returned unchanged
+// Chat commands other than /me are never split
+func (s *MessageSplitter) Split(text string) []string {
+	maxLength := s.MaxLength
+	if maxLength <= 0 {
+		maxLength = maxMessageLength
+	}
+
+	if utf8.RuneCountInString(text) <= maxLength {
+		return []string{text}
+	}
+
+	action := ""
+	if strings.HasPrefix(text, actionPrefix) {
+		action = actionPrefix
+		text = text[len(actionPrefix):]
+	} else if strings.HasPrefix(text, "/") || strings.HasPrefix(text, ".") {
+		return []string{text}
+	}
+
+	budget := maxLength - utf8.RuneCountInString(action+s.ContinuationPrefix+s.ContinuationSuffix)
+	if budget <= 0 {
+		return []string{action + text}
+	}
+
+	var parts []string
+	for utf8.RuneCountInString(text) > budget {
+		chunk, rest := splitGraphemes(text, budget)
+
+		// cut at the last whitespace that fits, or the one right after the chunk, keeping all other whitespace as it is
+		cut := strings.LastIndexFunc(chunk, unicode.IsSpace)
+		if next, _ := utf8.DecodeRuneInString(rest); unicode.IsSpace(next) {
+			cut = len(chunk)
+		}
+		if cut >= 0 {
+			chunk = strings.TrimRightFunc(text[:cut], unicode.IsSpace)
+			rest = strings.TrimLeftFunc(text[cut:], unicode.IsSpace)
+		}
+
+		if chunk != "" {
+			parts = append(parts, chunk)
+		}
+		text = rest
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+
+	for i := range parts {
+		if i > 0 {
+			parts[i] = s.ContinuationPrefix + parts[i]
+		}
+		if i < len(parts)-1 {
+			parts[i] += s.ContinuationSuffix
+		}
+		parts[i] = action + parts[i]
+	}
+
+	return parts
+}
+
+// splitGraphemes cuts word after at most max characters, without separating a character from its combining marks
+func splitGraphemes(word string, max int) (string, string) {
+	runes := []rune(word)
+
+	end := 0
+	for end < len(runes) {
+		next := graphemeEnd(runes, end)
+		if next > max {
+			break
+		}
+		end = next
+	}
+
+	// a single grapheme longer than max, cutting it is the only option left
+	if end == 0 {
+		end = max
+	}
+
+	return string(runes[:end]), string(runes[end:])
+}
+
+// graphemeEnd returns the index after the grapheme cluster starting at runes[start]
+// an approximation of unicode text segmentation covering combining marks, emoji sequences and flags
+func graphemeEnd(runes []rune, start int) int {
+	i := start + 1
+	if isRegionalIndicator(runes[start]) && i < len(runes) && isRegionalIndicator(runes[i]) {
+		i++
+	}
+
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case r == '\u200d' && i+1 < len(runes):
+			// zero width joiner, the next character belongs to the same emoji
+			i += 2
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc),
+			r >= '\ufe00' && r <= '\ufe0f',
+			r >= 0x1f3fb && r <= 0x1f3ff,
+			r >= 0xe0020 && r <= 0xe007f:
+			// combining marks, variation selectors, skin tones and tag sequences
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
@@ -0,0 +1,80 @@
+package twitch
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestShortMessagesAreNotSplit(t *testing.T) {
+	splitter := &MessageSplitter{}
+
+	assertStringSlicesEqual(t, []string{"hello chat"}, splitter.Split("hello chat"))
+}
+
+func TestCanSplitOnWordBoundaries(t *testing.T) {
+	splitter := &MessageSplitter{MaxLength: 20}
+
+	parts := splitter.Split("the quick brown fox jumps over the lazy dog Kappa")
+
+	assertStringSlicesEqual(t, []string{"the quick brown fox", "jumps over the lazy", "dog Kappa"}, parts)
+}
+
+func TestSplitKeepsWhitespace(t *testing.T) {
+	splitter := &MessageSplitter{MaxLength: 10}
+
+	parts := splitter.Split("one  two\tthree four five")
+
+	assertStringSlicesEqual(t, []string{"one  two", "three four", "five"}, parts)
+}
+
+func TestSplitKeepsActionFraming(t *testing.T) {
+	splitter := &MessageSplitter{MaxLength: 20}
+
+	parts := splitter.Split("/me waves at everyone in chat")
+
+	assertStringSlicesEqual(t, []string{"/me waves at", "/me everyone in chat"}, parts)
+}
+
+func TestSplitAddsContinuationMarkers(t *testing.T) {
+	splitter := &MessageSplitter{MaxLength: 20, ContinuationPrefix: "… ", ContinuationSuffix: " …"}
+
+	parts := splitter.Split("the quick brown fox jumps over the lazy dog")
+
+	for _, part := range parts {
+		assertTrue(t, utf8.RuneCountInString(part) <= 20, "part longer than MaxLength: "+part)
+	}
+	assertStringSlicesEqual(t, []string{"the quick brown …", "… fox jumps over …", "… the lazy dog"}, parts)
+}
+
+func TestSplitNeverCutsMultiByteCharacters(t *testing.T) {
+	splitter := &MessageSplitter{MaxLength: 5}
+
+	// e + combining acute accent, a family emoji joined with zero width joiners and a flag
+	family := "\U0001F468\u200d\U0001F469\u200d\U0001F467"
+	word := "abe\u0301" + family + "\U0001F1E9\U0001F1EA" + "xyz"
+
+	parts := splitter.Split(word)
+
+	for _, part := range parts {
+		assertTrue(t, utf8.ValidString(part), "part is not valid utf-8")
+	}
+	assertStringsEqual(t, word, strings.Join(parts, ""))
+	assertStringSlicesEqual(t, []string{"abe\u0301", family, "\U0001F1E9\U0001F1EA" + "xyz"}, parts)
+}
+
+func TestCommandsAreNotSplit(t *testing.T) {
+	splitter := &MessageSplitter{MaxLength: 10}
+	command := "/ban someone for a very long reason"
+
+	assertStringSlicesEqual(t, []string{command}, splitter.Split(command))
+}
+
+func TestSayQueuesEveryPart(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.SplitMessages = &MessageSplitter{}
+
+	client.Say("gempir", strings.Repeat("LUL ", 200))
+
+	assertIntsEqual(t, 2, client.outgoing.len())
+}
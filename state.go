@@ -0,0 +1,147 @@
+package twitch
+
+import (
+	"net"
+)
+
+// ConnectionState the lifecycle state of a Client connection
+type ConnectionState int
+
+const (
+	// StateDisconnected not connected, either Connect() was not called yet or it returned with an error
+	StateDisconnected ConnectionState = iota
+	// StateDialing opening the network connection
+	StateDialing
+	// StateAuthenticating connection open, waiting for the login and capabilities to be acknowledged
+	StateAuthenticating
+	// StateConnected logged in, channels are joined and messages can be sent
+	StateConnected
+	// StateReconnecting the connection dropped, a new one is opened shortly
+	StateReconnecting
+	// StateClosed Disconnect() was called
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateDialing:
+		return "dialing"
+	case StateAuthenticating:
+		return "authenticating"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// State returns the current state of the connection
+func (c *Client) State() ConnectionState {
+	c.stateMtx.RLock()
+	defer c.stateMtx.RUnlock()
+
+	return c.state
+}
+
+// OnStateChange attach callback to every state change of the connection
+// err is the reason for changes caused by an error, like the connection dropping
+// or the *PermanentTokenError Connect() gave up on
+func (c *Client) OnStateChange(callback func(from, to ConnectionState, err error)) {
+	c.stateMtx.Lock()
+	c.onStateChange = callback
+	c.stateMtx.Unlock()
+}
+
+// setState moves to state to, no matter the current state
+func (c *Client) setState(to ConnectionState, err error) {
+	c.stateMtx.Lock()
+	from := c.state
+	c.state = to
+	callback := c.onStateChange
+	c.stateMtx.Unlock()
+
+	c.logStateChange(from, to, err)
+	if callback != nil && from != to {
+		callback(from, to, err)
+	}
+}
+
+// transition moves to state to, returns false if Disconnect() closed the client in the meantime
+func (c *Client) transition(to ConnectionState, err error) bool {
+	return c.transitionWith(to, err, nil)
+}
+
+// attach makes conn the current connection and moves on to authenticating,
+// returns false if Disconnect() closed the client while dialing
+func (c *Client) attach(conn net.Conn) bool {
+	return c.transitionWith(StateAuthenticating, nil, conn)
+}
+
+// promote moves conn from authenticating to connected, returns false if conn is not the authenticating connection anymore
+func (c *Client) promote(conn net.Conn) bool {
+	c.stateMtx.Lock()
+	from := c.state
+	if from != StateAuthenticating || c.connection != conn {
+		c.stateMtx.Unlock()
+		return false
+	}
+	c.state = StateConnected
+	callback := c.onStateChange
+	c.stateMtx.Unlock()
+
+	c.logStateChange(from, StateConnected, nil)
+	if callback != nil {
+		callback(from, StateConnected, nil)
+	}
+	return true
+}
+
+func (c *Client) transitionWith(to ConnectionState, err error, conn net.Conn) bool {
+	c.stateMtx.Lock()
+	from := c.state
+	if from == StateClosed {
+		c.stateMtx.Unlock()
+		return false
+	}
+	if conn != nil {
+		c.connection = conn
+	}
+	c.state = to
+	callback := c.onStateChange
+	c.stateMtx.Unlock()
+
+	c.logStateChange(from, to, err)
+	if callback != nil && from != to {
+		callback(from, to, err)
+	}
+	return true
+}
+
+func (c *Client) logStateChange(from, to ConnectionState, err error) {
+	if from == to {
+		return
+	}
+	if err != nil {
+		c.log(LevelWarn, "state changed", "from", from, "to", to, "error", err)
+		return
+	}
+	c.log(LevelInfo, "state changed", "from", from, "to", to)
+}
+
+// conn returns the current connection, nil before the first connect
+func (c *Client) conn() net.Conn {
+	c.stateMtx.RLock()
+	defer c.stateMtx.RUnlock()
+
+	return c.connection
+}
+
+// connected reports whether the connection is ready for sending
+func (c *Client) connected() bool {
+	return c.State() == StateConnected
+}
@@ -0,0 +1,141 @@
+package twitch
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stateRecorder collects the transitions reported by OnStateChange
+type stateRecorder struct {
+	mtx         sync.Mutex
+	transitions []string
+	changed     chan ConnectionState
+}
+
+func recordStates(client *Client) *stateRecorder {
+	recorder := &stateRecorder{changed: make(chan ConnectionState, 20)}
+	client.OnStateChange(func(from, to ConnectionState, err error) {
+		recorder.mtx.Lock()
+		recorder.transitions = append(recorder.transitions, from.String()+" -> "+to.String())
+		recorder.mtx.Unlock()
+		recorder.changed <- to
+	})
+	return recorder
+}
+
+func (r *stateRecorder) waitFor(t *testing.T, state ConnectionState) {
+	for {
+		select {
+		case to := <-r.changed:
+			if to == state {
+				return
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("client did not reach state %s", state)
+		}
+	}
+}
+
+func (r *stateRecorder) get() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string{}, r.transitions...)
+}
+
+func TestStateChangesDuringConnectAndDisconnect(t *testing.T) {
+	host := startServer(t, nothingOnConnect, nothingOnMessage)
+	client := newTestClient(host)
+	recorder := recordStates(client)
+
+	assertStringsEqual(t, "disconnected", client.State().String())
+
+	errs := make(chan error)
+	go func() {
+		errs <- client.Connect()
+	}()
+
+	recorder.waitFor(t, StateConnected)
+	assertStringsEqual(t, "connected", client.State().String())
+
+	client.Disconnect()
+
+	select {
+	case err := <-errs:
+		if err != ErrClientDisconnected {
+			t.Fatalf("expected ErrClientDisconnected, got %v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("Connect() did not return after Disconnect()")
+	}
+
+	assertStringSlicesEqual(t, []string{
+		"disconnected -> dialing",
+		"dialing -> authenticating",
+		"authenticating -> connected",
+		"connected -> closed",
+	}, recorder.get())
+}
+
+func TestStateChangesDuringReconnect(t *testing.T) {
+	host := startServerMultiConns(t, 2, func(conn net.Conn) {
+		time.AfterFunc(50*time.Millisecond, func() {
+			conn.Write([]byte(":tmi.twitch.tv RECONNECT\r\n"))
+		})
+	}, nothingOnMessage)
+
+	client := newTestClient(host)
+	recorder := recordStates(client)
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	recorder.waitFor(t, StateReconnecting)
+	recorder.waitFor(t, StateConnected)
+
+	transitions := recorder.get()
+	assertStringSlicesEqual(t, []string{
+		"disconnected -> dialing",
+		"dialing -> authenticating",
+		"authenticating -> connected",
+		"connected -> reconnecting",
+		"reconnecting -> dialing",
+		"dialing -> authenticating",
+		"authenticating -> connected",
+	}, transitions[:7])
+}
+
+func TestStateIsDisconnectedAfterFailedDial(t *testing.T) {
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = "127.0.0.1:123123123123"
+
+	client.Connect()
+
+	assertStringsEqual(t, "disconnected", client.State().String())
+}
+
+func TestConcurrentUseWhileConnecting(t *testing.T) {
+	host := startServer(t, nothingOnConnect, nothingOnMessage)
+	client := newTestClient(host)
+	recorder := recordStates(client)
+
+	go client.Connect()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Join("gempir")
+			client.Say("gempir", "hello")
+			client.Userlist("gempir")
+			client.State()
+			client.Depart("gempir")
+		}()
+	}
+
+	recorder.waitFor(t, StateConnected)
+	wg.Wait()
+	client.Disconnect()
+}
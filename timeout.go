@@ -0,0 +1,89 @@
+package twitch
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrReadTimeout the connection was dropped because nothing was read for Client.ReadTimeout, the client reconnects
+	ErrReadTimeout = errors.New("read timed out")
+
+	// ErrWriteTimeout the connection was dropped because a write took longer than Client.WriteTimeout, the client reconnects
+	ErrWriteTimeout = errors.New("write timed out")
+)
+
+// defaultWriteTimeout how long a single write may block before the connection counts as stalled
+const defaultWriteTimeout = time.Second * 10
+
+// deadlineConn sets a fresh deadline before every read and write of the wrapped connection
+// and reports timeouts as ErrReadTimeout and ErrWriteTimeout
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	errMtx sync.Mutex
+	err    error
+}
+
+func newDeadlineConn(conn net.Conn, readTimeout, writeTimeout time.Duration) net.Conn {
+	if readTimeout <= 0 && writeTimeout <= 0 {
+		return conn
+	}
+
+	return &deadlineConn{
+		Conn:         conn,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+	}
+}
+
+func (d *deadlineConn) Read(p []byte) (int, error) {
+	if d.readTimeout > 0 {
+		d.Conn.SetReadDeadline(time.Now().Add(d.readTimeout))
+	}
+
+	n, err := d.Conn.Read(p)
+	if err != nil {
+		// a stalled write closes the connection, report that instead of the resulting read error
+		if writeErr := d.failure(); writeErr != nil {
+			return n, writeErr
+		}
+		if isTimeout(err) {
+			return n, ErrReadTimeout
+		}
+	}
+	return n, err
+}
+
+func (d *deadlineConn) Write(p []byte) (int, error) {
+	if d.writeTimeout > 0 {
+		d.Conn.SetWriteDeadline(time.Now().Add(d.writeTimeout))
+	}
+
+	n, err := d.Conn.Write(p)
+	if err != nil && isTimeout(err) {
+		d.errMtx.Lock()
+		d.err = ErrWriteTimeout
+		d.errMtx.Unlock()
+
+		// a partially written line leaves the connection unusable
+		d.Conn.Close()
+		return n, ErrWriteTimeout
+	}
+	return n, err
+}
+
+func (d *deadlineConn) failure() error {
+	d.errMtx.Lock()
+	defer d.errMtx.Unlock()
+	return d.err
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
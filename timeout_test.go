@@ -0,0 +1,81 @@
+package twitch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadTimeoutOnSilentConnection(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer serverSide.Close()
+
+	conn := newDeadlineConn(clientSide, time.Millisecond*50, 0)
+	defer conn.Close()
+
+	if _, err := conn.Read(make([]byte, 10)); err != ErrReadTimeout {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+}
+
+func TestWriteTimeoutOnStalledPeer(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer serverSide.Close()
+
+	conn := newDeadlineConn(clientSide, 0, time.Millisecond*50)
+
+	// nobody reads the other end of the pipe, so the write never completes
+	if _, err := conn.Write([]byte("PRIVMSG #gempir :hello\r\n")); err != ErrWriteTimeout {
+		t.Fatalf("expected ErrWriteTimeout, got %v", err)
+	}
+
+	// the connection is closed, reads report why
+	if _, err := conn.Read(make([]byte, 10)); err != ErrWriteTimeout {
+		t.Fatalf("expected ErrWriteTimeout from read after stalled write, got %v", err)
+	}
+}
+
+func TestNoDeadlinesWithoutTimeouts(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	if conn := newDeadlineConn(clientSide, 0, 0); conn != clientSide {
+		t.Fatal("connection was wrapped without any timeouts")
+	}
+}
+
+func TestReadTimeoutReturnsFromReadConnection(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer serverSide.Close()
+
+	client := NewClient("justinfan123123", "oauth:123123132")
+	client.connection = newDeadlineConn(clientSide, time.Millisecond*50, 0)
+
+	if err := client.readConnection(client.connection); err != ErrReadTimeout {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+}
+
+func TestReconnectsAfterReadTimeout(t *testing.T) {
+	wait := make(chan bool)
+
+	// the server welcomes the client and then never sends anything again
+	host := startServerMultiConns(t, 2, func(conn net.Conn) {
+		wait <- true
+	}, nothingOnMessage)
+
+	client := newTestClient(host)
+	client.SendPings = false
+	client.ReadTimeout = time.Millisecond * 100
+
+	go client.Connect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-wait:
+		case <-time.After(time.Second * 3):
+			t.Fatalf("connection %d was not established", i+1)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package twitch
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// tokenRetryMinBackoff wait before asking a failed TokenProvider again, doubled on every further failure
+	tokenRetryMinBackoff = time.Millisecond * 200
+	// tokenRetryMaxBackoff upper bound of the wait between two attempts
+	tokenRetryMaxBackoff = time.Second * 30
+)
+
+// TokenProvider supplies the oauth token used for authentication
+// Token is called on every connect and reconnect, so it can hand out a freshly refreshed token each time
+// A failing Token is retried with a backoff, unless the error is a *PermanentTokenError
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenRefresher can optionally be implemented by a TokenProvider
+// When twitch rejects the token, Refresh is called once and the login is retried with the next Token
+type TokenRefresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// PermanentTokenError marks an error of a TokenProvider that retrying won't fix, like a revoked refresh token
+// Connect() gives up and returns Err instead of asking the provider again
+type PermanentTokenError struct {
+	Err error
+}
+
+func (e *PermanentTokenError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns Err
+func (e *PermanentTokenError) Unwrap() error {
+	return e.Err
+}
+
+// SetTokenProvider sets a provider that is asked for the oauth token on every (re)connect
+// It takes precedence over the token passed to NewClient or SetIRCToken
+func (c *Client) SetTokenProvider(provider TokenProvider) {
+	c.tokenProvider = provider
+}
+
+// token returns the oauth token to authenticate the next connection with
+func (c *Client) token(ctx context.Context) (string, error) {
+	if c.tokenProvider == nil {
+		return c.ircToken, nil
+	}
+
+	return c.tokenProvider.Token(ctx)
+}
+
+// refreshToken asks the token provider for a new token, returns false if the provider can't refresh or the refresh failed
+func (c *Client) refreshToken(ctx context.Context) bool {
+	refresher, ok := c.tokenProvider.(TokenRefresher)
+	if !ok {
+		return false
+	}
+
+	if err := refresher.Refresh(ctx); err != nil {
+		c.log(LevelError, "token refresh failed", "error", err)
+		return false
+	}
+	return true
+}
+
+// nextTokenBackoff returns the wait after backoff, doubled up to tokenRetryMaxBackoff
+func nextTokenBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > tokenRetryMaxBackoff {
+		return tokenRetryMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,166 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+type stubTokenProvider struct {
+	mtx       sync.Mutex
+	token     string
+	refreshed string
+	calls     int
+	refreshes int
+	err       error
+	// failures the number of calls failing with err before the token is returned, all of them when 0
+	failures int
+}
+
+func (p *stubTokenProvider) Token(ctx context.Context) (string, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.calls++
+	if p.err != nil && (p.failures == 0 || p.calls <= p.failures) {
+		return "", p.err
+	}
+	return p.token, nil
+}
+
+func (p *stubTokenProvider) Refresh(ctx context.Context) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.refreshes++
+	p.token = p.refreshed
+	return nil
+}
+
+// startAuthServer starts a server rejecting every login with another token than validToken
+func startAuthServer(validToken string) *twitchtest.Server {
+	server := twitchtest.NewServer()
+	server.AcceptToken(validToken)
+
+	return server
+}
+
+// passes returns the oauth tokens of the PASS lines server received
+func passes(server *twitchtest.Server) []string {
+	var passes []string
+	for _, line := range server.Received() {
+		if strings.HasPrefix(line, "PASS ") {
+			passes = append(passes, strings.TrimPrefix(line, "PASS "))
+		}
+	}
+	return passes
+}
+
+func TestCanUseTokenProvider(t *testing.T) {
+	server := startAuthServer("oauth:fromprovider")
+	defer server.Close()
+
+	provider := &stubTokenProvider{token: "oauth:fromprovider"}
+	client := NewClient("justinfan123123", "oauth:unused")
+	client.TLS = false
+	client.IrcAddress = server.Addr
+	client.SetTokenProvider(provider)
+	go client.Connect()
+	defer client.Disconnect()
+
+	pass, err := server.WaitFor("PASS", time.Second*3)
+	if err != nil {
+		t.Fatal("no oauth read")
+	}
+	assertStringsEqual(t, "PASS oauth:fromprovider", pass)
+}
+
+func TestCanRefreshTokenOnAuthFailure(t *testing.T) {
+	connected := make(chan struct{})
+
+	server := startAuthServer("oauth:fresh")
+	defer server.Close()
+
+	provider := &stubTokenProvider{token: "oauth:expired", refreshed: "oauth:fresh"}
+	client := NewClient("justinfan123123", "")
+	client.TLS = false
+	client.IrcAddress = server.Addr
+	client.SetTokenProvider(provider)
+	client.OnConnect(func() {
+		close(connected)
+	})
+	go client.Connect()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second * 3):
+		t.Fatal("client did not connect after refreshing token")
+	}
+
+	assertStringSlicesEqual(t, []string{"oauth:expired", "oauth:fresh"}, passes(server))
+	assertIntsEqual(t, 1, provider.refreshes)
+}
+
+func TestGivesUpAfterOneTokenRefresh(t *testing.T) {
+	server := startAuthServer("oauth:neverissued")
+	defer server.Close()
+
+	provider := &stubTokenProvider{token: "oauth:expired", refreshed: "oauth:stillwrong"}
+	client := NewClient("justinfan123123", "")
+	client.TLS = false
+	client.IrcAddress = server.Addr
+	client.SetTokenProvider(provider)
+
+	if err := client.Connect(); err != ErrLoginAuthenticationFailed {
+		t.Fatalf("expected ErrLoginAuthenticationFailed, got %v", err)
+	}
+	assertIntsEqual(t, 1, provider.refreshes)
+	assertIntsEqual(t, 2, provider.calls)
+}
+
+func TestConnectReturnsPermanentTokenProviderError(t *testing.T) {
+	providerErr := errors.New("refresh token revoked")
+	server := startAuthServer("oauth:neverissued")
+	defer server.Close()
+
+	provider := &stubTokenProvider{err: &PermanentTokenError{Err: providerErr}}
+	client := NewClient("justinfan123123", "")
+	client.TLS = false
+	client.IrcAddress = server.Addr
+	client.SetTokenProvider(provider)
+
+	if err := client.Connect(); err != providerErr {
+		t.Fatalf("expected token provider error, got %v", err)
+	}
+	assertIntsEqual(t, 1, provider.calls)
+}
+
+func TestRetriesTokenProviderError(t *testing.T) {
+	connected := make(chan struct{})
+	server := startAuthServer("oauth:fromprovider")
+	defer server.Close()
+
+	provider := &stubTokenProvider{token: "oauth:fromprovider", err: errors.New("token endpoint unavailable"), failures: 2}
+	client := NewClient("justinfan123123", "")
+	client.TLS = false
+	client.IrcAddress = server.Addr
+	client.SetTokenProvider(provider)
+	client.OnConnect(func() {
+		close(connected)
+	})
+	go client.Connect()
+	defer client.Disconnect()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second * 3):
+		t.Fatal("client did not connect after the token provider recovered")
+	}
+
+	provider.mtx.Lock()
+	defer provider.mtx.Unlock()
+	assertIntsEqual(t, 3, provider.calls)
+}
@@ -0,0 +1,164 @@
+package twitch
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrTransportOptions returned from Connect() when DialContext, Proxy or TLSConfig of the Client can not be applied to Client.Transport,
+// because the transport sets the same option itself or has no such option
+var ErrTransportOptions = errors.New("client options conflict with the transport")
+
+// Transport opens the connection a Client talks IRC over
+// Set Client.Transport to pick one, by default TCPTransport or TLSTransport is used depending on Client.TLS
+type Transport interface {
+	Dial(address string) (net.Conn, error)
+}
+
+// tlsHandshakeTimeout how long the TLS handshake of a new connection may take
+const tlsHandshakeTimeout = time.Second * 10
+
+// DialContextFunc opens a network connection, (*net.Dialer).DialContext matches it
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// TCPTransport plain text IRC over TCP
+type TCPTransport struct {
+	DialContext DialContextFunc
+}
+
+// Dial connects to address, which is a host:port pair
+func (t *TCPTransport) Dial(address string) (net.Conn, error) {
+	return dialContext(t.DialContext)(context.Background(), "tcp", address)
+}
+
+// TLSTransport IRC over TCP secured with TLS
+type TLSTransport struct {
+	DialContext DialContextFunc
+	Config      *tls.Config
+}
+
+// Dial connects to address, which is a host:port pair
+func (t *TLSTransport) Dial(address string) (net.Conn, error) {
+	conn, err := dialContext(t.DialContext)(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tlsHandshake(conn, address, t.Config)
+}
+
+// transport returns the Transport to connect with, carrying over DialContext, Proxy and TLSConfig of the client
+// a Transport set on the client is copied, not modified
+func (c *Client) transport() (Transport, error) {
+	switch t := c.Transport.(type) {
+	case nil:
+		dial, err := c.dialContext(nil)
+		if err != nil {
+			return nil, err
+		}
+		if c.TLS {
+			return &TLSTransport{DialContext: dial, Config: c.TLSConfig}, nil
+		}
+		return &TCPTransport{DialContext: dial}, nil
+	case *TCPTransport:
+		if c.TLSConfig != nil {
+			return nil, ErrTransportOptions
+		}
+		dial, err := c.dialContext(t.DialContext)
+		if err != nil {
+			return nil, err
+		}
+		return &TCPTransport{DialContext: dial}, nil
+	case *TLSTransport:
+		dial, err := c.dialContext(t.DialContext)
+		if err != nil {
+			return nil, err
+		}
+		config, err := c.tlsConfig(t.Config)
+		if err != nil {
+			return nil, err
+		}
+		return &TLSTransport{DialContext: dial, Config: config}, nil
+	case *WebSocketTransport:
+		dial, err := c.dialContext(t.DialContext)
+		if err != nil {
+			return nil, err
+		}
+		config, err := c.tlsConfig(t.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &WebSocketTransport{DialContext: dial, TLSConfig: config}, nil
+	}
+
+	if c.DialContext != nil || c.Proxy != nil || c.TLSConfig != nil {
+		return nil, ErrTransportOptions
+	}
+	return c.Transport, nil
+}
+
+// dialContext returns dial of a transport, or DialContext of the client, tunneled through Proxy if it is set
+func (c *Client) dialContext(dial DialContextFunc) (DialContextFunc, error) {
+	if c.DialContext != nil {
+		if dial != nil {
+			return nil, ErrTransportOptions
+		}
+		dial = c.DialContext
+	}
+	if c.Proxy == nil {
+		return dial, nil
+	}
+
+	return ProxyDialContext(c.Proxy, dial)
+}
+
+// tlsConfig returns config of a transport, or TLSConfig of the client
+func (c *Client) tlsConfig(config *tls.Config) (*tls.Config, error) {
+	if c.TLSConfig == nil {
+		return config, nil
+	}
+	if config != nil {
+		return nil, ErrTransportOptions
+	}
+
+	return c.TLSConfig, nil
+}
+
+// tlsHandshake secures conn, verifying the certificate against the host of address unless config names a server
+func tlsHandshake(conn net.Conn, address string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+func dialContext(dial DialContextFunc) DialContextFunc {
+	if dial != nil {
+		return dial
+	}
+
+	dialer := &net.Dialer{
+		KeepAlive: time.Second * 10,
+	}
+	return dialer.DialContext
+}
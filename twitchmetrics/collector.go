@@ -0,0 +1,226 @@
+// Package twitchmetrics collects the metrics of a twitch client and serves them in the Prometheus text format
+//
+// No Prometheus library is needed, a Collector is both the twitch.Metrics of a client and an http.Handler:
+//
+//	collector := twitchmetrics.NewCollector()
+//	client.Metrics = collector
+//	http.Handle("/metrics", collector)
+package twitchmetrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+)
+
+// contentType of the Prometheus text exposition format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultLatencyBuckets upper bounds in seconds of the latency histogram
+var DefaultLatencyBuckets = []float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Collector implements twitch.Metrics and serves what it collected on ServeHTTP
+// A Collector can be shared by several clients, like those of a pool, counters then add up
+// but the queue depth is the one last reported by any client, give every client its own Collector to tell them apart
+type Collector struct {
+	mtx            sync.Mutex
+	received       map[receivedKey]uint64
+	sent           map[string]uint64
+	waits          uint64
+	waited         time.Duration
+	reconnects     uint64
+	parseErrors    uint64
+	latencyBuckets []float64
+	// latencyCounts[i] counts the measurements up to latencyBuckets[i], not cumulative
+	latencyCounts []uint64
+	latencyCount  uint64
+	latencySum    time.Duration
+	queueDepth    int
+}
+
+type receivedKey struct {
+	messageType twitch.MessageType
+	channel     string
+}
+
+// NewCollector creates a Collector with the DefaultLatencyBuckets
+func NewCollector() *Collector {
+	return NewCollectorWithBuckets(DefaultLatencyBuckets)
+}
+
+// NewCollectorWithBuckets creates a Collector with custom upper bounds of the latency histogram, in seconds
+func NewCollectorWithBuckets(latencyBuckets []float64) *Collector {
+	buckets := append([]float64{}, latencyBuckets...)
+	sort.Float64s(buckets)
+
+	return &Collector{
+		received:       map[receivedKey]uint64{},
+		sent:           map[string]uint64{},
+		latencyBuckets: buckets,
+		latencyCounts:  make([]uint64, len(buckets)),
+	}
+}
+
+// MessageReceived counts a received message
+func (c *Collector) MessageReceived(messageType twitch.MessageType, channel string) {
+	c.mtx.Lock()
+	c.received[receivedKey{messageType: messageType, channel: channel}]++
+	c.mtx.Unlock()
+}
+
+// MessageSent counts a sent message
+func (c *Collector) MessageSent(channel string) {
+	c.mtx.Lock()
+	c.sent[channel]++
+	c.mtx.Unlock()
+}
+
+// RateLimitWait counts a message held back by the outgoing queue and adds up how long it waited
+func (c *Collector) RateLimitWait(wait time.Duration) {
+	c.mtx.Lock()
+	c.waits++
+	c.waited += wait
+	c.mtx.Unlock()
+}
+
+// Reconnect counts a reconnect
+func (c *Collector) Reconnect() {
+	c.mtx.Lock()
+	c.reconnects++
+	c.mtx.Unlock()
+}
+
+// Latency adds a measurement to the latency histogram
+func (c *Collector) Latency(latency time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.latencyCount++
+	c.latencySum += latency
+	i := sort.SearchFloat64s(c.latencyBuckets, latency.Seconds())
+	if i < len(c.latencyCounts) {
+		c.latencyCounts[i]++
+	}
+}
+
+// QueueDepth sets the number of queued messages
+func (c *Collector) QueueDepth(depth int) {
+	c.mtx.Lock()
+	c.queueDepth = depth
+	c.mtx.Unlock()
+}
+
+// ParseError counts a line that could not be parsed
+func (c *Collector) ParseError() {
+	c.mtx.Lock()
+	c.parseErrors++
+	c.mtx.Unlock()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var b strings.Builder
+
+	writeHeader(&b, "twitch_messages_received_total", "counter", "Messages received, by type and channel.")
+	received := make([]receivedKey, 0, len(c.received))
+	for key := range c.received {
+		received = append(received, key)
+	}
+	sort.Slice(received, func(i, j int) bool {
+		if received[i].messageType != received[j].messageType {
+			return received[i].messageType < received[j].messageType
+		}
+		return received[i].channel < received[j].channel
+	})
+	for _, key := range received {
+		writeSample(&b, "twitch_messages_received_total", labels("type", key.messageType.String(), "channel", key.channel), formatUint(c.received[key]))
+	}
+
+	writeHeader(&b, "twitch_messages_sent_total", "counter", "Messages sent, by channel.")
+	sent := make([]string, 0, len(c.sent))
+	for channel := range c.sent {
+		sent = append(sent, channel)
+	}
+	sort.Strings(sent)
+	for _, channel := range sent {
+		writeSample(&b, "twitch_messages_sent_total", labels("channel", channel), formatUint(c.sent[channel]))
+	}
+
+	writeHeader(&b, "twitch_rate_limit_waits_total", "counter", "Messages the outgoing queue held back for the rate limit or slow mode.")
+	writeSample(&b, "twitch_rate_limit_waits_total", "", formatUint(c.waits))
+	writeHeader(&b, "twitch_rate_limit_wait_seconds_total", "counter", "Time messages were held back for the rate limit or slow mode.")
+	writeSample(&b, "twitch_rate_limit_wait_seconds_total", "", formatFloat(c.waited.Seconds()))
+
+	writeHeader(&b, "twitch_reconnects_total", "counter", "Reconnects after the connection dropped.")
+	writeSample(&b, "twitch_reconnects_total", "", formatUint(c.reconnects))
+
+	writeHeader(&b, "twitch_latency_seconds", "histogram", "Round-trip time of PINGs to the server.")
+	var cumulative uint64
+	for i, bound := range c.latencyBuckets {
+		cumulative += c.latencyCounts[i]
+		writeSample(&b, "twitch_latency_seconds_bucket", labels("le", formatFloat(bound)), formatUint(cumulative))
+	}
+	writeSample(&b, "twitch_latency_seconds_bucket", labels("le", "+Inf"), formatUint(c.latencyCount))
+	writeSample(&b, "twitch_latency_seconds_sum", "", formatFloat(c.latencySum.Seconds()))
+	writeSample(&b, "twitch_latency_seconds_count", "", formatUint(c.latencyCount))
+
+	writeHeader(&b, "twitch_queue_depth", "gauge", "Messages waiting in the outgoing queue.")
+	writeSample(&b, "twitch_queue_depth", "", strconv.Itoa(c.queueDepth))
+
+	writeHeader(&b, "twitch_parse_errors_total", "counter", "Received lines that could not be parsed.")
+	writeSample(&b, "twitch_parse_errors_total", "", formatUint(c.parseErrors))
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHeader(b *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeSample(b *strings.Builder, name, labels, value string) {
+	b.WriteString(name)
+	b.WriteString(labels)
+	b.WriteByte(' ')
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// labels formats alternating names and values as {name="value",...}
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+escapeLabelValue(pairs[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatUint(value uint64) string {
+	return strconv.FormatUint(value, 10)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,129 @@
+package twitchmetrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+func scrape(t *testing.T, collector *Collector) string {
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	body, _ := ioutil.ReadAll(recorder.Body)
+	return string(body)
+}
+
+func assertContainsLine(t *testing.T, body, line string) {
+	t.Helper()
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return
+		}
+	}
+	t.Errorf("line %q not found in:\n%s", line, body)
+}
+
+func TestCanExportCounters(t *testing.T) {
+	collector := NewCollector()
+	collector.MessageReceived(twitch.PRIVMSG, "gempir")
+	collector.MessageReceived(twitch.PRIVMSG, "gempir")
+	collector.MessageReceived(twitch.USERNOTICE, "pajlada")
+	collector.MessageSent("gempir")
+	collector.RateLimitWait(time.Millisecond * 1500)
+	collector.Reconnect()
+	collector.QueueDepth(3)
+	collector.ParseError()
+
+	body := scrape(t, collector)
+
+	assertContainsLine(t, body, "# TYPE twitch_messages_received_total counter")
+	assertContainsLine(t, body, `twitch_messages_received_total{type="PRIVMSG",channel="gempir"} 2`)
+	assertContainsLine(t, body, `twitch_messages_received_total{type="USERNOTICE",channel="pajlada"} 1`)
+	assertContainsLine(t, body, `twitch_messages_sent_total{channel="gempir"} 1`)
+	assertContainsLine(t, body, "twitch_rate_limit_waits_total 1")
+	assertContainsLine(t, body, "twitch_rate_limit_wait_seconds_total 1.5")
+	assertContainsLine(t, body, "twitch_reconnects_total 1")
+	assertContainsLine(t, body, "# TYPE twitch_queue_depth gauge")
+	assertContainsLine(t, body, "twitch_queue_depth 3")
+	assertContainsLine(t, body, "twitch_parse_errors_total 1")
+}
+
+func TestCanExportLatencyHistogram(t *testing.T) {
+	collector := NewCollectorWithBuckets([]float64{0.1, 0.05})
+	collector.Latency(time.Millisecond * 20)
+	collector.Latency(time.Millisecond * 50)
+	collector.Latency(time.Millisecond * 80)
+	collector.Latency(time.Second)
+
+	body := scrape(t, collector)
+
+	assertContainsLine(t, body, "# TYPE twitch_latency_seconds histogram")
+	assertContainsLine(t, body, `twitch_latency_seconds_bucket{le="0.05"} 2`)
+	assertContainsLine(t, body, `twitch_latency_seconds_bucket{le="0.1"} 3`)
+	assertContainsLine(t, body, `twitch_latency_seconds_bucket{le="+Inf"} 4`)
+	assertContainsLine(t, body, "twitch_latency_seconds_sum 1.15")
+	assertContainsLine(t, body, "twitch_latency_seconds_count 4")
+}
+
+func TestCanEscapeLabelValues(t *testing.T) {
+	collector := NewCollector()
+	collector.MessageSent("a\"b\\c\nd")
+
+	assertContainsLine(t, scrape(t, collector), `twitch_messages_sent_total{channel="a\"b\\c\nd"} 1`)
+}
+
+func TestCollectsMetricsOfClient(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	collector := NewCollector()
+	received := make(chan struct{})
+
+	client := twitch.NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+	client.RespectSlowMode = false
+	client.Metrics = collector
+	client.Join("gempir")
+	client.OnNewMessage(func(channel string, user twitch.User, message twitch.Message) {
+		close(received)
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	if _, err := server.WaitFor("JOIN #gempir", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	client.Say("gempir", "hello")
+	if _, err := server.WaitFor("PRIVMSG #gempir :hello", time.Second*3); err != nil {
+		t.Fatal(err)
+	}
+	server.Send("@id=1 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :hi")
+
+	select {
+	case <-received:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message received")
+	}
+
+	// the queue depth is reported after the message was written, which can be after the server read it
+	body := scrape(t, collector)
+	for deadline := time.Now().Add(time.Second * 3); !strings.Contains(body, "\ntwitch_queue_depth 0\n") && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 2)
+		body = scrape(t, collector)
+	}
+	assertContainsLine(t, body, `twitch_messages_received_total{type="PRIVMSG",channel="gempir"} 1`)
+	assertContainsLine(t, body, `twitch_messages_sent_total{channel="gempir"} 1`)
+	assertContainsLine(t, body, "twitch_queue_depth 0")
+}
@@ -0,0 +1,487 @@
+// Package twitchtest provides an in-process fake of the twitch IRC server for testing bots offline
+//
+// The server answers the login, CAP REQ, JOIN, PART, PING and PRIVMSG the way twitch does,
+// records every line clients send and lets tests inject any line from the server side.
+//
+//	server := twitchtest.NewServer()
+//	defer server.Close()
+//
+//	client := twitch.NewClient("justinfan123123", "oauth:123123123")
+//	client.IrcAddress = server.Addr
+//	client.TLS = false
+//	go client.Connect()
+//
+//	server.WaitFor("JOIN #gempir", time.Second)
+//	server.Send("@id=1 :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :hello")
+package twitchtest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tagsCapability       = "twitch.tv/tags"
+	commandsCapability   = "twitch.tv/commands"
+	membershipCapability = "twitch.tv/membership"
+)
+
+// ErrTimeout returned from WaitFor when no matching line was received in time
+var ErrTimeout = errors.New("timed out waiting for line")
+
+// Server a fake twitch IRC server listening on a local port
+type Server struct {
+	// Addr host:port the server listens on, to use as Client.IrcAddress
+	Addr string
+
+	listener net.Listener
+
+	mtx         sync.Mutex
+	conns       []*Conn
+	received    []string
+	cursor      int
+	newLine     chan struct{}
+	failAuth    bool
+	token       string
+	rateLimited bool
+	handler     func(line string) ([]string, bool)
+	closed      bool
+}
+
+// Conn one open client connection
+type Conn struct {
+	server       *Server
+	conn         net.Conn
+	writeMtx     sync.Mutex
+	pass         string
+	capabilities map[string]bool
+
+	// guarded by the mutex of the server
+	nick     string
+	channels map[string]bool
+	received []string
+}
+
+// NewServer starts a server accepting plain TCP connections
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("twitchtest: failed to listen: %s", err))
+	}
+
+	return newServer(listener)
+}
+
+// NewTLSServer starts a server accepting TLS connections, with a certificate trusted by ClientTLSConfig
+func NewTLSServer() *Server {
+	cert, err := tls.X509KeyPair(testCert, testKey)
+	if err != nil {
+		panic(fmt.Sprintf("twitchtest: invalid test certificate: %s", err))
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		panic(fmt.Sprintf("twitchtest: failed to listen: %s", err))
+	}
+
+	return newServer(listener)
+}
+
+func newServer(listener net.Listener) *Server {
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		newLine:  make(chan struct{}),
+	}
+
+	go s.accept()
+
+	return s
+}
+
+// ClientTLSConfig returns a TLS configuration trusting the certificate of servers started with NewTLSServer
+func (s *Server) ClientTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(testCert)
+
+	return &tls.Config{RootCAs: pool}
+}
+
+// Close stops listening and drops every connection
+func (s *Server) Close() {
+	s.mtx.Lock()
+	s.closed = true
+	s.mtx.Unlock()
+
+	s.listener.Close()
+	s.DropConnections()
+}
+
+// Connections returns the number of open client connections
+func (s *Server) Connections() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return len(s.conns)
+}
+
+// Conns returns the open client connections, in the order they connected
+func (s *Server) Conns() []*Conn {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]*Conn{}, s.conns...)
+}
+
+// Send writes line to every connected client, the line ending is added
+func (s *Server) Send(line string) {
+	for _, conn := range s.Conns() {
+		conn.write(line)
+	}
+}
+
+// Reconnect asks every connected client to reconnect, like twitch does before restarting a server
+func (s *Server) Reconnect() {
+	s.Send(":tmi.twitch.tv RECONNECT")
+}
+
+// DropConnections closes every client connection without notice
+func (s *Server) DropConnections() {
+	for _, conn := range s.Conns() {
+		conn.Close()
+	}
+}
+
+// FailAuth makes the server reject every following login, like twitch does for a wrong or expired token
+func (s *Server) FailAuth(fail bool) {
+	s.mtx.Lock()
+	s.failAuth = fail
+	s.mtx.Unlock()
+}
+
+// AcceptToken makes the server reject every following login with another oauth token, an empty token accepts all of them
+func (s *Server) AcceptToken(token string) {
+	s.mtx.Lock()
+	s.token = token
+	s.mtx.Unlock()
+}
+
+// Handle lets handler answer the lines clients send, instead of the server
+// handler is called with every line, if ok is true its answer is sent to the client and the server doesn't answer line itself
+func (s *Server) Handle(handler func(line string) (answer []string, ok bool)) {
+	s.mtx.Lock()
+	s.handler = handler
+	s.mtx.Unlock()
+}
+
+// RateLimit makes the server answer every following PRIVMSG with a msg_ratelimit NOTICE instead of accepting it
+func (s *Server) RateLimit(limited bool) {
+	s.mtx.Lock()
+	s.rateLimited = limited
+	s.mtx.Unlock()
+}
+
+// Received returns every line clients sent, in the order they arrived
+func (s *Server) Received() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]string{}, s.received...)
+}
+
+// WaitFor returns the next line starting with prefix, lines before it are skipped by following calls
+func (s *Server) WaitFor(prefix string, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mtx.Lock()
+		for s.cursor < len(s.received) {
+			line := s.received[s.cursor]
+			s.cursor++
+			if strings.HasPrefix(line, prefix) {
+				s.mtx.Unlock()
+				return line, nil
+			}
+		}
+		newLine := s.newLine
+		s.mtx.Unlock()
+
+		select {
+		case <-newLine:
+		case <-deadline:
+			return "", ErrTimeout
+		}
+	}
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &Conn{server: s, conn: conn, capabilities: map[string]bool{}, channels: map[string]bool{}}
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns = append(s.conns, c)
+		s.mtx.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c *Conn) {
+	defer func() {
+		c.conn.Close()
+		s.mtx.Lock()
+		for i, conn := range s.conns {
+			if conn == c {
+				s.conns = append(s.conns[:i:i], s.conns[i+1:]...)
+				break
+			}
+		}
+		s.mtx.Unlock()
+	}()
+
+	tp := textproto.NewReader(bufio.NewReader(c.conn))
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		s.record(c, line)
+
+		s.mtx.Lock()
+		handler := s.handler
+		s.mtx.Unlock()
+		if handler != nil {
+			if answer, ok := handler(line); ok {
+				for _, answerLine := range answer {
+					c.write(answerLine)
+				}
+				continue
+			}
+		}
+
+		if !s.answer(c, line) {
+			return
+		}
+	}
+}
+
+// record stores line sent on c and wakes up WaitFor
+func (s *Server) record(c *Conn, line string) {
+	s.mtx.Lock()
+	s.received = append(s.received, line)
+	c.received = append(c.received, line)
+	close(s.newLine)
+	s.newLine = make(chan struct{})
+	s.mtx.Unlock()
+}
+
+// answer replies to a line like twitch would, returns false if the connection should be closed
+func (s *Server) answer(c *Conn, line string) bool {
+	command, params := splitCommand(line)
+
+	switch command {
+	case "PASS":
+		c.pass = params
+	case "NICK":
+		s.mtx.Lock()
+		c.nick = strings.ToLower(params)
+		s.mtx.Unlock()
+		return s.login(c)
+	case "CAP":
+		s.answerCap(c, params)
+	case "PING":
+		c.write(":tmi.twitch.tv PONG tmi.twitch.tv " + params)
+	case "JOIN":
+		for _, channel := range strings.Split(params, ",") {
+			s.join(c, strings.TrimPrefix(channel, "#"))
+		}
+	case "PART":
+		for _, channel := range strings.Split(params, ",") {
+			channel = strings.TrimPrefix(channel, "#")
+			s.mtx.Lock()
+			delete(c.channels, channel)
+			s.mtx.Unlock()
+			c.write(fmt.Sprintf(":%s!%s@%s.tmi.twitch.tv PART #%s", c.nick, c.nick, c.nick, channel))
+		}
+	case "PRIVMSG":
+		s.mtx.Lock()
+		rateLimited := s.rateLimited
+		s.mtx.Unlock()
+
+		target, text := params, ""
+		if i := strings.Index(params, " :"); i >= 0 {
+			target, text = params[:i], params[i+2:]
+		}
+		if target == "#jtv" {
+			break
+		}
+		if rateLimited {
+			c.write(fmt.Sprintf("@msg-id=msg_ratelimit :tmi.twitch.tv NOTICE %s :Your message was not sent because you are sending messages too quickly.", target))
+			break
+		}
+		// twitch answers an accepted chat message with a USERSTATE, commands other than /me are answered with a NOTICE
+		isCommand := strings.HasPrefix(text, "/") || strings.HasPrefix(text, ".")
+		isAction := strings.HasPrefix(text, "/me ") || strings.HasPrefix(text, ".me ")
+		if (!isCommand || isAction) && c.capabilities[commandsCapability] {
+			c.write(c.userstate(strings.TrimPrefix(target, "#")))
+		}
+	}
+
+	return true
+}
+
+// login welcomes the client, or rejects it the way twitch does
+func (s *Server) login(c *Conn) bool {
+	s.mtx.Lock()
+	failAuth := s.failAuth || s.token != "" && c.pass != s.token
+	s.mtx.Unlock()
+
+	anonymous := strings.HasPrefix(c.nick, "justinfan") && c.pass == ""
+	if !anonymous && !strings.HasPrefix(c.pass, "oauth:") {
+		c.write(":tmi.twitch.tv NOTICE * :Improperly formatted auth")
+		return false
+	}
+	if !anonymous && failAuth {
+		c.write(":tmi.twitch.tv NOTICE * :Login authentication failed")
+		return false
+	}
+
+	c.write(fmt.Sprintf(":tmi.twitch.tv 001 %s :Welcome, GLHF!", c.nick))
+	c.write(fmt.Sprintf(":tmi.twitch.tv 002 %s :Your host is tmi.twitch.tv", c.nick))
+	c.write(fmt.Sprintf(":tmi.twitch.tv 003 %s :This server is rather new", c.nick))
+	c.write(fmt.Sprintf(":tmi.twitch.tv 004 %s :-", c.nick))
+	c.write(fmt.Sprintf(":tmi.twitch.tv 375 %s :-", c.nick))
+	c.write(fmt.Sprintf(":tmi.twitch.tv 372 %s :You are in a maze of twisty passages, all alike.", c.nick))
+	c.write(fmt.Sprintf(":tmi.twitch.tv 376 %s :>", c.nick))
+	return true
+}
+
+// answerCap acknowledges the capabilities twitch knows and rejects all others
+func (s *Server) answerCap(c *Conn, params string) {
+	if !strings.HasPrefix(params, "REQ ") {
+		if strings.HasPrefix(params, "LS") {
+			c.write(fmt.Sprintf(":tmi.twitch.tv CAP * LS :%s %s %s", membershipCapability, tagsCapability, commandsCapability))
+		}
+		return
+	}
+
+	requested := strings.TrimPrefix(strings.TrimPrefix(params, "REQ "), ":")
+	for _, capability := range strings.Fields(requested) {
+		switch capability {
+		case tagsCapability, commandsCapability, membershipCapability:
+		default:
+			c.write(":tmi.twitch.tv CAP * NAK :" + requested)
+			return
+		}
+	}
+
+	for _, capability := range strings.Fields(requested) {
+		c.capabilities[capability] = true
+	}
+	c.write(":tmi.twitch.tv CAP * ACK :" + requested)
+}
+
+// join echoes the JOIN, followed by the USERSTATE, ROOMSTATE and NAMES twitch sends with the requested capabilities
+func (s *Server) join(c *Conn, channel string) {
+	s.mtx.Lock()
+	c.channels[channel] = true
+	s.mtx.Unlock()
+
+	c.write(fmt.Sprintf(":%s!%s@%s.tmi.twitch.tv JOIN #%s", c.nick, c.nick, c.nick, channel))
+
+	if c.capabilities[commandsCapability] {
+		roomstate := fmt.Sprintf(":tmi.twitch.tv ROOMSTATE #%s", channel)
+		if c.capabilities[tagsCapability] {
+			roomstate = "@emote-only=0;followers-only=-1;r9k=0;rituals=0;room-id=0;slow=0;subs-only=0 " + roomstate
+		}
+		c.write(c.userstate(channel))
+		c.write(roomstate)
+	}
+
+	if c.capabilities[membershipCapability] {
+		c.write(fmt.Sprintf(":%s.tmi.twitch.tv 353 %s = #%s :%s", c.nick, c.nick, channel, c.nick))
+		c.write(fmt.Sprintf(":%s.tmi.twitch.tv 366 %s #%s :End of /NAMES list", c.nick, c.nick, channel))
+	}
+}
+
+// userstate returns the USERSTATE of a user without badges in channel
+func (c *Conn) userstate(channel string) string {
+	userstate := fmt.Sprintf(":tmi.twitch.tv USERSTATE #%s", channel)
+	if c.capabilities[tagsCapability] {
+		userstate = fmt.Sprintf("@badge-info=;badges=;color=;display-name=%s;emote-sets=0;mod=0;subscriber=0;user-type= ", c.nick) + userstate
+	}
+	return userstate
+}
+
+// Nick returns the login the client connected with
+func (c *Conn) Nick() string {
+	c.server.mtx.Lock()
+	defer c.server.mtx.Unlock()
+
+	return c.nick
+}
+
+// Channels returns the channels the client joined on this connection, sorted
+func (c *Conn) Channels() []string {
+	c.server.mtx.Lock()
+	defer c.server.mtx.Unlock()
+
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// Received returns every line the client sent on this connection, in the order they arrived
+func (c *Conn) Received() []string {
+	c.server.mtx.Lock()
+	defer c.server.mtx.Unlock()
+
+	return append([]string{}, c.received...)
+}
+
+// Send writes line to this client, the line ending is added
+func (c *Conn) Send(line string) {
+	c.write(line)
+}
+
+// Close closes the connection without notice, like a dropped network connection
+func (c *Conn) Close() {
+	c.conn.Close()
+}
+
+func (c *Conn) write(line string) {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
+	fmt.Fprintf(c.conn, "%s\r\n", line)
+}
+
+// splitCommand splits a client line into its command and the rest
+func splitCommand(line string) (string, string) {
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) == 1 {
+		return strings.ToUpper(parts[0]), ""
+	}
+	return strings.ToUpper(parts[0]), parts[1]
+}
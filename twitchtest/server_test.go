@@ -0,0 +1,284 @@
+package twitchtest_test
+
+import (
+	"testing"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc"
+	"github.com/gempir/go-twitch-irc/twitchtest"
+)
+
+func newClient(server *twitchtest.Server) *twitch.Client {
+	client := twitch.NewClient("justinfan123123", "oauth:123123132")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+
+	return client
+}
+
+func waitFor(t *testing.T, server *twitchtest.Server, prefix string) string {
+	line, err := server.WaitFor(prefix, time.Second*3)
+	if err != nil {
+		t.Fatalf("no line starting with %q received: %s", prefix, err)
+	}
+	return line
+}
+
+func TestClientCanJoinAndReceiveMessages(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	received := make(chan string)
+	joined := make(chan string, 1)
+
+	client := newClient(server)
+	client.Join("gempir")
+	client.OnUserJoin(func(channel, user string) {
+		joined <- user
+	})
+	client.OnNewMessage(func(channel string, user twitch.User, message twitch.Message) {
+		received <- user.Username + ": " + message.Text
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, "JOIN #gempir")
+
+	select {
+	case user := <-joined:
+		if user != "justinfan123123" {
+			t.Fatalf("unexpected JOIN echo for %s", user)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("JOIN was not echoed")
+	}
+
+	server.Send("@id=2a31a9df-d6ff-4840-b211-a2547c7e656e :gempir!gempir@gempir.tmi.twitch.tv PRIVMSG #gempir :hello")
+
+	select {
+	case message := <-received:
+		if message != "gempir: hello" {
+			t.Fatalf("unexpected message %q", message)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("injected message was not received")
+	}
+
+	userlist, err := client.Userlist("gempir")
+	if err != nil || len(userlist) != 1 || userlist[0] != "justinfan123123" {
+		t.Fatalf("unexpected userlist %v, %v", userlist, err)
+	}
+}
+
+func TestServerRecordsClientOutput(t *testing.T) {
+	server := twitchtest.NewTLSServer()
+	defer server.Close()
+
+	client := newClient(server)
+	client.TLS = true
+	client.TLSConfig = server.ClientTLSConfig()
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, "CAP REQ")
+	for client.State() != twitch.StateConnected {
+		time.Sleep(time.Millisecond * 2)
+	}
+	client.Say("gempir", "recorded")
+
+	if line := waitFor(t, server, "PRIVMSG"); line != "PRIVMSG #gempir :recorded" {
+		t.Fatalf("unexpected line %q", line)
+	}
+
+	received := server.Received()
+	if received[0] != "PASS oauth:123123132" || received[1] != "NICK justinfan123123" {
+		t.Fatalf("unexpected login %v", received[:2])
+	}
+}
+
+func TestServerCanAskForReconnect(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newClient(server)
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, "NICK")
+	server.Reconnect()
+	waitFor(t, server, "NICK")
+}
+
+func TestServerCanRejectLogin(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.FailAuth(true)
+
+	client := newClient(server)
+
+	errs := make(chan error)
+	go func() {
+		errs <- client.Connect()
+	}()
+
+	select {
+	case err := <-errs:
+		if err != twitch.ErrLoginAuthenticationFailed {
+			t.Fatalf("expected ErrLoginAuthenticationFailed, got %v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("Connect() did not return")
+	}
+}
+
+func TestServerCanRateLimit(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.RateLimit(true)
+
+	notices := make(chan string)
+
+	client := newClient(server)
+	client.OnNewNoticeMessage(func(channel string, user twitch.User, message twitch.Message) {
+		notices <- message.Tags["msg-id"]
+	})
+	client.Say("gempir", "too fast")
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	select {
+	case msgID := <-notices:
+		if msgID != "msg_ratelimit" {
+			t.Fatalf("unexpected NOTICE %s", msgID)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("no NOTICE received")
+	}
+}
+
+func TestServerAnswersAcceptedMessageWithUserstate(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	userstates := make(chan string, 2)
+
+	client := newClient(server)
+	client.Join("gempir")
+	client.OnNewUserstateMessage(func(channel string, user twitch.User, message twitch.Message) {
+		userstates <- channel
+	})
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	// the first USERSTATE answers the JOIN
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			client.Say("gempir", "hello")
+		}
+		select {
+		case channel := <-userstates:
+			if channel != "gempir" {
+				t.Fatalf("unexpected USERSTATE for %s", channel)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal("no USERSTATE received")
+		}
+	}
+}
+
+func TestServerTracksConnections(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	client := newClient(server)
+	client.Join("gempir")
+	client.Join("pajlada")
+	go client.Connect()
+	defer client.Disconnect()
+
+	waitFor(t, server, "JOIN")
+	waitFor(t, server, "JOIN")
+
+	conns := server.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("expected 1 connection, got %d", len(conns))
+	}
+	conn := conns[0]
+	if conn.Nick() != "justinfan123123" {
+		t.Fatalf("unexpected nick %s", conn.Nick())
+	}
+	if channels := conn.Channels(); len(channels) != 2 || channels[0] != "gempir" || channels[1] != "pajlada" {
+		t.Fatalf("unexpected channels %v", channels)
+	}
+	if received := conn.Received(); len(received) == 0 || received[0] != "PASS oauth:123123132" {
+		t.Fatalf("unexpected received lines %v", received)
+	}
+
+	// the client reconnects after its connection was closed
+	conn.Close()
+	waitFor(t, server, "NICK")
+}
+
+func TestServerCanHandleLines(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.Handle(func(line string) ([]string, bool) {
+		if line != "PRIVMSG #gempir :hello" {
+			return nil, false
+		}
+		return []string{"@msg-id=msg_slowmode :tmi.twitch.tv NOTICE #gempir :This room is in slow mode."}, true
+	})
+
+	notices := make(chan string)
+
+	client := newClient(server)
+	client.OnNewNoticeMessage(func(channel string, user twitch.User, message twitch.Message) {
+		notices <- message.Tags["msg-id"]
+	})
+	client.Say("gempir", "hello")
+
+	go client.Connect()
+	defer client.Disconnect()
+
+	select {
+	case msgID := <-notices:
+		if msgID != "msg_slowmode" {
+			t.Fatalf("unexpected NOTICE %s", msgID)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("handler answer was not received")
+	}
+}
+
+func TestServerAcceptsOnlyToken(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.AcceptToken("oauth:valid")
+
+	client := newClient(server)
+	if err := client.Connect(); err != twitch.ErrLoginAuthenticationFailed {
+		t.Fatalf("expected ErrLoginAuthenticationFailed, got %v", err)
+	}
+
+	connected := make(chan struct{})
+	client = twitch.NewClient("justinfan123123", "oauth:valid")
+	client.IrcAddress = server.Addr
+	client.TLS = false
+	client.SendPings = false
+	client.OnConnect(func() {
+		close(connected)
+	})
+	go client.Connect()
+	defer client.Disconnect()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second * 3):
+		t.Fatal("login with the accepted token failed")
+	}
+}
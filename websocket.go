@@ -0,0 +1,295 @@
+package twitch
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ircTwitchWebSocket twitch irc chat over websockets
+	ircTwitchWebSocket = "wss://irc-ws.chat.twitch.tv:443"
+
+	// magic value from RFC 6455 used to compute Sec-WebSocket-Accept
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// maxWebSocketFrameSize largest frame accepted from the server, IRC lines of twitch stay far below it
+	maxWebSocketFrameSize = 1 << 20
+
+	// webSocketHandshakeTimeout how long the HTTP upgrade of a new connection may take
+	webSocketHandshakeTimeout = time.Second * 10
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+var (
+	// ErrWebSocketHandshake returned from Connect() when the server did not upgrade to a websocket
+	ErrWebSocketHandshake = errors.New("websocket handshake failed")
+
+	// ErrWebSocketFrameTooLarge the server sent a frame larger than 1 MiB, the client reconnects
+	ErrWebSocketFrameTooLarge = errors.New("websocket frame too large")
+)
+
+// WebSocketTransport IRC over websockets, which gets through proxies that block the IRC ports
+// The address is a ws:// or wss:// url, a plain host:port is treated as wss://
+type WebSocketTransport struct {
+	DialContext DialContextFunc
+	TLSConfig   *tls.Config
+}
+
+// Dial connects to address and upgrades the connection to a websocket
+func (t *WebSocketTransport) Dial(address string) (net.Conn, error) {
+	if !strings.Contains(address, "://") {
+		address = "wss://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+
+	conn, err := dialContext(t.DialContext)(context.Background(), "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		if conn, err = tlsHandshake(conn, host, t.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	wsConn, err := webSocketHandshake(conn, u, webSocketHandshakeTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return wsConn, nil
+}
+
+// webSocketHandshake upgrades conn to a websocket, a server not answering within timeout fails it
+func webSocketHandshake(conn net.Conn, u *url.URL, timeout time.Duration) (net.Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	path := u.RequestURI()
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, u.Host, key)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, ErrWebSocketHandshake
+	}
+	conn.SetDeadline(time.Time{})
+
+	return newWebSocketConn(conn, reader, true), nil
+}
+
+// webSocketAccept computes the Sec-WebSocket-Accept header for a Sec-WebSocket-Key
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// webSocketConn exposes the text frames of a websocket as a stream of IRC lines
+type webSocketConn struct {
+	net.Conn
+	reader *bufio.Reader
+	// clients have to mask every frame they send
+	client   bool
+	writeMtx sync.Mutex
+	pending  []byte
+	closed   bool
+}
+
+func newWebSocketConn(conn net.Conn, reader *bufio.Reader, client bool) *webSocketConn {
+	return &webSocketConn{
+		Conn:   conn,
+		reader: reader,
+		client: client,
+	}
+}
+
+func (w *webSocketConn) Read(p []byte) (int, error) {
+	for len(w.pending) == 0 {
+		if err := w.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, w.pending)
+	w.pending = w.pending[n:]
+	return n, nil
+}
+
+// Write sends p as a single text frame
+func (w *webSocketConn) Write(p []byte) (int, error) {
+	if err := w.writeFrame(wsOpText, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame before closing the underlying connection
+func (w *webSocketConn) Close() error {
+	w.writeFrame(wsOpClose, []byte{0x03, 0xE8})
+	return w.Conn.Close()
+}
+
+func (w *webSocketConn) readFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(w.reader, header[:]); err != nil {
+		return err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(w.reader, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(w.reader, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	// the length comes from the server, refuse it before allocating the payload
+	if length > maxWebSocketFrameSize {
+		return ErrWebSocketFrameTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(w.reader, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(w.reader, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	switch opcode {
+	case wsOpPing:
+		return w.writeFrame(wsOpPong, payload)
+	case wsOpPong:
+		return nil
+	case wsOpClose:
+		w.writeFrame(wsOpClose, payload)
+		return io.EOF
+	case wsOpText, wsOpBinary, wsOpContinuation:
+		w.pending = append(w.pending, payload...)
+		// servers may leave out the line ending since a frame already delimits the message
+		if fin && len(w.pending) > 0 && w.pending[len(w.pending)-1] != '\n' {
+			w.pending = append(w.pending, '\r', '\n')
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown websocket opcode %d", opcode)
+}
+
+func (w *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	w.writeMtx.Lock()
+	defer w.writeMtx.Unlock()
+
+	if w.closed {
+		return io.ErrClosedPipe
+	}
+	if opcode == wsOpClose {
+		w.closed = true
+	}
+
+	frame := []byte{0x80 | opcode}
+
+	var maskBit byte
+	if w.client {
+		maskBit = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	if w.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := w.Conn.Write(frame)
+	return err
+}
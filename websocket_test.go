@@ -0,0 +1,151 @@
+package twitch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanComputeWebSocketAccept(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	assertStringsEqual(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestCanReadAndWriteWebSocketFrames(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	client := newWebSocketConn(clientSide, bufio.NewReader(clientSide), true)
+	server := newWebSocketConn(serverSide, bufio.NewReader(serverSide), false)
+
+	long := strings.Repeat("a", 300)
+	go client.Write([]byte("PRIVMSG #gempir :" + long + "\r\n"))
+
+	buf := make([]byte, 1024)
+	var received []byte
+	for !bytes.HasSuffix(received, []byte("\r\n")) {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	assertStringsEqual(t, "PRIVMSG #gempir :"+long+"\r\n", string(received))
+
+	// frames without a line ending still end up as separate lines
+	go server.Write([]byte("PING :tmi.twitch.tv"))
+
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringsEqual(t, "PING :tmi.twitch.tv\r\n", string(buf[:n]))
+
+	go server.Close()
+
+	if _, err := client.Read(buf); err != io.EOF {
+		t.Fatalf("expected io.EOF after close frame, got %v", err)
+	}
+}
+
+func TestCanConnectOverWebSocket(t *testing.T) {
+	testMessage := "@badges=;color=#00FF7F;display-name=Danielps1;emotes=;id=2a31a9df-d6ff-4840-b211-a2547c7e656e;user-id=32591953;user-type= :danielps1!danielps1@danielps1.tmi.twitch.tv PRIVMSG #gempir :over websockets"
+
+	wait := make(chan struct{})
+	var received string
+
+	host := startWebSocketServer(t, false, postMessageOnConnect(testMessage), nothingOnMessage)
+	client := newTestClient(host)
+	client.Transport = &WebSocketTransport{}
+
+	client.OnNewMessage(func(channel string, user User, message Message) {
+		received = message.Text
+		close(wait)
+	})
+
+	go client.Connect()
+
+	select {
+	case <-wait:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no message sent")
+	}
+
+	assertStringsEqual(t, "over websockets", received)
+}
+
+func TestCanSayMessageOverSecureWebSocket(t *testing.T) {
+	waitEnd := make(chan struct{})
+	var received string
+
+	host := startWebSocketServer(t, true, nothingOnConnect, func(message string) {
+		if strings.HasPrefix(message, "PRIVMSG") {
+			received = message
+			close(waitEnd)
+		}
+	})
+
+	// the TLSConfig of the client is passed on to the transport
+	client := newTestClient(host)
+	client.Transport = &WebSocketTransport{}
+	client.OnConnect(func() {
+		client.Say("gempir", "hello")
+	})
+
+	go client.Connect()
+
+	select {
+	case <-waitEnd:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no privmsg received")
+	}
+
+	assertStringsEqual(t, "PRIVMSG #gempir :hello", received)
+}
+
+func TestRejectsOversizedWebSocketFrames(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	client := newWebSocketConn(clientSide, bufio.NewReader(clientSide), true)
+
+	// a text frame header announcing 2^62 bytes of payload
+	go serverSide.Write([]byte{0x81, 127, 0x40, 0, 0, 0, 0, 0, 0, 0})
+
+	if _, err := client.Read(make([]byte, 1024)); err != ErrWebSocketFrameTooLarge {
+		t.Fatalf("expected ErrWebSocketFrameTooLarge, got %v", err)
+	}
+}
+
+func TestWebSocketHandshakeTimesOut(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	// the server reads the upgrade request but never answers it
+	go io.Copy(ioutil.Discard, serverSide)
+
+	u, _ := url.Parse("ws://127.0.0.1/")
+	errs := make(chan error, 1)
+	go func() {
+		_, err := webSocketHandshake(clientSide, u, time.Millisecond*50)
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("handshake did not time out")
+	}
+}